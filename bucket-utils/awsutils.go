@@ -2,109 +2,117 @@ package bucketutils
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
-	cfnTypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/aws/aws-sdk-go-v2/service/securityhub"
+	"github.com/aws/smithy-go"
 	"github.com/guardian/fsbp-tools/fsbp-fix/common"
 )
 
-func findFailingBuckets(ctx context.Context, securityHubClient *securityhub.Client, bucketCount int32, accountId string, region string) ([]string, error) {
-	controlId := "S3.8"
-	fmt.Printf("Retrieving Security Hub control failures for %s, in %s\n", controlId, region)
-	findings, err := common.ReturnFindings(ctx, securityHubClient, controlId, bucketCount, accountId, region)
-	if err != nil {
-		return nil, err
-	}
-
-	var bucketsToBlock []string
-	for _, finding := range findings {
-		for _, resource := range finding.Resources {
-			bucketsToBlock = append(bucketsToBlock, strings.TrimPrefix(*resource.Id, "arn:aws:s3:::"))
-		}
+// bucketNames turns the ARNs of failing buckets into bucket names.
+func bucketNames(arns []string) []string {
+	var names []string
+	for _, arn := range arns {
+		names = append(names, strings.TrimPrefix(arn, "arn:aws:s3:::"))
 	}
-
-	return bucketsToBlock, nil
+	return names
 }
 
-func getAllStackSummaries(ctx context.Context, cfnClient *cloudformation.Client) ([]cfnTypes.StackSummary, error) {
-	var allStackSummaries []cfnTypes.StackSummary
+// RegionBuckets is everything we need to know about a region to decide which
+// buckets to block. It is gathered concurrently and printed afterwards.
+type RegionBuckets struct {
+	Region          string
+	FailingBuckets  []string
+	BucketsInStacks []common.StackResources
+	ManagedInCode   []common.ManagedBy // Managed by IaC other than CloudFormation
+	StackPatches    []common.StackPatch
+	Err             error
+}
 
-	input := &cloudformation.ListStacksInput{}
-	stackPaginator := cloudformation.NewListStacksPaginator(cfnClient, input)
-	for stackPaginator.HasMorePages() {
-		page, err := stackPaginator.NextPage(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to list stacks: %w", err)
-		}
-		allStackSummaries = append(allStackSummaries, page.StackSummaries...)
+func getBucketTags(ctx context.Context, s3Client *s3.Client, bucket string) (map[string]string, error) {
+	resp, err := s3Client.GetBucketTagging(ctx, &s3.GetBucketTaggingInput{Bucket: &bucket})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchTagSet" {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags for bucket %s: %w", bucket, err)
 	}
 
-	fmt.Println("Found " + fmt.Sprint(len(allStackSummaries)) + " stacks.")
-	return allStackSummaries, nil
+	tags := map[string]string{}
+	for _, tag := range resp.TagSet {
+		tags[*tag.Key] = *tag.Value
+	}
+	return tags, nil
 }
 
-func FindBucketsInStack(summaries []cfnTypes.StackResourceSummary, stackName string) []string {
+func findBucketsManagedInCode(ctx context.Context, s3Client *s3.Client, detectors common.ManagedByDetectors, buckets []string, concurrency int) ([]common.ManagedBy, error) {
+	type tagResult struct {
+		resource common.ManagedResource
+		err      error
+	}
+	results := common.ParallelMap(ctx, concurrency, buckets, func(ctx context.Context, bucket string) tagResult {
+		tags, err := getBucketTags(ctx, s3Client, bucket)
+		return tagResult{
+			resource: common.ManagedResource{Id: bucket, Type: common.ResourceTypeS3Bucket, Tags: tags},
+			err:      err,
+		}
+	})
 
-	var buckets []string
-	for _, resource := range summaries {
-		if *resource.ResourceType == "AWS::S3::Bucket" {
-			buckets = append(buckets, *resource.PhysicalResourceId)
+	var resources []common.ManagedResource
+	var errs []error
+	for _, res := range results {
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
 		}
+		resources = append(resources, res.resource)
 	}
-	if len(buckets) > 0 {
-		fmt.Printf("\nStack: %s - Buckets: %v", stackName, buckets)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
-	return buckets
+	return detectors.Detect(resources), nil
 }
 
-func getAllStackResources(ctx context.Context, cfnClient *cloudformation.Client, stackName string) ([]cfnTypes.StackResourceSummary, error) {
-
-	allStackResources := []cfnTypes.StackResourceSummary{}
-	stackResourcePaginator := cloudformation.NewListStackResourcesPaginator(cfnClient, &cloudformation.ListStackResourcesInput{StackName: &stackName})
+func FindRegionBuckets(ctx context.Context, s3Client *s3.Client, cfnClient *cloudformation.Client, opts common.Options, failing common.FailingResources, accountId string) RegionBuckets {
+	region := failing.Region
+	failingBuckets := bucketNames(failing.Arns)
 
-	for stackResourcePaginator.HasMorePages() { // We fetch 1MB at a time, so more than one page is unlikely
-		page, err := stackResourcePaginator.NextPage(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get stack resources for stack %s: %w", stackName, err)
-		}
-		allStackResources = append(allStackResources, page.StackResourceSummaries...)
+	bucketsInStacks, err := opts.StackLookup.FindResourcesInStacks(ctx, cfnClient, accountId, region, common.ResourceTypeS3Bucket, failingBuckets, opts.Concurrency)
+	if err != nil {
+		return RegionBuckets{Region: region, Err: fmt.Errorf("could not determine which buckets are in CloudFormation stacks: %w", err)}
 	}
 
-	return allStackResources, nil
-}
-
-func listBucketsInStacks(ctx context.Context, cfnClient *cloudformation.Client) []string {
-	allStackSummaries, _ := getAllStackSummaries(ctx, cfnClient)
-	var bucketsInAStack []string
-
-	for _, stack := range allStackSummaries {
-		if stack.StackStatus != cfnTypes.StackStatusDeleteComplete {
-			stackResourceSummaries, _ := getAllStackResources(ctx, cfnClient, *stack.StackName)
-			buckets := FindBucketsInStack(stackResourceSummaries, *stack.StackName)
-			bucketsInAStack = append(bucketsInAStack, buckets...)
-		}
+	notInStacks := common.Without(failingBuckets, common.ResourcesInStacks(bucketsInStacks))
+	managedInCode, err := findBucketsManagedInCode(ctx, s3Client, opts.Detectors, notInStacks, opts.Concurrency)
+	if err != nil {
+		return RegionBuckets{Region: region, Err: fmt.Errorf("could not determine which buckets are managed in code: %w", err)}
 	}
-	fmt.Println("") //Tidy up the log output
-	return bucketsInAStack
-}
 
-func FindBucketsToBlock(ctx context.Context, securityHubClient *securityhub.Client, s3Client *s3.Client, cfnClient *cloudformation.Client, bucketCount int32, exclusions []string, accountId string, region string) ([]string, error) {
-	failingBuckets, err := findFailingBuckets(ctx, securityHubClient, bucketCount, accountId, region)
-	if err != nil {
-		return nil, err
+	return RegionBuckets{
+		Region:          region,
+		FailingBuckets:  failingBuckets,
+		BucketsInStacks: bucketsInStacks,
+		ManagedInCode:   managedInCode,
+		StackPatches:    common.BuildStackPatches(ctx, cfnClient, region, bucketsInStacks, PublicAccessBlockPatch, opts.Concurrency),
 	}
+}
 
+func FindBucketsToBlock(regionBuckets RegionBuckets, exclusions []string) []string {
+	failingBuckets := regionBuckets.FailingBuckets
 	failingBucketCount := len(failingBuckets)
-	excludedBuckets := append(listBucketsInStacks(ctx, cfnClient), exclusions...)
+
+	excludedBuckets := common.PrintExcluded("buckets", nil, regionBuckets.BucketsInStacks, regionBuckets.ManagedInCode)
+	excludedBuckets = append(excludedBuckets, exclusions...)
 
 	if len(excludedBuckets) > 0 {
-		fmt.Println("\nBuckets to exclude:")
+		fmt.Fprintln(common.Out, "\nBuckets to exclude:")
 	}
 	bucketsToBlock := common.Complement(failingBuckets, excludedBuckets)
 
@@ -112,17 +120,25 @@ func FindBucketsToBlock(ctx context.Context, securityHubClient *securityhub.Clie
 	bucketsToSkipCount := failingBucketCount - bucketsToBlockCount
 
 	if len(bucketsToBlock) > 0 {
-		fmt.Println("\nBlocking the following buckets:")
+		fmt.Fprintln(common.Out, "\nBlocking the following buckets:")
 		for idx, bucket := range bucketsToBlock {
-			fmt.Println(idx+1, bucket)
+			fmt.Fprintln(common.Out, idx+1, bucket)
 		}
-		fmt.Print("\n")
+		fmt.Fprint(common.Out, "\n")
 	}
 
-	fmt.Println(failingBucketCount, "failing buckets found.")
-	fmt.Println(bucketsToBlockCount, "to block, and", bucketsToSkipCount, "to skip.")
-	return bucketsToBlock, nil
+	fmt.Fprintln(common.Out, failingBucketCount, "failing buckets found.")
+	fmt.Fprintln(common.Out, bucketsToBlockCount, "to block, and", bucketsToSkipCount, "to skip.")
+	return bucketsToBlock
+
+}
 
+// publicAccessBlockParameters are what blockPublicAccess sets, for the audit log.
+var publicAccessBlockParameters = map[string]any{
+	"BlockPublicAcls":       true,
+	"IgnorePublicAcls":      true,
+	"BlockPublicPolicy":     true,
+	"RestrictPublicBuckets": true,
 }
 
 func blockPublicAccess(ctx context.Context, s3Client *s3.Client, name string) (*s3.PutPublicAccessBlockOutput, error) {
@@ -138,26 +154,6 @@ func blockPublicAccess(ctx context.Context, s3Client *s3.Client, name string) (*
 	if err != nil {
 		return resp, err
 	}
-	fmt.Println("Public access blocked for bucket: " + name)
+	slog.Info("Blocked public access", "bucket", name)
 	return resp, nil
 }
-
-func BlockBuckets(ctx context.Context, s3Client *s3.Client, bucketsToBlock []string, execute bool) {
-	if execute {
-		userConfirmed := common.UserConfirmation()
-		if userConfirmed {
-			for _, name := range bucketsToBlock {
-				_, err := blockPublicAccess(ctx, s3Client, name)
-				if err != nil {
-					fmt.Println("Error blocking public access: " + err.Error())
-				}
-			}
-			fmt.Println("Public access blocked for all buckets. Please note it may take 24 hours for SecurityHub to update.")
-		} else {
-			fmt.Println("Exiting without blocking public access.")
-		}
-	} else {
-		fmt.Println("\nSkipping execution.")
-		fmt.Println("Re-run with flag -execute to block access.")
-	}
-}
@@ -2,31 +2,44 @@ package bucketutils
 
 import (
 	"context"
-	"log"
 
-	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/securityhub"
 	"github.com/guardian/fsbp-tools/fsbp-fix/common"
 )
 
-func FixS3_8(ctx context.Context, profile string, region string, bucketCount int, exclusions []string, execute bool) {
-
-	cfg, err := common.Auth(ctx, profile, region)
-	common.ExitOnError(err, "Failed to authenticate with AWS for region "+region)
-
-	accountDetails, err := common.GetAccountDetails(ctx, profile, region)
-	if err != nil {
-		log.Fatalf("Error getting account ID: %v", err)
-	}
-
-	securityHubClient := securityhub.NewFromConfig(cfg)
-	s3Client := s3.NewFromConfig(cfg)
-	cfnClient := cloudformation.NewFromConfig(cfg)
-	bucketsToBlock, err := FindBucketsToBlock(ctx, securityHubClient, s3Client, cfnClient, int32(bucketCount), exclusions, accountDetails.AccountId, cfg.Region)
-	if err != nil {
-		log.Fatalf("Error working out which buckets need blocking: %v", err)
-	}
-
-	BlockBuckets(ctx, s3Client, bucketsToBlock, execute)
+// FixS3_8 blocks public access to failing buckets in every region, other than
+// those in exclusions. A canary also watches the error rates of CloudFront
+// distributions serving the canary buckets.
+func FixS3_8(ctx context.Context, sess *common.Session, opts common.Options, exclusions []string) (common.Result, error) {
+	return common.Remediate(ctx, sess, opts, common.Remediation[RegionBuckets, string]{
+		ControlId: "S3.8",
+		Noun:      "buckets",
+		Action:    "block public access",
+		Evaluate:  EvaluateS3_8(sess, opts.Concurrency),
+		Find: func(ctx context.Context, f common.FailingResources) (RegionBuckets, error) {
+			regionBuckets := FindRegionBuckets(ctx, sess.S3(f.Region), sess.CloudFormation(f.Region), opts, f, sess.AccountId)
+			return regionBuckets, regionBuckets.Err
+		},
+		Plan: func(regionBuckets RegionBuckets) ([]string, []common.StackPatch) {
+			return FindBucketsToBlock(regionBuckets, exclusions), regionBuckets.StackPatches
+		},
+		Apply: func(ctx context.Context, change common.Change[string]) error {
+			_, err := blockPublicAccess(ctx, sess.S3(change.Region), change.Item)
+			return err
+		},
+		Audit: func(bucket string) (string, string, map[string]any) {
+			return "s3:PutPublicAccessBlock", bucket, publicAccessBlockParameters
+		},
+		Verify: func(ctx context.Context, change common.Change[string]) (bool, error) {
+			config, err := getPublicAccessBlock(ctx, sess.S3(change.Region), change.Item)
+			return blocksPublicAccess(config), err
+		},
+		Id: func(bucket string) string { return bucket },
+		Checks: func(canary []common.Change[string]) []common.HealthCheck {
+			var buckets []string
+			for _, change := range canary {
+				buckets = append(buckets, change.Item)
+			}
+			return []common.HealthCheck{&CloudFrontErrorCheck{Sess: sess, Buckets: buckets}}
+		},
+	})
 }
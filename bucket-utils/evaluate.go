@@ -0,0 +1,74 @@
+package bucketutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// blocksPublicAccess reports whether a bucket-level configuration passes
+// S3.8, which needs every setting turned on.
+func blocksPublicAccess(config *s3Types.PublicAccessBlockConfiguration) bool {
+	return config != nil &&
+		aws.ToBool(config.BlockPublicAcls) &&
+		aws.ToBool(config.IgnorePublicAcls) &&
+		aws.ToBool(config.BlockPublicPolicy) &&
+		aws.ToBool(config.RestrictPublicBuckets)
+}
+
+func getPublicAccessBlock(ctx context.Context, s3Client *s3.Client, bucket string) (*s3Types.PublicAccessBlockConfiguration, error) {
+	resp, err := s3Client.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{Bucket: &bucket})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchPublicAccessBlockConfiguration" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public access block for bucket %s: %w", bucket, err)
+	}
+	return resp.PublicAccessBlockConfiguration, nil
+}
+
+// EvaluateS3_8 finds the buckets in a region which don't block public access,
+// without relying on Security Hub.
+func EvaluateS3_8(sess *common.Session, concurrency int) common.Evaluator {
+	return func(ctx context.Context, region string) ([]string, error) {
+		s3Client := sess.S3(region)
+		var buckets []string
+		paginator := s3.NewListBucketsPaginator(s3Client, &s3.ListBucketsInput{BucketRegion: &region})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list buckets: %w", err)
+			}
+			for _, bucket := range page.Buckets {
+				buckets = append(buckets, aws.ToString(bucket.Name))
+			}
+		}
+
+		type result struct {
+			passes bool
+			err    error
+		}
+		results := common.ParallelMap(ctx, concurrency, buckets, func(ctx context.Context, bucket string) result {
+			config, err := getPublicAccessBlock(ctx, s3Client, bucket)
+			return result{passes: blocksPublicAccess(config), err: err}
+		})
+
+		var failing []string
+		var errs []error
+		for i, res := range results {
+			if res.err != nil {
+				errs = append(errs, res.err)
+			} else if !res.passes {
+				failing = append(failing, "arn:aws:s3:::"+buckets[i])
+			}
+		}
+		return failing, errors.Join(errs...)
+	}
+}
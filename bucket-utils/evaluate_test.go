@@ -0,0 +1,34 @@
+package bucketutils
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestBlocksPublicAccess(t *testing.T) {
+	all := &s3Types.PublicAccessBlockConfiguration{
+		BlockPublicAcls:       aws.Bool(true),
+		IgnorePublicAcls:      aws.Bool(true),
+		BlockPublicPolicy:     aws.Bool(true),
+		RestrictPublicBuckets: aws.Bool(true),
+	}
+	partial := *all
+	partial.RestrictPublicBuckets = aws.Bool(false)
+
+	cases := []struct {
+		name     string
+		config   *s3Types.PublicAccessBlockConfiguration
+		expected bool
+	}{
+		{"all settings on", all, true},
+		{"one setting off", &partial, false},
+		{"no configuration", nil, false},
+	}
+	for _, c := range cases {
+		if got := blocksPublicAccess(c.config); got != c.expected {
+			t.Errorf("Error evaluating %s. Expected %v, got %v", c.name, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package bucketutils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// errorRateIncrease is how many percentage points a distribution's total error
+// rate, counting 4xx and 5xx errors together, may rise by during a canary
+// before we consider it broken.
+const errorRateIncrease = 5.0
+
+// CloudFrontErrorCheck watches the error rates of CloudFront distributions
+// serving the canary buckets, which is where blocking a bucket that is still
+// meant to be public shows up first.
+type CloudFrontErrorCheck struct {
+	Sess    *common.Session
+	Buckets []string
+
+	distributions map[string]string // ID to domain name
+	baseline      map[string]float64
+	since         time.Time
+}
+
+func (c *CloudFrontErrorCheck) Name() string {
+	return "CloudFront error rates"
+}
+
+// isBucketOrigin reports whether an origin domain is one of the bucket's S3
+// endpoints, e.g. bucket.s3.eu-west-1.amazonaws.com or a website endpoint.
+func isBucketOrigin(domain string, bucket string) bool {
+	return strings.HasPrefix(domain, bucket+".s3.") || strings.HasPrefix(domain, bucket+".s3-")
+}
+
+func (c *CloudFrontErrorCheck) findDistributions(ctx context.Context) error {
+	c.distributions = map[string]string{}
+	paginator := cloudfront.NewListDistributionsPaginator(c.Sess.CloudFront(), &cloudfront.ListDistributionsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list CloudFront distributions: %w", err)
+		}
+		if page.DistributionList == nil {
+			continue
+		}
+		for _, dist := range page.DistributionList.Items {
+			if dist.Origins == nil {
+				continue
+			}
+			for _, origin := range dist.Origins.Items {
+				for _, bucket := range c.Buckets {
+					if isBucketOrigin(aws.ToString(origin.DomainName), bucket) {
+						c.distributions[aws.ToString(dist.Id)] = aws.ToString(dist.DomainName)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// metricStatistics is the part of the CloudWatch client errorRate uses.
+type metricStatistics interface {
+	GetMetricStatistics(ctx context.Context, params *cloudwatch.GetMetricStatisticsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricStatisticsOutput, error)
+}
+
+// errorRate is the percentage of a distribution's requests that failed
+// between start and end: the sum of its average 4xx and 5xx error rates, as
+// each counts a different share of the requests.
+func errorRate(ctx context.Context, cw metricStatistics, distributionId string, start time.Time, end time.Time) (float64, error) {
+	period := int32(max(end.Sub(start).Round(time.Minute), time.Minute).Seconds())
+	total := 0.0
+	for _, metric := range []string{"4xxErrorRate", "5xxErrorRate"} {
+		resp, err := cw.GetMetricStatistics(ctx, &cloudwatch.GetMetricStatisticsInput{
+			Namespace:  aws.String("AWS/CloudFront"),
+			MetricName: aws.String(metric),
+			Dimensions: []cwTypes.Dimension{
+				{Name: aws.String("DistributionId"), Value: aws.String(distributionId)},
+				{Name: aws.String("Region"), Value: aws.String("Global")},
+			},
+			StartTime:  aws.Time(start),
+			EndTime:    aws.Time(end),
+			Period:     aws.Int32(period),
+			Statistics: []cwTypes.Statistic{cwTypes.StatisticAverage},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to get %s for distribution %s: %w", metric, distributionId, err)
+		}
+		for _, point := range resp.Datapoints {
+			total += aws.ToFloat64(point.Average) / float64(len(resp.Datapoints))
+		}
+	}
+	return total, nil
+}
+
+func (c *CloudFrontErrorCheck) Baseline(ctx context.Context) error {
+	if err := c.findDistributions(ctx); err != nil {
+		return err
+	}
+	// CloudFront publishes its metrics in us-east-1
+	cw := c.Sess.CloudWatch("us-east-1")
+	c.since = time.Now()
+	c.baseline = map[string]float64{}
+	for id := range c.distributions {
+		rate, err := errorRate(ctx, cw, id, c.since.Add(-time.Hour), c.since)
+		if err != nil {
+			return err
+		}
+		c.baseline[id] = rate
+	}
+	return nil
+}
+
+func (c *CloudFrontErrorCheck) Problems(ctx context.Context) ([]string, error) {
+	cw := c.Sess.CloudWatch("us-east-1")
+	var problems []string
+	for id, domain := range c.distributions {
+		rate, err := errorRate(ctx, cw, id, c.since, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if rate > c.baseline[id]+errorRateIncrease {
+			problems = append(problems, fmt.Sprintf("Distribution %s (%s) error rate rose from %.1f%% to %.1f%%", id, domain, c.baseline[id], rate))
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,61 @@
+package bucketutils
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+func TestIsBucketOrigin(t *testing.T) {
+	cases := map[string]bool{
+		"my-bucket.s3.amazonaws.com":                         true,
+		"my-bucket.s3.eu-west-1.amazonaws.com":               true,
+		"my-bucket.s3-website-eu-west-1.amazonaws.com":       true,
+		"my-bucket-logs.s3.amazonaws.com":                    false,
+		"example.com":                                        false,
+		"other.my-bucket.s3.eu-west-1.amazonaws.com.example": false,
+	}
+	for domain, expected := range cases {
+		if got := isBucketOrigin(domain, "my-bucket"); got != expected {
+			t.Errorf("Error matching origin %s. Expected %v, got %v", domain, expected, got)
+		}
+	}
+}
+
+// fakeMetrics returns the given averages as the datapoints of each metric.
+type fakeMetrics map[string][]float64
+
+func (f fakeMetrics) GetMetricStatistics(_ context.Context, params *cloudwatch.GetMetricStatisticsInput, _ ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	var points []cwTypes.Datapoint
+	for _, average := range f[aws.ToString(params.MetricName)] {
+		points = append(points, cwTypes.Datapoint{Average: aws.Float64(average)})
+	}
+	return &cloudwatch.GetMetricStatisticsOutput{Datapoints: points}, nil
+}
+
+func TestErrorRate(t *testing.T) {
+	cases := map[string]struct {
+		metrics  fakeMetrics
+		expected float64
+	}{
+		"no traffic":   {fakeMetrics{}, 0},
+		"4xx only":     {fakeMetrics{"4xxErrorRate": {2, 4}}, 3},
+		"4xx and 5xx":  {fakeMetrics{"4xxErrorRate": {2, 4}, "5xxErrorRate": {1, 1, 4}}, 5},
+		"missing 5xxs": {fakeMetrics{"4xxErrorRate": {1}, "5xxErrorRate": {}}, 1},
+	}
+	end := time.Now()
+	for name, c := range cases {
+		rate, err := errorRate(context.Background(), c.metrics, "E123", end.Add(-time.Hour), end)
+		if err != nil {
+			t.Fatalf("Error getting error rate for %s: %v", name, err)
+		}
+		if math.Abs(rate-c.expected) > 1e-9 {
+			t.Errorf("Error getting error rate for %s. Expected %v, got %v", name, c.expected, rate)
+		}
+	}
+}
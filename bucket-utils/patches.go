@@ -0,0 +1,16 @@
+package bucketutils
+
+import (
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+var publicAccessBlockConfiguration = map[string]any{
+	"BlockPublicAcls":       true,
+	"BlockPublicPolicy":     true,
+	"IgnorePublicAcls":      true,
+	"RestrictPublicBuckets": true,
+}
+
+// PublicAccessBlockPatch proposes the template change that blocks public
+// access to a stack-managed bucket.
+var PublicAccessBlockPatch = common.PropertyPatch("PublicAccessBlockConfiguration", publicAccessBlockConfiguration)
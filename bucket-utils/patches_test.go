@@ -0,0 +1,50 @@
+package bucketutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/commontest"
+)
+
+func TestPatchBucketWithoutProperties(t *testing.T) {
+	template := commontest.Template(t, `
+Resources:
+  Bucket:
+    Type: AWS::S3::Bucket
+`)
+	result := PublicAccessBlockPatch(template, "Bucket")
+	expected := []common.PatchOp{{
+		Op:    "add",
+		Path:  "/Resources/Bucket/Properties",
+		Value: map[string]any{"PublicAccessBlockConfiguration": publicAccessBlockConfiguration},
+	}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Error patching bucket without properties. Expected %v, got %v", expected, result)
+	}
+}
+
+func TestPatchBucketWithProperties(t *testing.T) {
+	template := commontest.Template(t, `{"Resources": {"Bucket": {"Type": "AWS::S3::Bucket", "Properties": {"BucketName": "my-bucket"}}}}`)
+	result := PublicAccessBlockPatch(template, "Bucket")
+	if len(result) != 1 || result[0].Op != "add" || result[0].Path != "/Resources/Bucket/Properties/PublicAccessBlockConfiguration" {
+		t.Errorf("Error patching bucket with properties. Got %v", result)
+	}
+}
+
+func TestPatchBucketWithPartialPublicAccessBlock(t *testing.T) {
+	template := commontest.Template(t, `
+Resources:
+  Bucket:
+    Type: AWS::S3::Bucket
+    Properties:
+      BucketName: !Sub "${AWS::StackName}-bucket"
+      PublicAccessBlockConfiguration:
+        BlockPublicAcls: true
+`)
+	result := PublicAccessBlockPatch(template, "Bucket")
+	if len(result) != 1 || result[0].Op != "replace" {
+		t.Errorf("Error patching bucket with partial public access block. Got %v", result)
+	}
+}
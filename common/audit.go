@@ -0,0 +1,167 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// AuditEntry records one mutating API call.
+type AuditEntry struct {
+	Time       time.Time      `json:"time"`
+	Operator   string         `json:"operator"` // ARN of the caller
+	AccountId  string         `json:"accountId"`
+	Region     string         `json:"region"`
+	Action     string         `json:"action"` // e.g. s3:PutPublicAccessBlock
+	Resource   string         `json:"resource"`
+	Parameters map[string]any `json:"parameters,omitempty"`
+	Outcome    string         `json:"outcome"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// AuditLog appends every change the tool makes to a JSONL file, which is
+// never truncated. A nil AuditLog records nothing.
+type AuditLog struct {
+	operator  string
+	accountId string
+
+	mu      sync.Mutex
+	file    *os.File
+	entries []AuditEntry // This run's entries, for uploading
+}
+
+func DefaultAuditLogPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "fsbp-fix", "audit.jsonl")
+}
+
+func OpenAuditLog(path string, sess *Session) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &AuditLog{operator: sess.Arn, accountId: sess.AccountId, file: file}, nil
+}
+
+// Record appends an entry for a mutating call. Failing to write the audit
+// log is logged, but doesn't stop the run, since the change has been made.
+func (a *AuditLog) Record(region string, action string, resource string, parameters map[string]any, err error) {
+	if a == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:       time.Now().UTC(),
+		Operator:   a.operator,
+		AccountId:  a.accountId,
+		Region:     region,
+		Action:     action,
+		Resource:   resource,
+		Parameters: parameters,
+		Outcome:    OutcomeSuccess,
+	}
+	if err != nil {
+		entry.Outcome = OutcomeFailure
+		entry.Error = err.Error()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, entry)
+	line, _ := json.Marshal(entry)
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		WarnOnError(err, "Failed to write audit log entry")
+	}
+}
+
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// parseS3Uri splits s3://bucket/prefix into its bucket and prefix.
+func parseS3Uri(uri string) (string, string, error) {
+	rest, ok := strings.CutPrefix(uri, "s3://")
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if !ok || bucket == "" {
+		return "", "", fmt.Errorf("expected an S3 URI such as s3://bucket/prefix, got %q", uri)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// ValidateAuditS3Uri checks the -audit-s3 flag before the run starts, rather
+// than finding out it is wrong after making changes. Empty means no upload.
+func ValidateAuditS3Uri(uri string) error {
+	if uri == "" {
+		return nil
+	}
+	_, _, err := parseS3Uri(uri)
+	return err
+}
+
+func bucketRegion(ctx context.Context, sess *Session, bucket string) (string, error) {
+	resp, err := sess.S3(defaultRegion).GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: &bucket})
+	if err != nil {
+		return "", fmt.Errorf("failed to find the region of bucket %s: %w", bucket, err)
+	}
+	if resp.LocationConstraint == "" {
+		return defaultRegion, nil // Buckets in us-east-1 have no location constraint
+	}
+	return string(resp.LocationConstraint), nil
+}
+
+// Upload copies this run's entries to S3, as one object per run.
+func (a *AuditLog) Upload(ctx context.Context, sess *Session, uri string) error {
+	if a == nil || len(a.entries) == 0 {
+		return nil
+	}
+	bucket, prefix, err := parseS3Uri(uri)
+	if err != nil {
+		return err
+	}
+	region, err := bucketRegion(ctx, sess, bucket)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	for _, entry := range a.entries {
+		line, _ := json.Marshal(entry)
+		body.Write(append(line, '\n'))
+	}
+	key := fmt.Sprintf("%s/%s.jsonl", a.accountId, a.entries[0].Time.Format("20060102T150405Z"))
+	if prefix != "" {
+		key = prefix + "/" + key
+	}
+	_, err = sess.S3(region).PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(body.Bytes()),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload audit log to s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditLogAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	sess := &Session{Arn: "arn:aws:sts::123456789012:assumed-role/admin/me", AccountId: "123456789012"}
+
+	for i := range 2 {
+		audit, err := OpenAuditLog(path, sess)
+		if err != nil {
+			t.Fatalf("Error opening audit log: %v", err)
+		}
+		var callErr error
+		if i == 1 {
+			callErr = errors.New("AccessDenied")
+		}
+		audit.Record("eu-west-1", "s3:PutPublicAccessBlock", "my-bucket", map[string]any{"BlockPublicAcls": true}, callErr)
+		audit.Close()
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Error reading audit log: %v", err)
+	}
+	defer file.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Error parsing audit log line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("Error appending to audit log. Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Outcome != OutcomeSuccess || entries[0].Operator != sess.Arn || entries[0].Resource != "my-bucket" {
+		t.Errorf("Error recording success. Got %+v", entries[0])
+	}
+	if entries[1].Outcome != OutcomeFailure || entries[1].Error != "AccessDenied" {
+		t.Errorf("Error recording failure. Got %+v", entries[1])
+	}
+}
+
+func TestNilAuditLogRecordsNothing(t *testing.T) {
+	var audit *AuditLog
+	audit.Record("eu-west-1", "s3:PutPublicAccessBlock", "my-bucket", nil, nil)
+	if err := audit.Close(); err != nil {
+		t.Errorf("Error closing nil audit log: %v", err)
+	}
+}
+
+func TestParseS3Uri(t *testing.T) {
+	bucket, prefix, err := parseS3Uri("s3://audit-bucket/fsbp-fix/")
+	if err != nil || bucket != "audit-bucket" || prefix != "fsbp-fix" {
+		t.Errorf("Error parsing S3 URI. Got %s, %s, %v", bucket, prefix, err)
+	}
+	if _, _, err := parseS3Uri("audit-bucket"); err == nil {
+		t.Errorf("Error parsing S3 URI. Expected an error without the s3:// scheme")
+	}
+}
+
+func TestValidateAuditS3Uri(t *testing.T) {
+	cases := map[string]bool{
+		"":                         true,
+		"s3://audit-bucket":        true,
+		"s3://audit-bucket/prefix": true,
+		"audit-bucket":             false,
+		"s3://":                    false,
+		"s3:///prefix":             false,
+		"https://audit-bucket":     false,
+	}
+	for uri, valid := range cases {
+		if err := ValidateAuditS3Uri(uri); (err == nil) != valid {
+			t.Errorf("Error validating %q. Expected valid to be %v, got %v", uri, valid, err)
+		}
+	}
+}
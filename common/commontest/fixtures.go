@@ -0,0 +1,18 @@
+// Package commontest has helpers shared by the tests of each control.
+package commontest
+
+import (
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// Template parses a template, failing the test if it can't.
+func Template(t testing.TB, body string) common.StackTemplate {
+	t.Helper()
+	template, err := common.ParseTemplate(body)
+	if err != nil {
+		t.Fatalf("Error parsing template: %v", err)
+	}
+	return template
+}
@@ -0,0 +1,203 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/securityhub"
+	shTypes "github.com/aws/aws-sdk-go-v2/service/securityhub/types"
+)
+
+// findingsPageSize is the most findings Security Hub will return per call.
+// It is unrelated to how many resources we process.
+const findingsPageSize int32 = 100
+
+func findingsInput(controlId string, accountId string, region string) *securityhub.GetFindingsInput {
+	return &securityhub.GetFindingsInput{
+		MaxResults: aws.Int32(findingsPageSize),
+		Filters: &shTypes.AwsSecurityFindingFilters{
+			ComplianceSecurityControlId: []shTypes.StringFilter{{
+				Value:      &controlId,
+				Comparison: shTypes.StringFilterComparisonEquals,
+			}},
+			ComplianceStatus: []shTypes.StringFilter{{
+				Value:      aws.String("PASSED"),
+				Comparison: shTypes.StringFilterComparisonNotEquals,
+			}},
+			RecordState: []shTypes.StringFilter{{
+				Value:      aws.String("ACTIVE"),
+				Comparison: shTypes.StringFilterComparisonEquals,
+			}},
+			AwsAccountId: []shTypes.StringFilter{{
+				Value:      &accountId,
+				Comparison: shTypes.StringFilterComparisonEquals,
+			}},
+			Region: []shTypes.StringFilter{{
+				Value:      &region,
+				Comparison: shTypes.StringFilterComparisonEquals,
+			}},
+		},
+	}
+}
+
+// FindingsIterator yields each resource failing a control once, however many
+// findings reference it.
+type FindingsIterator struct {
+	paginator *securityhub.GetFindingsPaginator
+	seen      map[string]bool
+	pending   []string
+}
+
+func NewFindingsIterator(securityHubClient *securityhub.Client, controlId string, accountId string, region string) *FindingsIterator {
+	return &FindingsIterator{
+		paginator: securityhub.NewGetFindingsPaginator(securityHubClient, findingsInput(controlId, accountId, region)),
+		seen:      map[string]bool{},
+	}
+}
+
+// add queues the resources in findings that haven't been seen before.
+func (it *FindingsIterator) add(findings []shTypes.AwsSecurityFinding) {
+	for _, finding := range findings {
+		for _, resource := range finding.Resources {
+			arn := aws.ToString(resource.Id)
+			if arn == "" || it.seen[arn] {
+				continue
+			}
+			it.seen[arn] = true
+			it.pending = append(it.pending, arn)
+		}
+	}
+}
+
+// Next returns the ARN of the next failing resource, fetching another page of
+// findings if needed. ok is false once there are none left.
+func (it *FindingsIterator) Next(ctx context.Context) (arn string, ok bool, err error) {
+	for len(it.pending) == 0 {
+		if !it.paginator.HasMorePages() {
+			return "", false, nil
+		}
+		page, err := it.paginator.NextPage(ctx)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to get findings: %w", err)
+		}
+		it.add(page.Findings)
+	}
+	arn, it.pending = it.pending[0], it.pending[1:]
+	return arn, true, nil
+}
+
+// FailingResources are the resources failing a control in one region.
+type FailingResources struct {
+	Region string
+	Arns   []string // The resources to process
+	Total  int      // How many distinct resources are failing
+	// Set if Security Hub was only read up to the limit, so Total is a lower bound
+	Partial bool
+	Err     error
+
+	// Only set when comparing sources. Resources failing in one source but not the other
+	OnlyInSecurityHub []string
+	OnlyInDirect      []string
+}
+
+func (f FailingResources) Skipped() int {
+	return f.Total - len(f.Arns)
+}
+
+// TotalSkipped counts the failing resources -limit held back in every region.
+// They are still failing, so a run that skips any has work remaining.
+func TotalSkipped(failing []FailingResources) int {
+	skipped := 0
+	for _, f := range failing {
+		skipped += f.Skipped()
+	}
+	return skipped
+}
+
+// arnIterator yields distinct ARNs, like FindingsIterator.
+type arnIterator interface {
+	Next(ctx context.Context) (arn string, ok bool, err error)
+}
+
+// readArns reads at most limit ARNs from it, or all of them if limit is 0.
+// more reports whether it stopped with ARNs still to read.
+func readArns(ctx context.Context, it arnIterator, limit int) (arns []string, more bool, err error) {
+	for limit == 0 || len(arns) < limit {
+		arn, ok, err := it.Next(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			return arns, false, nil
+		}
+		arns = append(arns, arn)
+	}
+	_, more, err = it.Next(ctx)
+	return arns, more, err
+}
+
+// securityHubArns reads the resources failing a control, stopping once it has
+// limit of them, so a small -limit doesn't page through every finding. A limit
+// of 0 reads them all.
+func securityHubArns(ctx context.Context, securityHubClient *securityhub.Client, controlId string, accountId string, region string, limit int) ([]string, bool, error) {
+	return readArns(ctx, NewFindingsIterator(securityHubClient, controlId, accountId, region), limit)
+}
+
+// limitArns keeps at most limit of the distinct arns, counting all of them.
+// A limit of 0 keeps them all.
+func limitArns(region string, arns []string, limit int) FailingResources {
+	failing := FailingResources{Region: region}
+	seen := map[string]bool{}
+	for _, arn := range arns {
+		if seen[arn] {
+			continue
+		}
+		seen[arn] = true
+		failing.Total++
+		if limit == 0 || len(failing.Arns) < limit {
+			failing.Arns = append(failing.Arns, arn)
+		}
+	}
+	return failing
+}
+
+// LimitFailingResources keeps at most limit resources across all regions,
+// taking them in region order. A limit of 0 keeps them all.
+func LimitFailingResources(regions []FailingResources, limit int) []FailingResources {
+	if limit == 0 {
+		return regions
+	}
+	limited := make([]FailingResources, len(regions))
+	remaining := limit
+	for i, region := range regions {
+		limited[i] = region
+		limited[i].Arns = region.Arns[:min(len(region.Arns), remaining)]
+		remaining -= len(limited[i].Arns)
+	}
+	return limited
+}
+
+// PrintFailingResources summarises how many resources are failing, and how
+// many of them this run will look at, along with any disagreement between
+// Security Hub and the resources' live configuration.
+func PrintFailingResources(regions []FailingResources, noun string) {
+	total, processing, partial := 0, 0, false
+	for _, region := range regions {
+		total += region.Total
+		processing += len(region.Arns)
+		partial = partial || region.Partial
+		for _, arn := range region.OnlyInSecurityHub {
+			slog.Warn("Security Hub reports a resource as failing, but it passes now. The finding may be out of date", "region", region.Region, "resource", arn)
+		}
+		for _, arn := range region.OnlyInDirect {
+			slog.Warn("Resource is failing, but Security Hub has no finding for it yet", "region", region.Region, "resource", arn)
+		}
+	}
+	if partial {
+		fmt.Fprintf(Out, "Found at least %d failing %s. Processing %d of them.\n", total, noun, processing)
+		return
+	}
+	fmt.Fprintf(Out, "Found %d failing %s. Processing %d of them.\n", total, noun, processing)
+}
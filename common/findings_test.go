@@ -0,0 +1,113 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	shTypes "github.com/aws/aws-sdk-go-v2/service/securityhub/types"
+)
+
+func finding(arns ...string) shTypes.AwsSecurityFinding {
+	var resources []shTypes.Resource
+	for _, arn := range arns {
+		resources = append(resources, shTypes.Resource{Id: aws.String(arn)})
+	}
+	return shTypes.AwsSecurityFinding{Resources: resources}
+}
+
+func TestFindingsIteratorDedupesResources(t *testing.T) {
+	it := &FindingsIterator{seen: map[string]bool{}}
+	it.add([]shTypes.AwsSecurityFinding{finding("arn:aws:s3:::a"), finding("arn:aws:s3:::b", "arn:aws:s3:::a")})
+	it.add([]shTypes.AwsSecurityFinding{finding("arn:aws:s3:::b"), finding("arn:aws:s3:::c")})
+
+	expected := []string{"arn:aws:s3:::a", "arn:aws:s3:::b", "arn:aws:s3:::c"}
+	if !reflect.DeepEqual(it.pending, expected) {
+		t.Errorf("Error deduping findings. Expected %v, got %v", expected, it.pending)
+	}
+}
+
+func TestLimitFailingResources(t *testing.T) {
+	regions := []FailingResources{
+		{Region: "eu-west-1", Arns: []string{"a", "b"}, Total: 2},
+		{Region: "us-east-1", Arns: []string{"c", "d"}, Total: 5},
+		{Region: "us-west-2", Arns: []string{"e"}, Total: 1},
+	}
+	limited := LimitFailingResources(regions, 3)
+
+	if !reflect.DeepEqual(limited[0].Arns, []string{"a", "b"}) || !reflect.DeepEqual(limited[1].Arns, []string{"c"}) || len(limited[2].Arns) != 0 {
+		t.Errorf("Error limiting resources across regions. Got %v", limited)
+	}
+	if limited[1].Skipped() != 4 || limited[2].Skipped() != 1 {
+		t.Errorf("Error counting skipped resources. Got %d and %d", limited[1].Skipped(), limited[2].Skipped())
+	}
+	if len(regions[1].Arns) != 2 {
+		t.Errorf("Error limiting resources. The input was modified")
+	}
+}
+
+func TestLimitFailingResourcesZeroMeansNoLimit(t *testing.T) {
+	regions := []FailingResources{{Region: "eu-west-1", Arns: []string{"a", "b"}, Total: 2}}
+	if limited := LimitFailingResources(regions, 0); !reflect.DeepEqual(limited, regions) {
+		t.Errorf("Error applying no limit. Expected %v, got %v", regions, limited)
+	}
+}
+
+func TestLimitArnsCountsDistinctResources(t *testing.T) {
+	failing := limitArns("eu-west-1", []string{"a", "b", "a", "c"}, 2)
+	if !reflect.DeepEqual(failing.Arns, []string{"a", "b"}) || failing.Total != 3 {
+		t.Errorf("Error limiting ARNs. Expected [a b] of 3, got %v of %d", failing.Arns, failing.Total)
+	}
+}
+
+type fakeArns struct {
+	arns []string
+	read int
+}
+
+func (f *fakeArns) Next(_ context.Context) (string, bool, error) {
+	if f.read == len(f.arns) {
+		return "", false, nil
+	}
+	f.read++
+	return f.arns[f.read-1], true, nil
+}
+
+func TestReadArnsStopsAtLimit(t *testing.T) {
+	cases := []struct {
+		limit    int
+		expected []string
+		more     bool
+		read     int
+	}{
+		{0, []string{"a", "b", "c"}, false, 3},
+		{2, []string{"a", "b"}, true, 3},
+		{3, []string{"a", "b", "c"}, false, 3},
+		{5, []string{"a", "b", "c"}, false, 3},
+	}
+	for _, c := range cases {
+		it := &fakeArns{arns: []string{"a", "b", "c"}}
+		arns, more, err := readArns(context.Background(), it, c.limit)
+		if err != nil || !reflect.DeepEqual(arns, c.expected) || more != c.more {
+			t.Errorf("Error reading ARNs with a limit of %d. Expected %v, %v, got %v, %v, %v", c.limit, c.expected, c.more, arns, more, err)
+		}
+		if it.read != c.read {
+			t.Errorf("Error reading ARNs with a limit of %d. Expected %d reads, got %d", c.limit, c.read, it.read)
+		}
+	}
+}
+
+func TestPrintFailingResourcesWritesToOut(t *testing.T) {
+	var b bytes.Buffer
+	Out = &b
+	defer func() { Out = os.Stdout }()
+
+	PrintFailingResources([]FailingResources{{Region: "eu-west-1", Arns: []string{"a"}, Total: 2, Partial: true}}, "buckets")
+	expected := "Found at least 2 failing buckets. Processing 1 of them.\n"
+	if b.String() != expected {
+		t.Errorf("Error printing failing resources. Expected %q, got %q", expected, b.String())
+	}
+}
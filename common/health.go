@@ -0,0 +1,77 @@
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// AlarmHealthCheck treats any CloudWatch alarm that goes into the ALARM state
+// during the canary as a problem.
+type AlarmHealthCheck struct {
+	Sess    *Session
+	Regions []string
+	Prefix  string // Only consider alarms whose names start with this
+
+	baseline map[string]bool
+}
+
+func (a *AlarmHealthCheck) Name() string {
+	return "CloudWatch alarms"
+}
+
+func (a *AlarmHealthCheck) alarmsInAlarm(ctx context.Context) (map[string]string, error) {
+	alarms := map[string]string{}
+	for _, region := range a.Regions {
+		input := &cloudwatch.DescribeAlarmsInput{
+			StateValue: cwTypes.StateValueAlarm,
+			AlarmTypes: []cwTypes.AlarmType{cwTypes.AlarmTypeMetricAlarm, cwTypes.AlarmTypeCompositeAlarm},
+		}
+		if a.Prefix != "" {
+			input.AlarmNamePrefix = &a.Prefix
+		}
+
+		paginator := cloudwatch.NewDescribeAlarmsPaginator(a.Sess.CloudWatch(region), input)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe alarms in %s: %w", region, err)
+			}
+			for _, alarm := range page.MetricAlarms {
+				alarms[*alarm.AlarmArn] = fmt.Sprintf("%s (%s)", *alarm.AlarmName, region)
+			}
+			for _, alarm := range page.CompositeAlarms {
+				alarms[*alarm.AlarmArn] = fmt.Sprintf("%s (%s)", *alarm.AlarmName, region)
+			}
+		}
+	}
+	return alarms, nil
+}
+
+func (a *AlarmHealthCheck) Baseline(ctx context.Context) error {
+	alarms, err := a.alarmsInAlarm(ctx)
+	if err != nil {
+		return err
+	}
+	a.baseline = map[string]bool{}
+	for arn := range alarms {
+		a.baseline[arn] = true
+	}
+	return nil
+}
+
+func (a *AlarmHealthCheck) Problems(ctx context.Context) ([]string, error) {
+	alarms, err := a.alarmsInAlarm(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var problems []string
+	for arn, name := range alarms {
+		if !a.baseline[arn] {
+			problems = append(problems, "Alarm went off: "+name)
+		}
+	}
+	return problems, nil
+}
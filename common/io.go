@@ -2,32 +2,145 @@ package common
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"strings"
 )
 
-func UserConfirmation() bool {
+// Out is where reports meant for people go. It is stdout, unless stdout is
+// carrying machine-readable output, such as ec2.2's -output json, when it is
+// stderr.
+var Out io.Writer = os.Stdout
+
+var ErrNotInteractive = errors.New("stdin is not a terminal, so changes cannot be confirmed interactively. Pass -yes, or -confirm-account with the account ID, to run non-interactively")
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func UserConfirmation() (bool, error) {
+	if !stdinIsTerminal() {
+		return false, ErrNotInteractive
+	}
 
 	buf := bufio.NewReader(os.Stdin)
-	fmt.Println("Press 'y', to confirm, and enter to continue. Otherwise, hit enter to exit.")
-	fmt.Print("> ")
+	fmt.Fprintln(Out, "Press 'y', to confirm, and enter to continue. Otherwise, hit enter to exit.")
+	fmt.Fprint(Out, "> ")
 	input, err := buf.ReadBytes('\n')
 	if err != nil {
-		fmt.Println("Error reading input: " + err.Error())
+		return false, fmt.Errorf("error reading input: %w", err)
 	}
-	return strings.ToLower(strings.TrimSpace(string(input))) == "y"
+	return strings.ToLower(strings.TrimSpace(string(input))) == "y", nil
 }
 
-func ExitOnError(err error, msg string) {
-	if err != nil {
-		log.Fatalf("%s: %v", msg, err)
+// Confirmation decides whether changes go ahead. Either flag lets the tool
+// run unattended, e.g. in CI. Otherwise the user is asked once per run.
+type Confirmation struct {
+	Yes            bool
+	ConfirmAccount string // Only proceed if this matches the account we're authenticated against
+}
+
+// CheckAccount fails if the user asked to confirm an account other than the
+// one the session resolved, so a wrong profile is caught before any work.
+func (c Confirmation) CheckAccount(accountId string) error {
+	if c.ConfirmAccount != "" && c.ConfirmAccount != accountId {
+		return fmt.Errorf("-confirm-account %s does not match the authenticated account %s", c.ConfirmAccount, accountId)
 	}
+	return nil
+}
+
+// CheckInteractive fails if we would need to ask for confirmation but can't,
+// so a pipeline fails before doing any work rather than at the prompt.
+func (c Confirmation) CheckInteractive() error {
+	if !c.Yes && c.ConfirmAccount == "" && !stdinIsTerminal() {
+		return ErrNotInteractive
+	}
+	return nil
+}
+
+func (c Confirmation) Confirm(accountId string) (bool, error) {
+	if err := c.CheckAccount(accountId); err != nil {
+		return false, err
+	}
+	if c.Yes || c.ConfirmAccount != "" {
+		return true, nil
+	}
+	return UserConfirmation()
+}
+
+// PlannedChanges is the number of changes to make in each region, in order.
+type PlannedChanges struct {
+	Regions []string
+	Counts  []int
+}
+
+func (p *PlannedChanges) Add(region string, count int) {
+	if count > 0 {
+		p.Regions = append(p.Regions, region)
+		p.Counts = append(p.Counts, count)
+	}
+}
+
+func (p PlannedChanges) Total() int {
+	total := 0
+	for _, count := range p.Counts {
+		total += count
+	}
+	return total
+}
+
+// Print summarises the changes across every region, before asking for confirmation.
+func (p PlannedChanges) Print(accountId string, noun string) {
+	fmt.Fprintf(Out, "\nAbout to change %d %s in account %s:\n", p.Total(), noun, accountId)
+	for i, region := range p.Regions {
+		fmt.Fprintf(Out, "  %s: %d\n", region, p.Counts[i])
+	}
+}
+
+// Skipped is a failing resource a run leaves alone, and why.
+type Skipped struct {
+	Name   string
+	Reason string
+}
+
+// PrintExcluded prints the failing resources in a region that this run won't
+// change: those skipped, with why, and those to fix in code, whether in a
+// stack or managed by another tool. It returns the IDs of those to fix in
+// code.
+func PrintExcluded(noun string, skipped []Skipped, inStacks []StackResources, managed []ManagedBy) []string {
+	for _, s := range skipped {
+		fmt.Fprintf(Out, "\nSkipping %s: %s", s.Name, s.Reason)
+	}
+	for _, stack := range inStacks {
+		fmt.Fprintf(Out, "\nStack: %s - %s%s, please fix in code: %v", stack.StackName, strings.ToUpper(noun[:1]), noun[1:], stack.PhysicalIds)
+	}
+	for _, m := range managed {
+		fmt.Fprintf(Out, "\nManaged in code, please fix there: %s (%s)", m.Id, m.Reason)
+	}
+	fmt.Fprintln(Out, "")
+	return append(ResourcesInStacks(inStacks), ManagedIds(managed)...)
 }
 
 func WarnOnError(err error, msg string) {
 	if err != nil {
-		log.Printf("Warning: %s: %v", msg, err)
+		slog.Warn(msg, "error", err)
+	}
+}
+
+// FormatBytes gives a human-readable size, e.g. 1.5 GiB.
+func FormatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
 	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCheckAccountWithoutConfirmAccount(t *testing.T) {
+	if err := (Confirmation{}).CheckAccount("123456789012"); err != nil {
+		t.Errorf("Expected no error when no account to confirm was given, got %v", err)
+	}
+}
+
+func TestCheckAccountMismatch(t *testing.T) {
+	if err := (Confirmation{ConfirmAccount: "210987654321"}).CheckAccount("123456789012"); err == nil {
+		t.Errorf("Expected an error when the account to confirm does not match")
+	}
+}
+
+func TestConfirmWithYes(t *testing.T) {
+	confirmed, err := (Confirmation{Yes: true}).Confirm("123456789012")
+	if !confirmed || err != nil {
+		t.Errorf("Expected -yes to confirm without prompting, got %v, %v", confirmed, err)
+	}
+}
+
+func TestConfirmWithMatchingAccount(t *testing.T) {
+	confirmed, err := (Confirmation{ConfirmAccount: "123456789012"}).Confirm("123456789012")
+	if !confirmed || err != nil {
+		t.Errorf("Expected a matching -confirm-account to confirm without prompting, got %v, %v", confirmed, err)
+	}
+}
+
+func TestConfirmWithMismatchedAccountDespiteYes(t *testing.T) {
+	confirmed, err := (Confirmation{Yes: true, ConfirmAccount: "210987654321"}).Confirm("123456789012")
+	if confirmed || err == nil {
+		t.Errorf("Expected a mismatched -confirm-account to refuse, even with -yes")
+	}
+}
+
+func TestPlannedChangesSkipsEmptyRegions(t *testing.T) {
+	planned := PlannedChanges{}
+	planned.Add("eu-west-1", 2)
+	planned.Add("us-east-1", 0)
+	planned.Add("eu-west-2", 3)
+	evaluateResult(t, planned.Regions, []string{"eu-west-1", "eu-west-2"}, "Error recording planned changes")
+	if planned.Total() != 5 {
+		t.Errorf("Error totalling planned changes. Expected 5, got %d", planned.Total())
+	}
+}
+
+func TestPrintExcluded(t *testing.T) {
+	var b bytes.Buffer
+	Out = &b
+	defer func() { Out = os.Stdout }()
+
+	excluded := PrintExcluded("log groups",
+		[]Skipped{{Name: "/aws/lambda/gone", Reason: "log group no longer exists"}},
+		[]StackResources{{StackName: "app", PhysicalIds: []string{"/app/logs"}}},
+		[]ManagedBy{{Id: "/tf/logs", Reason: "tagged terraform=true"}})
+
+	if expected := []string{"/app/logs", "/tf/logs"}; !reflect.DeepEqual(excluded, expected) {
+		t.Errorf("Error printing excluded resources. Expected %v to fix in code, got %v", expected, excluded)
+	}
+	expected := `
+Skipping /aws/lambda/gone: log group no longer exists
+Stack: app - Log groups, please fix in code: [/app/logs]
+Managed in code, please fix there: /tf/logs (tagged terraform=true)
+`
+	if b.String() != expected {
+		t.Errorf("Error printing excluded resources. Expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[int64]string{
+		0:                      "0 B",
+		1023:                   "1023 B",
+		1536:                   "1.5 KiB",
+		5 * 1024 * 1024 * 1024: "5.0 GiB",
+	}
+	for bytes, expected := range cases {
+		if got := FormatBytes(bytes); got != expected {
+			t.Errorf("Error formatting %d bytes. Expected %s, got %s", bytes, expected, got)
+		}
+	}
+}
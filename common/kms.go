@@ -0,0 +1,34 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsTypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// IsAwsManagedKey reports whether a key is an AWS managed key, e.g.
+// alias/aws/sns. These are created on first use, and their key policies
+// can't be changed to let other services use them.
+func IsAwsManagedKey(keyId string) bool {
+	return strings.HasPrefix(keyId, "alias/aws/")
+}
+
+// CheckEncryptionKey makes sure a customer managed key chosen to encrypt
+// resources exists in a region and is enabled, before anything is pointed at
+// it. AWS managed keys aren't checked.
+func CheckEncryptionKey(ctx context.Context, kmsClient *kms.Client, keyId string) error {
+	if IsAwsManagedKey(keyId) {
+		return nil
+	}
+	resp, err := kmsClient.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: &keyId})
+	if err != nil {
+		return fmt.Errorf("failed to describe key %s: %w", keyId, err)
+	}
+	if resp.KeyMetadata.KeyState != kmsTypes.KeyStateEnabled {
+		return fmt.Errorf("key %s is %s, not enabled", keyId, resp.KeyMetadata.KeyState)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package common
+
+import "testing"
+
+func TestIsAwsManagedKey(t *testing.T) {
+	cases := map[string]bool{
+		"alias/aws/sns":                        true,
+		"alias/aws/sqs":                        true,
+		"alias/my-key":                         false,
+		"1234abcd-12ab-34cd-56ef-1234567890ab": false,
+		"arn:aws:kms:eu-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab": false,
+	}
+	for key, expected := range cases {
+		if got := IsAwsManagedKey(key); got != expected {
+			t.Errorf("Error checking whether %s is AWS managed. Expected %v, got %v", key, expected, got)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// Change is a fix to make to one resource, in one region.
+type Change[T any] struct {
+	Region string
+	Item   T
+}
+
+// Limits cap how many changes a run makes, to bound the damage a mistake can
+// do. Zero means no limit.
+type Limits struct {
+	MaxChanges          int
+	MaxChangesPerRegion int
+}
+
+// ApplyLimits splits changes into those to make in this run, and those
+// deferred to a later one, keeping the original order.
+func ApplyLimits[T any](l Limits, changes []Change[T]) ([]Change[T], []Change[T]) {
+	var allowed, deferred []Change[T]
+	perRegion := map[string]int{}
+	for _, change := range changes {
+		overRun := l.MaxChanges > 0 && len(allowed) >= l.MaxChanges
+		overRegion := l.MaxChangesPerRegion > 0 && perRegion[change.Region] >= l.MaxChangesPerRegion
+		if overRun || overRegion {
+			deferred = append(deferred, change)
+			continue
+		}
+		allowed = append(allowed, change)
+		perRegion[change.Region]++
+	}
+	return allowed, deferred
+}
+
+func PlanChanges[T any](changes []Change[T]) PlannedChanges {
+	planned := PlannedChanges{}
+	for _, change := range changes {
+		n := len(planned.Regions)
+		if n > 0 && planned.Regions[n-1] == change.Region {
+			planned.Counts[n-1]++
+		} else {
+			planned.Add(change.Region, 1)
+		}
+	}
+	return planned
+}
+
+// Canary makes the first few changes, waits, and checks nothing has broken
+// before making the rest.
+type Canary struct {
+	Size int // 0 disables the canary
+	Wait time.Duration
+}
+
+// HealthCheck looks for signs that a change has broken something.
+type HealthCheck interface {
+	Name() string
+	// Baseline records the state before the canary, to compare against.
+	Baseline(ctx context.Context) error
+	// Problems describes anything that has got worse since the baseline.
+	Problems(ctx context.Context) ([]string, error)
+}
+
+var ErrCanaryFailed = errors.New("canary failed its health checks, so the remaining changes were not made")
+
+// CanaryChanges returns the changes to make first. If the canary is at least
+// as big as the run, every change is in it, so the health checks still run.
+func CanaryChanges[T any](c Canary, changes []Change[T]) []Change[T] {
+	if c.Size <= 0 {
+		return nil
+	}
+	return changes[:min(c.Size, len(changes))]
+}
+
+// RollOut makes every change, canary first if one is configured. When the
+// canary covers every change there is nothing left to hold back, but the
+// health checks still run, so a failure is reported rather than missed.
+func RollOut[T any](ctx context.Context, c Canary, changes []Change[T], checks []HealthCheck, apply func(context.Context, Change[T])) error {
+	canary := CanaryChanges(c, changes)
+	if len(canary) == 0 {
+		for _, change := range changes {
+			apply(ctx, change)
+		}
+		return nil
+	}
+
+	for _, check := range checks {
+		if err := check.Baseline(ctx); err != nil {
+			return fmt.Errorf("could not record a baseline for health check %s: %w", check.Name(), err)
+		}
+	}
+
+	for _, change := range canary {
+		apply(ctx, change)
+	}
+	slog.Info("Canary applied. Waiting before checking health", "changes", len(canary), "wait", c.Wait)
+	select {
+	case <-time.After(c.Wait):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var problems []string
+	for _, check := range checks {
+		found, err := check.Problems(ctx)
+		if err != nil {
+			// If we can't tell whether the canary is healthy, assume it isn't
+			return fmt.Errorf("health check %s failed: %w", check.Name(), err)
+		}
+		problems = append(problems, found...)
+	}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			slog.Error("Health check found a problem after the canary", "problem", problem)
+		}
+		return ErrCanaryFailed
+	}
+	if len(canary) == len(changes) {
+		slog.Info("Canary healthy. It covered every change, so there are none remaining")
+		return nil
+	}
+
+	slog.Info("Canary healthy. Applying the remaining changes", "changes", len(changes)-len(canary))
+	for _, change := range changes[len(canary):] {
+		apply(ctx, change)
+	}
+	return nil
+}
+
+// Regions returns the distinct regions of changes, in order.
+func Regions[T any](changes []Change[T]) []string {
+	var regions []string
+	for _, change := range changes {
+		if !slices.Contains(regions, change.Region) {
+			regions = append(regions, change.Region)
+		}
+	}
+	return regions
+}
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func changesIn(regions ...string) []Change[int] {
+	var changes []Change[int]
+	for i, region := range regions {
+		changes = append(changes, Change[int]{Region: region, Item: i})
+	}
+	return changes
+}
+
+func TestApplyLimitsPerRegion(t *testing.T) {
+	changes := changesIn("eu-west-1", "eu-west-1", "eu-west-1", "us-east-1", "us-east-1")
+	allowed, deferred := ApplyLimits(Limits{MaxChangesPerRegion: 2}, changes)
+	expected := []Change[int]{changes[0], changes[1], changes[3], changes[4]}
+	if !reflect.DeepEqual(allowed, expected) {
+		t.Errorf("Error limiting changes per region. Expected %v, got %v", expected, allowed)
+	}
+	if !reflect.DeepEqual(deferred, []Change[int]{changes[2]}) {
+		t.Errorf("Error deferring changes. Expected %v, got %v", changes[2:3], deferred)
+	}
+}
+
+func TestApplyLimitsPerRun(t *testing.T) {
+	changes := changesIn("eu-west-1", "eu-west-1", "us-east-1")
+	allowed, deferred := ApplyLimits(Limits{MaxChanges: 2, MaxChangesPerRegion: 5}, changes)
+	if len(allowed) != 2 || len(deferred) != 1 || deferred[0].Region != "us-east-1" {
+		t.Errorf("Error limiting changes per run. Got allowed %v, deferred %v", allowed, deferred)
+	}
+}
+
+func TestApplyLimitsZeroMeansNoLimit(t *testing.T) {
+	changes := changesIn("eu-west-1", "eu-west-1", "us-east-1")
+	allowed, deferred := ApplyLimits(Limits{}, changes)
+	if len(allowed) != 3 || len(deferred) != 0 {
+		t.Errorf("Error applying no limits. Got allowed %v, deferred %v", allowed, deferred)
+	}
+}
+
+func TestPlanChanges(t *testing.T) {
+	planned := PlanChanges(changesIn("eu-west-1", "eu-west-1", "us-east-1"))
+	expected := PlannedChanges{Regions: []string{"eu-west-1", "us-east-1"}, Counts: []int{2, 1}}
+	if !reflect.DeepEqual(planned, expected) {
+		t.Errorf("Error planning changes. Expected %v, got %v", expected, planned)
+	}
+}
+
+type fakeCheck struct {
+	problems  []string
+	baselined bool
+}
+
+func (f *fakeCheck) Name() string { return "fake" }
+
+func (f *fakeCheck) Baseline(_ context.Context) error {
+	f.baselined = true
+	return nil
+}
+
+func (f *fakeCheck) Problems(_ context.Context) ([]string, error) {
+	return f.problems, nil
+}
+
+func rollOut(canary Canary, check *fakeCheck, changes []Change[int]) ([]int, error) {
+	var applied []int
+	err := RollOut(context.Background(), canary, changes, []HealthCheck{check}, func(_ context.Context, c Change[int]) {
+		applied = append(applied, c.Item)
+	})
+	return applied, err
+}
+
+func TestRollOutHealthyCanary(t *testing.T) {
+	check := &fakeCheck{}
+	applied, err := rollOut(Canary{Size: 1}, check, changesIn("a", "a", "b"))
+	if err != nil || !reflect.DeepEqual(applied, []int{0, 1, 2}) {
+		t.Errorf("Error rolling out after a healthy canary. Got %v, %v", applied, err)
+	}
+	if !check.baselined {
+		t.Errorf("Error rolling out. Expected a baseline before the canary")
+	}
+}
+
+func TestRollOutStopsAfterUnhealthyCanary(t *testing.T) {
+	check := &fakeCheck{problems: []string{"Alarm went off"}}
+	applied, err := rollOut(Canary{Size: 2}, check, changesIn("a", "a", "b"))
+	if !errors.Is(err, ErrCanaryFailed) {
+		t.Errorf("Error stopping an unhealthy canary. Expected ErrCanaryFailed, got %v", err)
+	}
+	if !reflect.DeepEqual(applied, []int{0, 1}) {
+		t.Errorf("Error stopping an unhealthy canary. Expected only the canary applied, got %v", applied)
+	}
+}
+
+func TestRollOutChecksCanaryCoveringEverything(t *testing.T) {
+	for _, size := range []int{3, 5} {
+		check := &fakeCheck{problems: []string{"Alarm went off"}}
+		applied, err := rollOut(Canary{Size: size}, check, changesIn("a", "a", "b"))
+		if !errors.Is(err, ErrCanaryFailed) || len(applied) != 3 || !check.baselined {
+			t.Errorf("Error rolling out a canary of %d covering every change. Got %v, %v", size, applied, err)
+		}
+	}
+}
+
+func TestRollOutWithoutCanary(t *testing.T) {
+	check := &fakeCheck{problems: []string{"Alarm went off"}}
+	applied, err := rollOut(Canary{}, check, changesIn("a", "a", "b"))
+	if err != nil || len(applied) != 3 || check.baselined {
+		t.Errorf("Error rolling out without a canary. Got %v, %v", applied, err)
+	}
+}
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+// NewLogger builds the logger for progress and diagnostics. Reports, such as
+// the resources to fix, are printed to stdout separately, so logs should go
+// to stderr to keep the two apart.
+func NewLogger(w io.Writer, format string, verbose bool, quiet bool) (*slog.Logger, error) {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	}
+	if quiet {
+		level = slog.LevelWarn
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+
+	switch format {
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	case LogFormatJson:
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q. Expected %s or %s", format, LogFormatText, LogFormatJson)
+}
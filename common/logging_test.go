@@ -0,0 +1,55 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLoggerLevels(t *testing.T) {
+	cases := []struct {
+		verbose, quiet bool
+		expected       []string
+	}{
+		{false, false, []string{"info", "warn"}},
+		{true, false, []string{"debug", "info", "warn"}},
+		{false, true, []string{"warn"}},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		logger, err := NewLogger(&out, LogFormatText, c.verbose, c.quiet)
+		if err != nil {
+			t.Fatalf("Error creating logger: %v", err)
+		}
+		logger.Debug("debug")
+		logger.Info("info")
+		logger.Warn("warn")
+
+		var logged []string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			_, msg, _ := strings.Cut(line, "msg=")
+			logged = append(logged, msg)
+		}
+		if strings.Join(logged, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("Error filtering levels with verbose %v and quiet %v. Expected %v, got %v", c.verbose, c.quiet, c.expected, logged)
+		}
+	}
+}
+
+func TestNewLoggerJson(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := NewLogger(&out, LogFormatJson, false, false)
+	if err != nil {
+		t.Fatalf("Error creating logger: %v", err)
+	}
+	logger.Info("Blocked public access", "bucket", "my-bucket")
+
+	var entry map[string]any
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil || entry["bucket"] != "my-bucket" {
+		t.Errorf("Error logging JSON. Got %q, %v", out.String(), err)
+	}
+	if _, err := NewLogger(&out, "xml", false, false); err == nil {
+		t.Errorf("Error creating logger. Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,246 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	ResourceTypeS3Bucket         = "AWS::S3::Bucket"
+	ResourceTypeSecurityGroup    = "AWS::EC2::SecurityGroup"
+	ResourceTypeKmsKey           = "AWS::KMS::Key"
+	ResourceTypeLogGroup         = "AWS::Logs::LogGroup"
+	ResourceTypeEc2Instance      = "AWS::EC2::Instance"
+	ResourceTypeIamRole          = "AWS::IAM::Role"
+	ResourceTypeDynamoDbTable    = "AWS::DynamoDB::Table"
+	ResourceTypeGlobalTable      = "AWS::DynamoDB::GlobalTable"
+	ResourceTypeLambdaFunction   = "AWS::Lambda::Function"
+	ResourceTypeLambdaPermission = "AWS::Lambda::Permission"
+	ResourceTypeSnsTopic         = "AWS::SNS::Topic"
+	ResourceTypeTopicPolicy      = "AWS::SNS::TopicPolicy"
+	ResourceTypeSqsQueue         = "AWS::SQS::Queue"
+	ResourceTypeQueuePolicy      = "AWS::SQS::QueuePolicy"
+	ResourceTypeEcrRepository    = "AWS::ECR::Repository"
+)
+
+// ManagedResource is a resource that might be owned by an infrastructure as
+// code tool, in which case it should be fixed in code rather than changed here.
+type ManagedResource struct {
+	Id   string // Bucket name, security group ID, etc.
+	Type string // CloudFormation resource type, e.g. AWS::S3::Bucket
+	Tags map[string]string
+}
+
+// ManagedBy records that a resource is owned by an infrastructure as code tool.
+type ManagedBy struct {
+	Id     string
+	Reason string
+}
+
+type ManagedByDetector interface {
+	// ManagedBy returns a human-readable reason if the resource is owned by
+	// infrastructure as code.
+	ManagedBy(resource ManagedResource) (string, bool)
+}
+
+type ManagedByDetectors []ManagedByDetector
+
+// Detect returns every resource that at least one detector recognises, with
+// the reason given by the first detector to recognise it.
+func (d ManagedByDetectors) Detect(resources []ManagedResource) []ManagedBy {
+	var managed []ManagedBy
+	for _, resource := range resources {
+		for _, detector := range d {
+			if reason, ok := detector.ManagedBy(resource); ok {
+				managed = append(managed, ManagedBy{Id: resource.Id, Reason: reason})
+				break
+			}
+		}
+	}
+	return managed
+}
+
+func ManagedIds(managed []ManagedBy) []string {
+	var ids []string
+	for _, m := range managed {
+		ids = append(ids, m.Id)
+	}
+	return ids
+}
+
+// DefaultManagedByTags are tag keys that IaC tools, or our own conventions,
+// put on the resources they own. CloudFormation adds the first automatically.
+var DefaultManagedByTags = []string{
+	"aws:cloudformation:stack-name",
+	"terraform",
+	"pulumi",
+}
+
+// DefaultManagedByValueTags are tag keys whose value says what manages a
+// resource, e.g. managed-by=terraform. People also use them for things like
+// managed-by=manual, so only the values in IacTools count.
+var DefaultManagedByValueTags = []string{
+	"managed-by",
+	"managedby",
+}
+
+// IacTools are the infrastructure as code tools recognised in the value of a
+// tag such as managed-by.
+var IacTools = []string{
+	"terraform",
+	"opentofu",
+	"pulumi",
+	"cdk",
+	"cloudformation",
+	"serverless",
+	"crossplane",
+}
+
+// TagDetector recognises resources carrying any of a set of tag keys. Keys
+// are compared case-insensitively. If Values is set, the tag's value must
+// also contain one of them, in any case.
+type TagDetector struct {
+	Keys   []string
+	Values []string
+}
+
+func (d TagDetector) matchesValue(value string) bool {
+	if len(d.Values) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(d.Values, func(v string) bool {
+		return strings.Contains(strings.ToLower(value), strings.ToLower(v))
+	})
+}
+
+func (d TagDetector) ManagedBy(resource ManagedResource) (string, bool) {
+	for _, key := range d.Keys {
+		for tagKey, tagValue := range resource.Tags {
+			if strings.EqualFold(tagKey, key) && d.matchesValue(tagValue) {
+				return fmt.Sprintf("tagged %s=%s", tagKey, tagValue), true
+			}
+		}
+	}
+	return "", false
+}
+
+// terraformResourceTypes maps Terraform resource types to the attribute
+// holding the ID of the resource they manage, and that resource's type.
+var terraformResourceTypes = map[string]struct {
+	attribute    string
+	resourceType string
+}{
+	"aws_s3_bucket":                       {"bucket", ResourceTypeS3Bucket},
+	"aws_s3_bucket_public_access_block":   {"bucket", ResourceTypeS3Bucket},
+	"aws_s3_bucket_policy":                {"bucket", ResourceTypeS3Bucket},
+	"aws_security_group":                  {"id", ResourceTypeSecurityGroup},
+	"aws_default_security_group":          {"id", ResourceTypeSecurityGroup},
+	"aws_security_group_rule":             {"security_group_id", ResourceTypeSecurityGroup},
+	"aws_vpc_security_group_ingress_rule": {"security_group_id", ResourceTypeSecurityGroup},
+	"aws_vpc_security_group_egress_rule":  {"security_group_id", ResourceTypeSecurityGroup},
+	"aws_kms_key":                         {"key_id", ResourceTypeKmsKey},
+	"aws_cloudwatch_log_group":            {"name", ResourceTypeLogGroup},
+	"aws_instance":                        {"id", ResourceTypeEc2Instance},
+	"aws_iam_role":                        {"name", ResourceTypeIamRole},
+	"aws_iam_role_policy_attachment":      {"role", ResourceTypeIamRole},
+	"aws_dynamodb_table":                  {"name", ResourceTypeDynamoDbTable},
+	"aws_lambda_function":                 {"function_name", ResourceTypeLambdaFunction},
+	"aws_lambda_permission":               {"function_name", ResourceTypeLambdaFunction},
+	"aws_sns_topic":                       {"arn", ResourceTypeSnsTopic},
+	"aws_sns_topic_policy":                {"arn", ResourceTypeSnsTopic},
+	"aws_sqs_queue":                       {"url", ResourceTypeSqsQueue},
+	"aws_sqs_queue_policy":                {"queue_url", ResourceTypeSqsQueue},
+	"aws_ecr_repository":                  {"name", ResourceTypeEcrRepository},
+	"aws_ecr_lifecycle_policy":            {"repository", ResourceTypeEcrRepository},
+}
+
+type terraformState struct {
+	Resources []struct {
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Module    string `json:"module"`
+		Instances []struct {
+			Attributes map[string]any `json:"attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// TerraformStateDetector recognises resources that appear in local Terraform
+// state files, e.g. from `terraform state pull`.
+type TerraformStateDetector struct {
+	managed map[string]string // Keyed by resource type and ID
+}
+
+func terraformKey(resourceType string, id string) string {
+	return resourceType + "/" + id
+}
+
+func ParseTerraformState(data []byte, source string) (TerraformStateDetector, error) {
+	var state terraformState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return TerraformStateDetector{}, fmt.Errorf("failed to parse Terraform state %s: %w", source, err)
+	}
+
+	detector := TerraformStateDetector{managed: map[string]string{}}
+	for _, resource := range state.Resources {
+		mapping, ok := terraformResourceTypes[resource.Type]
+		if resource.Mode != "managed" || !ok {
+			continue
+		}
+
+		address := resource.Type + "." + resource.Name
+		if resource.Module != "" {
+			address = resource.Module + "." + address
+		}
+		for _, instance := range resource.Instances {
+			if id, ok := instance.Attributes[mapping.attribute].(string); ok && id != "" {
+				detector.managed[terraformKey(mapping.resourceType, id)] = fmt.Sprintf("in Terraform state %s as %s", source, address)
+			}
+		}
+	}
+	return detector, nil
+}
+
+func LoadTerraformState(paths []string) (TerraformStateDetector, error) {
+	detector := TerraformStateDetector{managed: map[string]string{}}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return TerraformStateDetector{}, fmt.Errorf("failed to read Terraform state: %w", err)
+		}
+		state, err := ParseTerraformState(data, path)
+		if err != nil {
+			return TerraformStateDetector{}, err
+		}
+		for key, reason := range state.managed {
+			detector.managed[key] = reason
+		}
+	}
+	return detector, nil
+}
+
+func (d TerraformStateDetector) ManagedBy(resource ManagedResource) (string, bool) {
+	reason, ok := d.managed[terraformKey(resource.Type, resource.Id)]
+	return reason, ok
+}
+
+// NewManagedByDetectors builds the detectors for a run from user-supplied
+// extra tag keys and Terraform state file paths.
+func NewManagedByDetectors(extraTags []string, terraformStatePaths []string) (ManagedByDetectors, error) {
+	detectors := ManagedByDetectors{
+		TagDetector{Keys: slices.Concat(DefaultManagedByTags, extraTags)},
+		TagDetector{Keys: DefaultManagedByValueTags, Values: IacTools},
+	}
+	if len(terraformStatePaths) > 0 {
+		terraform, err := LoadTerraformState(terraformStatePaths)
+		if err != nil {
+			return nil, err
+		}
+		detectors = append(detectors, terraform)
+	}
+	return detectors, nil
+}
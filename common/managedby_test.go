@@ -0,0 +1,107 @@
+package common
+
+import (
+	"testing"
+)
+
+const exampleTerraformState = `{
+  "version": 4,
+  "resources": [
+    {
+      "mode": "managed",
+      "type": "aws_s3_bucket",
+      "name": "assets",
+      "instances": [{"attributes": {"id": "my-assets", "bucket": "my-assets"}}]
+    },
+    {
+      "module": "module.network",
+      "mode": "managed",
+      "type": "aws_default_security_group",
+      "name": "default",
+      "instances": [{"attributes": {"id": "sg-0123456789abcdef0"}}]
+    },
+    {
+      "mode": "data",
+      "type": "aws_s3_bucket",
+      "name": "lookup",
+      "instances": [{"attributes": {"id": "someone-elses-bucket", "bucket": "someone-elses-bucket"}}]
+    }
+  ]
+}`
+
+func TestTagDetectorMatchesCaseInsensitively(t *testing.T) {
+	detector := TagDetector{Keys: []string{"terraform"}}
+	reason, ok := detector.ManagedBy(ManagedResource{Id: "a", Tags: map[string]string{"Terraform": "true"}})
+	if !ok || reason != "tagged Terraform=true" {
+		t.Errorf("Error detecting tagged resource. Got %q, %v", reason, ok)
+	}
+}
+
+func TestTagDetectorIgnoresOtherTags(t *testing.T) {
+	detector := TagDetector{Keys: DefaultManagedByTags}
+	_, ok := detector.ManagedBy(ManagedResource{Id: "a", Tags: map[string]string{"Stage": "PROD"}})
+	if ok {
+		t.Errorf("Detected a resource without any managed-by tags")
+	}
+}
+
+func TestTagDetectorMatchesKnownValues(t *testing.T) {
+	detector := TagDetector{Keys: DefaultManagedByValueTags, Values: IacTools}
+	cases := map[string]bool{
+		"terraform":      true,
+		"Pulumi":         true,
+		"aws-cdk":        true,
+		"CloudFormation": true,
+		"manual":         false,
+		"platform-team":  false,
+		"":               false,
+	}
+	for value, expected := range cases {
+		if _, ok := detector.ManagedBy(ManagedResource{Id: "a", Tags: map[string]string{"Managed-By": value}}); ok != expected {
+			t.Errorf("Error detecting a resource tagged Managed-By=%s. Expected %v, got %v", value, expected, ok)
+		}
+	}
+}
+
+func TestTerraformStateDetector(t *testing.T) {
+	detector, err := ParseTerraformState([]byte(exampleTerraformState), "example.tfstate")
+	if err != nil {
+		t.Fatalf("Error parsing Terraform state: %v", err)
+	}
+
+	cases := []struct {
+		resource ManagedResource
+		managed  bool
+	}{
+		{ManagedResource{Id: "my-assets", Type: ResourceTypeS3Bucket}, true},
+		{ManagedResource{Id: "sg-0123456789abcdef0", Type: ResourceTypeSecurityGroup}, true},
+		{ManagedResource{Id: "someone-elses-bucket", Type: ResourceTypeS3Bucket}, false},
+		{ManagedResource{Id: "my-assets", Type: ResourceTypeSecurityGroup}, false},
+	}
+	for _, c := range cases {
+		if _, ok := detector.ManagedBy(c.resource); ok != c.managed {
+			t.Errorf("Error detecting %s %s in Terraform state. Expected %v, got %v", c.resource.Type, c.resource.Id, c.managed, ok)
+		}
+	}
+}
+
+func TestInvalidTerraformState(t *testing.T) {
+	_, err := ParseTerraformState([]byte("not json"), "broken.tfstate")
+	if err == nil {
+		t.Errorf("Expected an error parsing invalid Terraform state")
+	}
+}
+
+func TestDetectUsesFirstMatchingDetector(t *testing.T) {
+	terraform, _ := ParseTerraformState([]byte(exampleTerraformState), "example.tfstate")
+	detectors := ManagedByDetectors{TagDetector{Keys: []string{"managed-by"}}, terraform}
+	resources := []ManagedResource{
+		{Id: "my-assets", Type: ResourceTypeS3Bucket, Tags: map[string]string{"managed-by": "pulumi"}},
+		{Id: "unmanaged", Type: ResourceTypeS3Bucket},
+	}
+
+	managed := detectors.Detect(resources)
+	if len(managed) != 1 || managed[0].Id != "my-assets" || managed[0].Reason != "tagged managed-by=pulumi" {
+		t.Errorf("Error detecting managed resources. Got %v", managed)
+	}
+}
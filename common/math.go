@@ -18,10 +18,27 @@ func Complement[T comparable](slice []T, toRemove []T) []T {
 		if !found {
 			complement = append(complement, element)
 		} else {
-			fmt.Printf("\nExcluding: '%v'", element)
+			fmt.Fprintf(Out, "\nExcluding: '%v'", element)
 		}
 	}
-	fmt.Println("") //This ensures sure the log output is tidy
+	fmt.Fprintln(Out, "") //This ensures sure the log output is tidy
 
 	return complement
 }
+
+// Without is Complement, without logging what was removed. Use it where
+// output would otherwise be interleaved, e.g. inside ParallelMap.
+func Without[T comparable](slice []T, toRemove []T) []T {
+	removeMap := make(map[T]bool)
+	for _, remove := range toRemove {
+		removeMap[remove] = true
+	}
+
+	var without []T
+	for _, element := range slice {
+		if !removeMap[element] {
+			without = append(without, element)
+		}
+	}
+	return without
+}
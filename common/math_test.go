@@ -82,3 +82,17 @@ func TestComplementOfDuplicates(t *testing.T) {
 	expected := []string{"a", "b"}
 	evaluateResult(t, result, expected, "Error computing complement when slice to remove contains duplicates")
 }
+
+func TestWithoutOfNonEmptySlices(t *testing.T) {
+	slice := []string{"a", "b", "c", "d", "e"}
+	toRemove := []string{"b", "d", "f"}
+	result := Without(slice, toRemove)
+	expected := []string{"a", "c", "e"}
+	evaluateResult(t, result, expected, "Error removing elements from slice")
+}
+
+func TestWithoutOfEmptyToRemove(t *testing.T) {
+	slice := []string{"a", "b"}
+	result := Without(slice, []string{})
+	evaluateResult(t, result, slice, "Error removing nothing from slice")
+}
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Options are the settings shared by every control.
+type Options struct {
+	Execute     bool
+	Concurrency int
+	Limit       int // The most failing resources to process, across all regions. 0 means no limit
+	Source      string
+	StackLookup StackLookup
+	Detectors   ManagedByDetectors
+	PatchDir    string // Where to write proposed template patches, if anywhere
+	Confirm     Confirmation
+	Limits      Limits
+	Canary      Canary
+	AlarmPrefix string // Only watch alarms with this prefix during a canary
+	Audit       *AuditLog
+	Retry       RetryPolicy
+}
+
+// ReportStackPatches prints each proposed stack patch and, if a patch
+// directory was given, saves them there.
+func (o Options) ReportStackPatches(patches []StackPatch) {
+	for _, patch := range patches {
+		if patch.Err == nil && len(patch.Resources) == 0 {
+			continue
+		}
+		fmt.Fprint(Out, patch.Describe())
+		if o.PatchDir == "" || patch.Err != nil {
+			continue
+		}
+		path, err := WriteStackPatch(o.PatchDir, patch)
+		if err != nil {
+			WarnOnError(err, "Failed to write patch for stack "+patch.StackName)
+			continue
+		}
+		slog.Info("Wrote stack patch", "stack", patch.StackName, "path", path)
+	}
+}
+
+// HealthChecks are the checks to run after a canary in the given regions,
+// plus any specific to the control.
+func (o Options) HealthChecks(sess *Session, regions []string, extra ...HealthCheck) []HealthCheck {
+	alarms := &AlarmHealthCheck{Sess: sess, Regions: regions, Prefix: o.AlarmPrefix}
+	return append([]HealthCheck{alarms}, extra...)
+}
@@ -0,0 +1,45 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// templateStatement reads a statement from a template. Intrinsic functions
+// are left as they are, so only literal principals are recognised as public.
+func templateStatement(value any) (Statement, bool) {
+	out, err := json.Marshal(value)
+	if err != nil {
+		return Statement{}, false
+	}
+	var statement Statement
+	if err := json.Unmarshal(out, &statement); err != nil {
+		return Statement{}, false
+	}
+	return statement, true
+}
+
+// RemovePublicStatementsPatch proposes removing the public statements from the
+// policies attached to a stack-managed resource, e.g. from each
+// AWS::SNS::TopicPolicy whose Topics include a topic.
+func RemovePublicStatementsPatch(policyType string, property string) common.PatchBuilder {
+	return func(template common.StackTemplate, logicalId string) []common.PatchOp {
+		var ops []common.PatchOp
+		for _, policyId := range template.ResourcesWithProperty(policyType, property, logicalId) {
+			document, _ := template.Properties(policyId)["PolicyDocument"].(map[string]any)
+			statements, _ := document["Statement"].([]any)
+			// Remove from the end, so earlier indexes stay valid
+			for i := len(statements) - 1; i >= 0; i-- {
+				if statement, ok := templateStatement(statements[i]); ok && statement.Public() {
+					ops = append(ops, common.PatchOp{
+						Op:   "remove",
+						Path: common.ResourcePointer(policyId, "Properties", "PolicyDocument", "Statement", fmt.Sprint(i)),
+					})
+				}
+			}
+		}
+		return ops
+	}
+}
@@ -0,0 +1,45 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func TestRemovePublicStatementsPatch(t *testing.T) {
+	template, err := common.ParseTemplate(`
+Resources:
+  Queue:
+    Type: AWS::SQS::Queue
+  QueuePolicy:
+    Type: AWS::SQS::QueuePolicy
+    Properties:
+      Queues:
+        - !Ref Queue
+      PolicyDocument:
+        Statement:
+          - Effect: Allow
+            Principal: "*"
+            Action: sqs:SendMessage
+          - Effect: Allow
+            Principal:
+              Service: events.amazonaws.com
+            Action: sqs:SendMessage
+          - Effect: Allow
+            Principal:
+              AWS: "*"
+            Action: sqs:ReceiveMessage
+`)
+	if err != nil {
+		t.Fatalf("Error parsing template: %v", err)
+	}
+
+	expected := []common.PatchOp{
+		{Op: "remove", Path: "/Resources/QueuePolicy/Properties/PolicyDocument/Statement/2"},
+		{Op: "remove", Path: "/Resources/QueuePolicy/Properties/PolicyDocument/Statement/0"},
+	}
+	if got := RemovePublicStatementsPatch(common.ResourceTypeQueuePolicy, "Queues")(template, "Queue"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error patching Queue. Expected %v, got %v", expected, got)
+	}
+}
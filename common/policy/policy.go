@@ -0,0 +1,417 @@
+// Package policy parses IAM policy documents, such as the resource-based
+// policies of buckets, functions, topics and queues, and answers questions
+// about who they let in.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Values is a policy field that may be written as a single string or a list,
+// e.g. Action, Resource, or the values of a condition. Booleans and numbers,
+// as in "aws:SecureTransport": false, are read as strings.
+type Values []string
+
+func (v *Values) UnmarshalJSON(data []byte) error {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	items, ok := raw.([]any)
+	if !ok {
+		items = []any{raw}
+	}
+	*v = nil
+	for _, item := range items {
+		switch i := item.(type) {
+		case string:
+			*v = append(*v, i)
+		case bool, float64:
+			*v = append(*v, fmt.Sprint(i))
+		default:
+			return fmt.Errorf("unexpected policy value %s", data)
+		}
+	}
+	return nil
+}
+
+// MarshalJSON writes a single value as a string, as AWS does.
+func (v Values) MarshalJSON() ([]byte, error) {
+	if len(v) == 1 {
+		return json.Marshal(v[0])
+	}
+	return json.Marshal([]string(v))
+}
+
+// Principals are keyed by type, e.g. AWS, Service or Federated. A principal of
+// "*" is read as {"AWS": "*"}, which means the same.
+type Principals map[string]Values
+
+func (p *Principals) UnmarshalJSON(data []byte) error {
+	var everyone string
+	if json.Unmarshal(data, &everyone) == nil {
+		if everyone != "*" {
+			return fmt.Errorf("unexpected principal %s", data)
+		}
+		*p = Principals{"AWS": {"*"}}
+		return nil
+	}
+	var principals map[string]Values
+	if err := json.Unmarshal(data, &principals); err != nil {
+		return err
+	}
+	*p = principals
+	return nil
+}
+
+// Everyone reports whether the principals include anyone at all.
+func (p Principals) Everyone() bool {
+	return slices.Contains(p["AWS"], "*")
+}
+
+// Accounts returns the accounts of the AWS principals, which may be given as
+// account IDs, or the ARNs of the accounts' roots, roles or users.
+func (p Principals) Accounts() []string {
+	var accounts []string
+	for _, principal := range p["AWS"] {
+		account := principal
+		if parts := strings.Split(principal, ":"); len(parts) >= 5 && parts[0] == "arn" {
+			account = parts[4]
+		}
+		if account != "*" && !slices.Contains(accounts, account) {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+// Conditions are keyed by operator, e.g. StringEquals, then by condition key.
+// Condition keys are case-insensitive, so are read in lower case.
+type Conditions map[string]map[string]Values
+
+func (c *Conditions) UnmarshalJSON(data []byte) error {
+	var conditions map[string]map[string]Values
+	if err := json.Unmarshal(data, &conditions); err != nil {
+		return err
+	}
+	*c = Conditions{}
+	for operator, keys := range conditions {
+		(*c)[operator] = map[string]Values{}
+		for key, values := range keys {
+			(*c)[operator][strings.ToLower(key)] = values
+		}
+	}
+	return nil
+}
+
+// Value returns the first value of a condition key under an operator, e.g.
+// StringEquals lambda:FunctionUrlAuthType, or an empty string.
+func (c Conditions) Value(operator string, key string) string {
+	if values := c[operator][strings.ToLower(key)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// restrictingConditionKeys limit who can use a statement, so a statement
+// requiring any of them isn't public, even if its principal is *.
+var restrictingConditionKeys = []string{
+	"aws:sourcearn",
+	"aws:sourceaccount",
+	"aws:sourceowner",
+	"aws:sourceorgid",
+	"aws:sourceorgpaths",
+	"aws:principalorgid",
+	"aws:principalorgpaths",
+	"aws:principalaccount",
+	"aws:principalarn",
+	"aws:sourcevpc",
+	"aws:sourcevpce",
+	"aws:sourceip",
+}
+
+// matchesAnything are condition values that match every request, so a
+// condition on them restricts nothing, e.g. aws:SourceIp 0.0.0.0/0.
+var matchesAnything = []string{"*", "0.0.0.0/0", "::/0"}
+
+// restricts reports whether a condition operator only lets in requests that
+// match its values. Negated operators let in everything else, IfExists
+// operators let in requests without the key, and Null only checks presence.
+func restricts(operator string) bool {
+	operator = strings.TrimPrefix(strings.TrimPrefix(operator, "ForAnyValue:"), "ForAllValues:")
+	return !strings.Contains(operator, "Not") && !strings.HasSuffix(operator, "IfExists") && operator != "Null"
+}
+
+// Statement is a statement of a policy. Statements read from a policy are
+// written back exactly as they were, so removing or adding other statements
+// leaves them untouched.
+type Statement struct {
+	Sid          string     `json:"Sid,omitempty"`
+	Effect       string     `json:"Effect"`
+	Principal    Principals `json:"Principal,omitempty"`
+	NotPrincipal Principals `json:"NotPrincipal,omitempty"`
+	Action       Values     `json:"Action,omitempty"`
+	NotAction    Values     `json:"NotAction,omitempty"`
+	Resource     Values     `json:"Resource,omitempty"`
+	NotResource  Values     `json:"NotResource,omitempty"`
+	Condition    Conditions `json:"Condition,omitempty"`
+	raw          json.RawMessage
+}
+
+// statementFields is Statement without its JSON methods.
+type statementFields Statement
+
+func (s *Statement) UnmarshalJSON(data []byte) error {
+	var fields statementFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*s = Statement(fields)
+	s.raw = slices.Clone(data)
+	return nil
+}
+
+func (s Statement) MarshalJSON() ([]byte, error) {
+	if s.raw != nil {
+		return s.raw, nil
+	}
+	return json.Marshal(statementFields(s))
+}
+
+// String is the statement as compact JSON, to show users exactly what is
+// being changed.
+func (s Statement) String() string {
+	out, _ := json.Marshal(s)
+	return string(out)
+}
+
+func (s Statement) allows() bool {
+	return s.Effect == "Allow"
+}
+
+// RestrictedBy reports whether a statement only applies to requests matching
+// a condition on key, e.g. aws:SourceArn. A condition matching anything, such
+// as aws:SourceAccount "*" or aws:SourceIp "0.0.0.0/0", doesn't count.
+func (s Statement) RestrictedBy(key string) bool {
+	for operator, keys := range s.Condition {
+		values, ok := keys[strings.ToLower(key)]
+		anything := slices.ContainsFunc(values, func(v string) bool { return slices.Contains(matchesAnything, v) })
+		if ok && restricts(operator) && !anything {
+			return true
+		}
+	}
+	return false
+}
+
+// Restricted reports whether a statement's conditions limit who can use it.
+func (s Statement) Restricted() bool {
+	return slices.ContainsFunc(restrictingConditionKeys, s.RestrictedBy)
+}
+
+// Public reports whether a statement lets anyone use the resource. Statements
+// scoped by e.g. aws:SourceArn or aws:SourceAccount are not public. Allowing
+// everyone except a NotPrincipal is public.
+func (s Statement) Public() bool {
+	everyone := s.Principal.Everyone() || s.NotPrincipal != nil
+	return s.allows() && everyone && !s.Restricted()
+}
+
+// CrossAccount reports whether a statement lets in principals from accounts
+// other than accountId.
+func (s Statement) CrossAccount(accountId string) bool {
+	return s.allows() && slices.ContainsFunc(s.Principal.Accounts(), func(account string) bool { return account != accountId })
+}
+
+// Document is a policy. Fields other than its statements are written back as
+// they were, as is its indentation.
+type Document struct {
+	Version   string
+	Id        string
+	Statement []Statement
+	fields    map[string]json.RawMessage
+	indent    string
+}
+
+func (d *Document) UnmarshalJSON(data []byte) error {
+	d.fields = nil
+	if err := json.Unmarshal(data, &d.fields); err != nil {
+		return err
+	}
+	d.Version, d.Id = "", ""
+	if version, ok := d.fields["Version"]; ok {
+		if err := json.Unmarshal(version, &d.Version); err != nil {
+			return err
+		}
+	}
+	if id, ok := d.fields["Id"]; ok {
+		if err := json.Unmarshal(id, &d.Id); err != nil {
+			return err
+		}
+	}
+
+	// A policy with one statement may give it as an object
+	d.Statement = nil
+	statements := bytes.TrimSpace(d.fields["Statement"])
+	if len(statements) > 0 && statements[0] == '{' {
+		var statement Statement
+		if err := json.Unmarshal(statements, &statement); err != nil {
+			return err
+		}
+		d.Statement = []Statement{statement}
+	} else if len(statements) > 0 {
+		if err := json.Unmarshal(statements, &d.Statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalJSON writes Version and Id first, then any other fields, and the
+// statements last, as in policies written by hand.
+func (d Document) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	write := func(key string, value any) error {
+		out, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%q:", key)
+		buf.Write(out)
+		return nil
+	}
+
+	if d.Version != "" {
+		write("Version", d.Version)
+	}
+	if d.Id != "" {
+		write("Id", d.Id)
+	}
+	var others []string
+	for key := range d.fields {
+		if key != "Version" && key != "Id" && key != "Statement" {
+			others = append(others, key)
+		}
+	}
+	slices.Sort(others)
+	for _, key := range others {
+		if err := write(key, d.fields[key]); err != nil {
+			return nil, err
+		}
+	}
+	statements := d.Statement
+	if statements == nil {
+		statements = []Statement{}
+	}
+	if err := write("Statement", statements); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// indentation returns the indent of the first indented line of a policy, or
+// an empty string if it's compact.
+func indentation(policy string) string {
+	for _, line := range strings.Split(policy, "\n")[1:] {
+		if trimmed := strings.TrimLeft(line, " \t"); trimmed != line {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return ""
+}
+
+func Parse(policy string) (Document, error) {
+	var document Document
+	if err := json.Unmarshal([]byte(policy), &document); err != nil {
+		return Document{}, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	document.indent = indentation(policy)
+	return document, nil
+}
+
+// String is the policy as JSON, ready to set on a resource, indented as it
+// was when parsed.
+func (d Document) String() string {
+	out, _ := json.Marshal(d)
+	if d.indent == "" {
+		return string(out)
+	}
+	var indented bytes.Buffer
+	json.Indent(&indented, out, "", d.indent)
+	return indented.String()
+}
+
+func (d Document) filter(keep func(Statement) bool) []Statement {
+	var statements []Statement
+	for _, statement := range d.Statement {
+		if keep(statement) {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+func (d Document) PublicStatements() []Statement {
+	return d.filter(Statement.Public)
+}
+
+// CrossAccountStatements returns the statements letting in other accounts
+// than accountId.
+func (d Document) CrossAccountStatements(accountId string) []Statement {
+	return d.filter(func(s Statement) bool { return s.CrossAccount(accountId) })
+}
+
+// Services returns the AWS services a policy lets use the resource, either as
+// service principals or through an aws:SourceArn condition, e.g.
+// events.amazonaws.com. The result is sorted.
+func (d Document) Services() []string {
+	var services []string
+	add := func(service string) {
+		if !slices.Contains(services, service) {
+			services = append(services, service)
+		}
+	}
+	for _, statement := range d.filter(Statement.allows) {
+		for _, service := range statement.Principal["Service"] {
+			add(service)
+		}
+		for _, keys := range statement.Condition {
+			for _, arn := range keys["aws:sourcearn"] {
+				if parts := strings.Split(arn, ":"); len(parts) > 2 && parts[2] != "" && parts[2] != "*" {
+					add(parts[2] + ".amazonaws.com")
+				}
+			}
+		}
+	}
+	slices.Sort(services)
+	return services
+}
+
+// RemoveStatements returns the policy without the statements for which remove
+// is true. The policy itself is unchanged.
+func (d Document) RemoveStatements(remove func(Statement) bool) Document {
+	d.Statement = slices.DeleteFunc(slices.Clone(d.Statement), remove)
+	return d
+}
+
+// AddStatements returns the policy with the given statements added at the
+// end. IAM requires Sids to be unique, so a statement whose Sid is already in
+// the policy is an error. The policy itself is unchanged.
+func (d Document) AddStatements(statements ...Statement) (Document, error) {
+	d.Statement = slices.Clone(d.Statement)
+	for _, statement := range statements {
+		if statement.Sid != "" && slices.ContainsFunc(d.Statement, func(s Statement) bool { return s.Sid == statement.Sid }) {
+			return Document{}, fmt.Errorf("policy already has a statement with Sid %s", statement.Sid)
+		}
+		d.Statement = append(d.Statement, statement)
+	}
+	return d, nil
+}
@@ -0,0 +1,351 @@
+package policy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func parseStatement(t *testing.T, statement string) Statement {
+	t.Helper()
+	var s Statement
+	if err := json.Unmarshal([]byte(statement), &s); err != nil {
+		t.Fatalf("Error parsing statement %s: %v", statement, err)
+	}
+	return s
+}
+
+func sids(statements []Statement) []string {
+	var result []string
+	for _, statement := range statements {
+		result = append(result, statement.Sid)
+	}
+	return result
+}
+
+func TestValues(t *testing.T) {
+	cases := []struct {
+		json     string
+		expected Values
+		err      bool
+	}{
+		{json: `"s3:GetObject"`, expected: Values{"s3:GetObject"}},
+		{json: `["s3:GetObject", "s3:PutObject"]`, expected: Values{"s3:GetObject", "s3:PutObject"}},
+		{json: `false`, expected: Values{"false"}},
+		{json: `[10, "20"]`, expected: Values{"10", "20"}},
+		{json: `{"Fn::Sub": "x"}`, err: true},
+	}
+	for _, c := range cases {
+		var values Values
+		err := json.Unmarshal([]byte(c.json), &values)
+		if c.err {
+			if err == nil {
+				t.Errorf("Error reading %s. Expected an error, got %v", c.json, values)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(values, c.expected) {
+			t.Errorf("Error reading %s. Expected %v, got %v (%v)", c.json, c.expected, values, err)
+		}
+	}
+
+	if out, _ := json.Marshal(Values{"sns:Publish"}); string(out) != `"sns:Publish"` {
+		t.Errorf("Error writing a single value. Expected a string, got %s", out)
+	}
+}
+
+func TestPrincipals(t *testing.T) {
+	cases := []struct {
+		json     string
+		expected Principals
+		everyone bool
+		accounts []string
+	}{
+		{json: `"*"`, expected: Principals{"AWS": {"*"}}, everyone: true},
+		{json: `{"AWS": "*"}`, expected: Principals{"AWS": {"*"}}, everyone: true},
+		{json: `{"AWS": ["arn:aws:iam::111111111111:root", "*"]}`, expected: Principals{"AWS": {"arn:aws:iam::111111111111:root", "*"}}, everyone: true, accounts: []string{"111111111111"}},
+		{
+			json:     `{"AWS": ["111111111111", "arn:aws:iam::222222222222:role/deploy", "arn:aws:iam::111111111111:user/ci"]}`,
+			expected: Principals{"AWS": {"111111111111", "arn:aws:iam::222222222222:role/deploy", "arn:aws:iam::111111111111:user/ci"}},
+			accounts: []string{"111111111111", "222222222222"},
+		},
+		{json: `{"Service": ["events.amazonaws.com", "sns.amazonaws.com"]}`, expected: Principals{"Service": {"events.amazonaws.com", "sns.amazonaws.com"}}},
+	}
+	for _, c := range cases {
+		var principals Principals
+		if err := json.Unmarshal([]byte(c.json), &principals); err != nil {
+			t.Errorf("Error reading principal %s: %v", c.json, err)
+			continue
+		}
+		if !reflect.DeepEqual(principals, c.expected) {
+			t.Errorf("Error reading principal %s. Expected %v, got %v", c.json, c.expected, principals)
+		}
+		if everyone := principals.Everyone(); everyone != c.everyone {
+			t.Errorf("Error checking whether %s is everyone. Expected %v, got %v", c.json, c.everyone, everyone)
+		}
+		if accounts := principals.Accounts(); !reflect.DeepEqual(accounts, c.accounts) {
+			t.Errorf("Error finding accounts of %s. Expected %v, got %v", c.json, c.accounts, accounts)
+		}
+	}
+
+	var principals Principals
+	if err := json.Unmarshal([]byte(`"arn:aws:iam::111111111111:root"`), &principals); err == nil {
+		t.Errorf("Error reading principal. Expected a string other than * to be an error, got %v", principals)
+	}
+}
+
+func TestConditions(t *testing.T) {
+	s := parseStatement(t, `{"Effect": "Allow", "Principal": "*", "Action": "lambda:InvokeFunctionUrl",
+  "Condition": {"StringEquals": {"lambda:FunctionUrlAuthType": "NONE", "AWS:SourceAccount": ["111111111111"]}, "Bool": {"aws:SecureTransport": false}}}`)
+
+	expected := Conditions{
+		"StringEquals": {"lambda:functionurlauthtype": {"NONE"}, "aws:sourceaccount": {"111111111111"}},
+		"Bool":         {"aws:securetransport": {"false"}},
+	}
+	if !reflect.DeepEqual(s.Condition, expected) {
+		t.Errorf("Error normalising conditions. Expected %v, got %v", expected, s.Condition)
+	}
+	if value := s.Condition.Value("StringEquals", "lambda:FunctionUrlAuthType"); value != "NONE" {
+		t.Errorf("Error finding condition value. Expected NONE, got %s", value)
+	}
+	if value := s.Condition.Value("StringLike", "lambda:FunctionUrlAuthType"); value != "" {
+		t.Errorf("Error finding condition value under another operator. Expected none, got %s", value)
+	}
+}
+
+func TestPublic(t *testing.T) {
+	cases := []struct {
+		name      string
+		statement string
+		expected  bool
+	}{
+		{"star principal", `{"Effect": "Allow", "Principal": "*", "Action": "sqs:SendMessage"}`, true},
+		{"AWS star principal", `{"Effect": "Allow", "Principal": {"AWS": "*"}, "Action": "sqs:SendMessage"}`, true},
+		{"star in a list", `{"Effect": "Allow", "Principal": {"AWS": ["arn:aws:iam::111111111111:root", "*"]}, "Action": "sqs:SendMessage"}`, true},
+		{"deny", `{"Effect": "Deny", "Principal": "*", "Action": "sqs:SendMessage"}`, false},
+		{"account principal", `{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::111111111111:root"}, "Action": "sqs:SendMessage"}`, false},
+		{"service principal", `{"Effect": "Allow", "Principal": {"Service": "events.amazonaws.com"}, "Action": "sqs:SendMessage"}`, false},
+		{"everyone but a NotPrincipal", `{"Effect": "Allow", "NotPrincipal": {"AWS": "arn:aws:iam::111111111111:root"}, "Action": "sqs:SendMessage"}`, true},
+		{"source ARN", `{"Effect": "Allow", "Principal": "*", "Action": "sqs:SendMessage", "Condition": {"ArnEquals": {"aws:SourceArn": "arn:aws:sns:eu-west-1:111111111111:alerts"}}}`, false},
+		{"source ARN in lower case", `{"Effect": "Allow", "Principal": "*", "Action": "sqs:SendMessage", "Condition": {"ArnLike": {"aws:sourcearn": "arn:aws:s3:::bucket"}}}`, false},
+		{"source account", `{"Effect": "Allow", "Principal": "*", "Action": "sns:Publish", "Condition": {"StringEquals": {"AWS:SourceAccount": "111111111111"}}}`, false},
+		{"source owner", `{"Effect": "Allow", "Principal": {"AWS": "*"}, "Action": "sns:Publish", "Condition": {"StringEquals": {"AWS:SourceOwner": "111111111111"}}}`, false},
+		{"organisation", `{"Effect": "Allow", "Principal": "*", "Action": "sns:Publish", "Condition": {"StringEquals": {"aws:PrincipalOrgID": "o-abc123"}}}`, false},
+		{"organisation paths", `{"Effect": "Allow", "Principal": "*", "Action": "sns:Publish", "Condition": {"ForAnyValue:StringLike": {"aws:PrincipalOrgPaths": "o-abc123/r-ab12/*"}}}`, false},
+		{"VPC endpoint", `{"Effect": "Allow", "Principal": "*", "Action": "sqs:*", "Condition": {"StringEquals": {"aws:SourceVpce": "vpce-1a2b3c4d"}}}`, false},
+		{"source account if exists", `{"Effect": "Allow", "Principal": "*", "Action": "sns:Publish", "Condition": {"StringEqualsIfExists": {"aws:SourceAccount": "111111111111"}}}`, true},
+		{"any source account but one", `{"Effect": "Allow", "Principal": "*", "Action": "sns:Publish", "Condition": {"StringNotEquals": {"aws:SourceAccount": "111111111111"}}}`, true},
+		{"any source account", `{"Effect": "Allow", "Principal": "*", "Action": "sns:Publish", "Condition": {"StringLike": {"aws:SourceAccount": "*"}}}`, true},
+		{"source ARN present", `{"Effect": "Allow", "Principal": "*", "Action": "sqs:SendMessage", "Condition": {"Null": {"aws:SourceArn": "false"}}}`, true},
+		{"source IP", `{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Condition": {"IpAddress": {"aws:SourceIp": "203.0.113.0/24"}}}`, false},
+		{"any IPv4 source IP", `{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Condition": {"IpAddress": {"aws:SourceIp": "0.0.0.0/0"}}}`, true},
+		{"any IPv6 source IP", `{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Condition": {"IpAddress": {"aws:SourceIp": ["203.0.113.0/24", "::/0"]}}}`, true},
+		{"secure transport only", `{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Condition": {"Bool": {"aws:SecureTransport": "true"}}}`, true},
+	}
+	for _, c := range cases {
+		if public := parseStatement(t, c.statement).Public(); public != c.expected {
+			t.Errorf("Error checking whether %s is public. Expected %v, got %v", c.name, c.expected, public)
+		}
+	}
+}
+
+func TestRestrictedBy(t *testing.T) {
+	s := parseStatement(t, `{"Effect": "Allow", "Principal": "*", "Action": "sqs:SendMessage",
+  "Condition": {"ArnEquals": {"aws:SourceArn": "arn:aws:sns:eu-west-1:111111111111:alerts"}, "StringNotEquals": {"aws:SourceAccount": "222222222222"}}}`)
+	cases := map[string]bool{
+		"aws:SourceArn":      true,
+		"AWS:SOURCEARN":      true,
+		"aws:SourceAccount":  false,
+		"aws:PrincipalOrgID": false,
+	}
+	for key, expected := range cases {
+		if restricted := s.RestrictedBy(key); restricted != expected {
+			t.Errorf("Error checking whether the statement is restricted by %s. Expected %v, got %v", key, expected, restricted)
+		}
+	}
+}
+
+func TestCrossAccount(t *testing.T) {
+	cases := []struct {
+		name      string
+		statement string
+		expected  bool
+	}{
+		{"same account root", `{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::111111111111:root"}, "Action": "sqs:*"}`, false},
+		{"same account ID", `{"Effect": "Allow", "Principal": {"AWS": "111111111111"}, "Action": "sqs:*"}`, false},
+		{"other account role", `{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::222222222222:role/reader"}, "Action": "sqs:ReceiveMessage"}`, true},
+		{"one of several", `{"Effect": "Allow", "Principal": {"AWS": ["111111111111", "222222222222"]}, "Action": "sqs:ReceiveMessage"}`, true},
+		{"denied other account", `{"Effect": "Deny", "Principal": {"AWS": "222222222222"}, "Action": "sqs:*"}`, false},
+		{"everyone", `{"Effect": "Allow", "Principal": "*", "Action": "sqs:*"}`, false},
+		{"service", `{"Effect": "Allow", "Principal": {"Service": "sns.amazonaws.com"}, "Action": "sqs:SendMessage"}`, false},
+	}
+	for _, c := range cases {
+		if crossAccount := parseStatement(t, c.statement).CrossAccount("111111111111"); crossAccount != c.expected {
+			t.Errorf("Error checking whether %s is cross-account. Expected %v, got %v", c.name, c.expected, crossAccount)
+		}
+	}
+}
+
+const examplePolicy = `{
+  "Version": "2012-10-17",
+  "Id": "queue-policy",
+  "Statement": [
+    {"Sid": "public", "Effect": "Allow", "Principal": "*", "Action": "sqs:SendMessage", "Resource": "arn:aws:sqs:eu-west-1:111111111111:queue"},
+    {"Sid": "topic", "Effect": "Allow", "Principal": {"AWS": "*"}, "Action": "sqs:SendMessage", "Resource": "arn:aws:sqs:eu-west-1:111111111111:queue",
+     "Condition": {"ArnEquals": {"aws:SourceArn": "arn:aws:sns:eu-west-1:111111111111:alerts"}}},
+    {"Sid": "events", "Effect": "Allow", "Principal": {"Service": "events.amazonaws.com"}, "Action": "sqs:SendMessage", "Resource": "arn:aws:sqs:eu-west-1:111111111111:queue"},
+    {"Sid": "reader", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::222222222222:role/reader"}, "Action": ["sqs:ReceiveMessage", "sqs:DeleteMessage"], "Resource": "arn:aws:sqs:eu-west-1:111111111111:queue"},
+    {"Sid": "owner-only", "Effect": "Deny", "NotPrincipal": {"AWS": "arn:aws:iam::111111111111:root"}, "NotAction": "sqs:SendMessage", "Resource": "arn:aws:sqs:eu-west-1:111111111111:queue"}
+  ]
+}`
+
+func TestParse(t *testing.T) {
+	document, err := Parse(examplePolicy)
+	if err != nil {
+		t.Fatalf("Error parsing policy: %v", err)
+	}
+	if document.Version != "2012-10-17" || document.Id != "queue-policy" {
+		t.Errorf("Error reading policy fields. Got version %s and ID %s", document.Version, document.Id)
+	}
+
+	cases := []struct {
+		name     string
+		got      []string
+		expected []string
+	}{
+		{"statements", sids(document.Statement), []string{"public", "topic", "events", "reader", "owner-only"}},
+		{"public statements", sids(document.PublicStatements()), []string{"public"}},
+		{"cross-account statements", sids(document.CrossAccountStatements("111111111111")), []string{"reader"}},
+		{"services", document.Services(), []string{"events.amazonaws.com", "sns.amazonaws.com"}},
+		{"actions", document.Statement[3].Action, []string{"sqs:ReceiveMessage", "sqs:DeleteMessage"}},
+		{"not actions", document.Statement[4].NotAction, []string{"sqs:SendMessage"}},
+	}
+	for _, c := range cases {
+		if !reflect.DeepEqual(c.got, c.expected) {
+			t.Errorf("Error finding %s. Expected %v, got %v", c.name, c.expected, c.got)
+		}
+	}
+
+	if _, err := Parse(`{"Statement": `); err == nil {
+		t.Errorf("Error parsing a truncated policy. Expected an error")
+	}
+}
+
+func TestParseSingleStatement(t *testing.T) {
+	document, err := Parse(`{"Version": "2012-10-17", "Statement": {"Sid": "only", "Effect": "Allow", "Principal": "*", "Action": "sns:Publish"}}`)
+	if err != nil {
+		t.Fatalf("Error parsing policy: %v", err)
+	}
+	if got := sids(document.PublicStatements()); !reflect.DeepEqual(got, []string{"only"}) {
+		t.Errorf("Error reading a policy with a single statement object. Got %v", got)
+	}
+}
+
+func TestRemoveStatements(t *testing.T) {
+	document, err := Parse(examplePolicy)
+	if err != nil {
+		t.Fatalf("Error parsing policy: %v", err)
+	}
+	updated := document.RemoveStatements(Statement.Public)
+	if len(document.Statement) != 5 {
+		t.Errorf("Error removing statements. Expected the original policy to be unchanged, got %v", sids(document.Statement))
+	}
+
+	// The other statements are written back exactly as they were, so fields
+	// like NotPrincipal, and the way values are written, survive
+	expected := `{
+  "Version": "2012-10-17",
+  "Id": "queue-policy",
+  "Statement": [
+    {
+      "Sid": "topic",
+      "Effect": "Allow",
+      "Principal": {
+        "AWS": "*"
+      },
+      "Action": "sqs:SendMessage",
+      "Resource": "arn:aws:sqs:eu-west-1:111111111111:queue",
+      "Condition": {
+        "ArnEquals": {
+          "aws:SourceArn": "arn:aws:sns:eu-west-1:111111111111:alerts"
+        }
+      }
+    },
+    {
+      "Sid": "events",
+      "Effect": "Allow",
+      "Principal": {
+        "Service": "events.amazonaws.com"
+      },
+      "Action": "sqs:SendMessage",
+      "Resource": "arn:aws:sqs:eu-west-1:111111111111:queue"
+    },
+    {
+      "Sid": "reader",
+      "Effect": "Allow",
+      "Principal": {
+        "AWS": "arn:aws:iam::222222222222:role/reader"
+      },
+      "Action": [
+        "sqs:ReceiveMessage",
+        "sqs:DeleteMessage"
+      ],
+      "Resource": "arn:aws:sqs:eu-west-1:111111111111:queue"
+    },
+    {
+      "Sid": "owner-only",
+      "Effect": "Deny",
+      "NotPrincipal": {
+        "AWS": "arn:aws:iam::111111111111:root"
+      },
+      "NotAction": "sqs:SendMessage",
+      "Resource": "arn:aws:sqs:eu-west-1:111111111111:queue"
+    }
+  ]
+}`
+	if got := updated.String(); got != expected {
+		t.Errorf("Error removing public statements. Expected %s, got %s", expected, got)
+	}
+}
+
+func TestStringPreservesFormat(t *testing.T) {
+	cases := map[string]string{
+		"compact":         `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"sns:Publish"}]}`,
+		"extra fields":    `{"Version":"2012-10-17","Id":"policy","Custom":{"a":1},"Statement":[]}`,
+		"tab indentation": "{\n\t\"Version\": \"2012-10-17\",\n\t\"Statement\": []\n}",
+	}
+	for name, document := range cases {
+		parsed, err := Parse(document)
+		if err != nil {
+			t.Errorf("Error parsing %s policy: %v", name, err)
+			continue
+		}
+		if got := parsed.String(); got != document {
+			t.Errorf("Error writing %s policy. Expected %s, got %s", name, document, got)
+		}
+	}
+}
+
+func TestAddStatements(t *testing.T) {
+	document, err := Parse(`{"Version":"2012-10-17","Statement":[{"Sid":"events","Effect":"Allow","Principal":{"Service":"events.amazonaws.com"},"Action":"sqs:SendMessage"}]}`)
+	if err != nil {
+		t.Fatalf("Error parsing policy: %v", err)
+	}
+
+	added := Statement{Sid: "reader", Effect: "Allow", Principal: Principals{"AWS": {"arn:aws:iam::222222222222:role/reader"}}, Action: Values{"sqs:ReceiveMessage"}}
+	updated, err := document.AddStatements(added)
+	if err != nil {
+		t.Fatalf("Error adding statement: %v", err)
+	}
+	expected := `{"Version":"2012-10-17","Statement":[{"Sid":"events","Effect":"Allow","Principal":{"Service":"events.amazonaws.com"},"Action":"sqs:SendMessage"},{"Sid":"reader","Effect":"Allow","Principal":{"AWS":"arn:aws:iam::222222222222:role/reader"},"Action":"sqs:ReceiveMessage"}]}`
+	if got := updated.String(); got != expected {
+		t.Errorf("Error adding statement. Expected %s, got %s", expected, got)
+	}
+	if len(document.Statement) != 1 {
+		t.Errorf("Error adding statement. Expected the original policy to be unchanged, got %v", sids(document.Statement))
+	}
+
+	if _, err := updated.AddStatements(Statement{Sid: "events", Effect: "Deny", Principal: Principals{"AWS": {"*"}}}); err == nil {
+		t.Errorf("Error adding a statement with a duplicate Sid. Expected an error")
+	}
+}
@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// Resource is a failing resource with a public policy, e.g. a topic or queue.
+type Resource struct {
+	Id         string // The resource's ARN or URL, as its service names it
+	Name       string
+	Policy     Document
+	SkipReason string // Set if the resource can't be changed, e.g. it no longer exists
+}
+
+// Change removes the public statements from a resource's policy.
+type Change struct {
+	Id      string
+	Name    string
+	Policy  Document // The policy before the change
+	Removed []Statement
+}
+
+// Updated is the policy to set, without the public statements. It is empty
+// if every statement was public, removing the policy altogether.
+func (c Change) Updated() string {
+	updated := c.Policy.RemoveStatements(Statement.Public)
+	if len(updated.Statement) == 0 {
+		return ""
+	}
+	return updated.String()
+}
+
+// Apply re-reads the resource's policy with read, and sets it without its
+// public statements with write, so statements added since the plan are kept.
+// Policy and Removed are updated to the policy actually replaced, for the
+// audit log. If emptyAllowed is false, a policy whose every statement is now
+// public is left for replacing by hand.
+func (c *Change) Apply(read func() (Document, error), write func(policy string) error, emptyAllowed bool) error {
+	current, err := read()
+	if err != nil {
+		return fmt.Errorf("failed to re-read policy of %s: %w", c.Name, err)
+	}
+	c.Policy = current
+	c.Removed = current.PublicStatements()
+	if len(c.Removed) == 0 {
+		// Fixed since the plan, so there is nothing to remove
+		return nil
+	}
+	if !emptyAllowed && len(c.Removed) == len(current.Statement) {
+		return fmt.Errorf("every statement of the policy of %s is now public, so it needs replacing by hand", c.Name)
+	}
+	return write(c.Updated())
+}
+
+// Parameters record the statements removed from a policy, with the original
+// policy to restore it, for the audit log.
+func (c Change) Parameters() map[string]any {
+	return map[string]any{
+		"Policy":   c.Updated(),
+		"Removed":  c.Removed,
+		"Rollback": map[string]any{"Policy": c.Policy.String()},
+	}
+}
+
+// removalSkipReason explains why the public statements can't be removed from
+// a resource's policy, or is empty.
+func removalSkipReason(r Resource, emptyAllowed bool) string {
+	if r.SkipReason != "" {
+		return r.SkipReason
+	}
+	public := r.Policy.PublicStatements()
+	if len(public) == 0 {
+		return "its policy is no longer public"
+	}
+	if !emptyAllowed && len(public) == len(r.Policy.Statement) {
+		return "every statement of its policy is public, so it needs replacing by hand"
+	}
+	return ""
+}
+
+// PlanRemovals prints each public statement of the failing resources'
+// policies, and returns the changes that remove them. Each change is applied to the policy
+// as it is then, see Change.Apply. Resources in stacks or
+// managed in code are left to be fixed there. If emptyAllowed is false, the
+// service won't accept a policy without statements, so resources whose every
+// statement is public are skipped.
+func PlanRemovals(noun string, resources []Resource, inStacks []common.StackResources, managed []common.ManagedBy, emptyAllowed bool) []*Change {
+	var skipped []common.Skipped
+	for _, r := range resources {
+		if reason := removalSkipReason(r, emptyAllowed); reason != "" {
+			skipped = append(skipped, common.Skipped{Name: r.Name, Reason: reason})
+		}
+	}
+	excluded := common.PrintExcluded(noun, skipped, inStacks, managed)
+
+	var changes []*Change
+	for _, r := range resources {
+		if removalSkipReason(r, emptyAllowed) == "" && !slices.Contains(excluded, r.Id) {
+			changes = append(changes, &Change{Id: r.Id, Name: r.Name, Policy: r.Policy, Removed: r.Policy.PublicStatements()})
+		}
+	}
+
+	if len(changes) > 0 {
+		fmt.Fprintln(common.Out, "\nRemoving the following public statements:")
+		for idx, change := range changes {
+			fmt.Fprintln(common.Out, idx+1, change.Name)
+			for _, statement := range change.Removed {
+				fmt.Fprintln(common.Out, "  ", statement)
+			}
+		}
+		fmt.Fprint(common.Out, "\n")
+	}
+
+	fmt.Fprintln(common.Out, len(resources), "failing", noun, "found.")
+	fmt.Fprintln(common.Out, len(changes), "to fix, and", len(resources)-len(changes), "to skip.")
+	return changes
+}
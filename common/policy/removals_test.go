@@ -0,0 +1,112 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func mustParse(t *testing.T, document string) Document {
+	t.Helper()
+	parsed, err := Parse(document)
+	if err != nil {
+		t.Fatalf("Error parsing policy: %v", err)
+	}
+	return parsed
+}
+
+func TestChangeUpdated(t *testing.T) {
+	allPublic := Change{Policy: mustParse(t, `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "sqs:*"}]}`)}
+	if updated := allPublic.Updated(); updated != "" {
+		t.Errorf("Error removing every statement. Expected the policy to be removed, got %s", updated)
+	}
+
+	mixed := Change{Policy: mustParse(t, `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"sqs:*"},{"Effect":"Allow","Principal":{"Service":"events.amazonaws.com"},"Action":"sqs:SendMessage"}]}`)}
+	expected := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"events.amazonaws.com"},"Action":"sqs:SendMessage"}]}`
+	if updated := mixed.Updated(); updated != expected {
+		t.Errorf("Error removing public statements. Expected %s, got %s", expected, updated)
+	}
+}
+
+func TestPlanRemovals(t *testing.T) {
+	public := mustParse(t, `{"Statement": [
+  {"Sid": "owner", "Effect": "Allow", "Principal": {"AWS": "*"}, "Action": "sns:Publish", "Condition": {"StringEquals": {"AWS:SourceOwner": "123456789012"}}},
+  {"Sid": "public", "Effect": "Allow", "Principal": "*", "Action": "sns:Subscribe"}
+]}`)
+	allPublic := mustParse(t, `{"Statement": [{"Sid": "public", "Effect": "Allow", "Principal": "*", "Action": "sns:Publish"}]}`)
+	resources := []Resource{
+		{Id: "public", Name: "public", Policy: public},
+		{Id: "all-public", Name: "all-public", Policy: allPublic},
+		{Id: "fixed-already", Name: "fixed-already"},
+		{Id: "deleted", Name: "deleted", Policy: public, SkipReason: "no longer exists"},
+		{Id: "in-stack", Name: "in-stack", Policy: public},
+	}
+	inStacks := []common.StackResources{{StackName: "stack", PhysicalIds: []string{"in-stack"}}}
+
+	cases := map[bool][]string{
+		false: {"public"},
+		true:  {"public", "all-public"},
+	}
+	for emptyAllowed, expected := range cases {
+		changes := PlanRemovals("topics", resources, inStacks, nil, emptyAllowed)
+		var ids []string
+		for _, change := range changes {
+			ids = append(ids, change.Id)
+			if len(change.Removed) != 1 || change.Removed[0].Sid != "public" {
+				t.Errorf("Error finding statements to remove from %s. Expected the public statement, got %v", change.Id, sids(change.Removed))
+			}
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("Error planning removals with emptyAllowed %v. Expected %v, got %v", emptyAllowed, expected, ids)
+		}
+	}
+}
+
+func TestChangeApply(t *testing.T) {
+	planned := mustParse(t, `{"Statement": [{"Sid": "public", "Effect": "Allow", "Principal": "*", "Action": "sqs:*"}, {"Sid": "events", "Effect": "Allow", "Principal": {"Service": "events.amazonaws.com"}, "Action": "sqs:SendMessage"}]}`)
+	// A statement was added since the plan, which must be kept
+	current := mustParse(t, `{"Statement": [{"Sid": "public", "Effect": "Allow", "Principal": "*", "Action": "sqs:*"}, {"Sid": "events", "Effect": "Allow", "Principal": {"Service": "events.amazonaws.com"}, "Action": "sqs:SendMessage"}, {"Sid": "new", "Effect": "Allow", "Principal": {"Service": "sns.amazonaws.com"}, "Action": "sqs:SendMessage"}]}`)
+	private := mustParse(t, `{"Statement": [{"Sid": "events", "Effect": "Allow", "Principal": {"Service": "events.amazonaws.com"}, "Action": "sqs:SendMessage"}]}`)
+	allPublic := mustParse(t, `{"Statement": [{"Sid": "public", "Effect": "Allow", "Principal": "*", "Action": "sqs:*"}]}`)
+
+	cases := []struct {
+		name         string
+		current      Document
+		emptyAllowed bool
+		written      []string // Sids of the policy written, or nil if none was
+		removed      []string
+		err          bool
+	}{
+		{"changed since the plan", current, false, []string{"events", "new"}, []string{"public"}, false},
+		{"fixed since the plan", private, false, nil, nil, false},
+		{"every statement public", allPublic, false, nil, []string{"public"}, true},
+		{"every statement public, empty allowed", allPublic, true, []string{}, []string{"public"}, false},
+	}
+	for _, c := range cases {
+		change := &Change{Id: "queue", Name: "queue", Policy: planned, Removed: planned.PublicStatements()}
+		var written []string
+		err := change.Apply(
+			func() (Document, error) { return c.current, nil },
+			func(updated string) error {
+				written = []string{}
+				if updated != "" {
+					written = sids(mustParse(t, updated).Statement)
+				}
+				return nil
+			},
+			c.emptyAllowed)
+		if (err != nil) != c.err {
+			t.Errorf("Error applying a change to a policy %s. Expected error %v, got %v", c.name, c.err, err)
+		}
+		if !reflect.DeepEqual(written, c.written) {
+			t.Errorf("Error applying a change to a policy %s. Expected to write %v, got %v", c.name, c.written, written)
+		}
+		if got := sids(change.Removed); !reflect.DeepEqual(got, c.removed) {
+			t.Errorf("Error recording the statements removed from a policy %s. Expected %v, got %v", c.name, c.removed, got)
+		}
+		if change.Parameters()["Rollback"].(map[string]any)["Policy"] != c.current.String() {
+			t.Errorf("Error recording the policy replaced %s. Expected %s, got %v", c.name, c.current, change.Parameters()["Rollback"])
+		}
+	}
+}
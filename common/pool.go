@@ -0,0 +1,36 @@
+package common
+
+import (
+	"context"
+	"sync"
+)
+
+// ParallelMap calls fn for every item, with at most concurrency calls in
+// flight at once. Results are returned in the same order as items, regardless
+// of the order in which the calls complete, so output built from them is stable.
+// Calls to ParallelMap from within fn have their own limit, so nesting it
+// multiplies the number of calls in flight.
+func ParallelMap[T any, R any](ctx context.Context, concurrency int, items []T, fn func(context.Context, T) R) []R {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]R, len(items))
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+
+	for i, item := range items {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = fn(ctx, item)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
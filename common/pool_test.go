@@ -0,0 +1,59 @@
+package common
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallelMapPreservesOrder(t *testing.T) {
+	items := []int{5, 4, 3, 2, 1}
+	result := ParallelMap(context.Background(), 3, items, func(_ context.Context, i int) int {
+		time.Sleep(time.Duration(i) * time.Millisecond) // Later items finish first
+		return i * 10
+	})
+	expected := []int{50, 40, 30, 20, 10}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Error preserving order. Expected %v, got %v", expected, result)
+	}
+}
+
+func TestParallelMapRespectsConcurrency(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	items := make([]int, 20)
+	ParallelMap(context.Background(), 4, items, func(_ context.Context, _ int) int {
+		n := inFlight.Add(1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		inFlight.Add(-1)
+		return 0
+	})
+	if maxInFlight.Load() > 4 {
+		t.Errorf("Error limiting concurrency. Expected at most 4 calls in flight, got %d", maxInFlight.Load())
+	}
+}
+
+func TestParallelMapOfEmptySlice(t *testing.T) {
+	result := ParallelMap(context.Background(), 4, []int{}, func(_ context.Context, i int) int {
+		return i
+	})
+	if len(result) != 0 {
+		t.Errorf("Error mapping empty slice")
+	}
+}
+
+func TestParallelMapWithInvalidConcurrency(t *testing.T) {
+	result := ParallelMap(context.Background(), 0, []int{1, 2}, func(_ context.Context, i int) int {
+		return i
+	})
+	if !reflect.DeepEqual(result, []int{1, 2}) {
+		t.Errorf("Error mapping with a concurrency below 1")
+	}
+}
@@ -0,0 +1,156 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Remediation describes how to fix one control. R is what a control learns
+// about one region's failing resources, and T is one change to make.
+type Remediation[R any, T any] struct {
+	ControlId string
+	Noun      string // The failing resources, e.g. "keys"
+	Changes   string // What each change is to, e.g. "topic policies". Defaults to Noun
+	Action    string // What the fix does, e.g. "turn on key rotation"
+	Evaluate  Evaluator
+
+	// Find looks up one region's failing resources. Regions are looked up in
+	// parallel.
+	Find func(ctx context.Context, failing FailingResources) (R, error)
+	// Plan prints what will happen in a region, and returns the changes to
+	// make and the patches proposed for stack-managed resources. Regions are
+	// planned one at a time, in order.
+	Plan func(region R) ([]T, []StackPatch)
+	// Apply makes one change. Audit describes it for the audit log.
+	Apply func(ctx context.Context, change Change[T]) error
+	Audit func(item T) (action string, resource string, parameters map[string]any)
+	// Verify checks one change has taken effect. Id names it in the report.
+	Verify func(ctx context.Context, change Change[T]) (bool, error)
+	Id     func(item T) string
+
+	// Checks are health checks specific to the control, for the canary.
+	Checks func(canary []Change[T]) []HealthCheck
+	// Notes are printed at the end, about the changes that were made.
+	Notes func(fixed []Change[T]) []string
+}
+
+func (r Remediation[R, T]) changes() string {
+	if r.Changes == "" {
+		return r.Noun
+	}
+	return r.Changes
+}
+
+// Remediate fixes a control in every region of the session: it finds the
+// failing resources, plans and confirms the changes, rolls them out, and
+// verifies them. Regions and changes that fail are recorded in the result,
+// so one bad region doesn't stop the rest. An error means the run itself
+// could not complete.
+func Remediate[R any, T any](ctx context.Context, sess *Session, opts Options, r Remediation[R, T]) (Result, error) {
+	result := Result{}
+
+	slog.Info("Retrieving control failures for "+r.ControlId, "source", opts.Source, "regions", len(sess.Regions))
+	failing := ParallelMap(ctx, opts.Concurrency, sess.Regions, func(ctx context.Context, region string) FailingResources {
+		return opts.FindFailing(ctx, sess, r.ControlId, r.Evaluate, region)
+	})
+	failing = LimitFailingResources(failing, opts.Limit)
+	PrintFailingResources(failing, r.Noun)
+
+	type regionResult struct {
+		region R
+		err    error
+	}
+	found := ParallelMap(ctx, opts.Concurrency, failing, func(ctx context.Context, f FailingResources) regionResult {
+		if f.Err != nil {
+			return regionResult{err: fmt.Errorf("could not retrieve failing %s: %w", r.Noun, f.Err)}
+		}
+		region, err := r.Find(ctx, f)
+		return regionResult{region: region, err: err}
+	})
+
+	var changes []Change[T]
+	for i, f := range failing {
+		fmt.Fprintf(Out, "Region %d: %s\n", i+1, f.Region)
+		if err := found[i].err; err != nil {
+			slog.Error("Error working out what to change", "control", r.ControlId, "region", f.Region, "error", err)
+			result.Fail(fmt.Errorf("%s: %w", f.Region, err))
+			fmt.Fprintf(Out, "----------------------------------------------------\n\n")
+			continue
+		}
+
+		if skipped := f.Skipped(); skipped > 0 {
+			slog.Info("Not processing more failing "+r.Noun+", due to -limit", "region", f.Region, "skipped", skipped)
+		}
+		items, patches := r.Plan(found[i].region)
+		for _, item := range items {
+			changes = append(changes, Change[T]{Region: f.Region, Item: item})
+		}
+		opts.ReportStackPatches(patches)
+		fmt.Fprintf(Out, "----------------------------------------------------\n\n")
+	}
+
+	changes, deferred := ApplyLimits(opts.Limits, changes)
+	if len(deferred) > 0 {
+		slog.Warn("Deferring "+r.changes()+" to a later run, to stay within the change limits", "deferred", len(deferred))
+	}
+	result.Remaining = len(changes) + len(deferred) + TotalSkipped(failing)
+
+	if !opts.Execute {
+		fmt.Fprintln(Out, "Skipping execution.")
+		fmt.Fprintf(Out, "Re-run with flag -execute to %s.\n", r.Action)
+		return result, nil
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(Out, "No %s to change.\n", r.changes())
+		return result, nil
+	}
+
+	PlanChanges(changes).Print(sess.AccountId, r.changes())
+	confirmed, err := opts.Confirm.Confirm(sess.AccountId)
+	if err != nil {
+		return result, fmt.Errorf("could not confirm changes: %w", err)
+	}
+	if !confirmed {
+		fmt.Fprintf(Out, "Exiting without changing any %s.\n", r.changes())
+		return result, nil
+	}
+
+	var checks []HealthCheck
+	if r.Checks != nil {
+		checks = r.Checks(CanaryChanges(opts.Canary, changes))
+	}
+	var fixed []Change[T]
+	err = RollOut(ctx, opts.Canary, changes, opts.HealthChecks(sess, Regions(changes), checks...), func(ctx context.Context, change Change[T]) {
+		err := r.Apply(ctx, change)
+		action, resource, parameters := r.Audit(change.Item)
+		opts.Audit.Record(change.Region, action, resource, parameters, err)
+		if err != nil {
+			slog.Error("Error applying fix", "control", r.ControlId, "region", change.Region, "resource", resource, "error", err)
+			result.Fail(fmt.Errorf("failed to %s for %s: %w", r.Action, resource, err))
+			return
+		}
+		fixed = append(fixed, change)
+	})
+	result.Remaining -= len(fixed)
+	// If the canary failed, the remaining changes were not attempted
+	result.Fail(err)
+
+	slog.Info("Verifying changes", "control", r.ControlId, "changes", len(fixed))
+	verifications := VerifyChanges(ctx, DefaultBackoff, opts.Concurrency, fixed, r.Id,
+		func(change Change[T]) Check {
+			return func(ctx context.Context) (bool, error) {
+				return r.Verify(ctx, change)
+			}
+		})
+	PrintVerifications(verifications, r.changes())
+	result.RecordVerifications(verifications)
+
+	if r.Notes != nil {
+		for _, note := range r.Notes(fixed) {
+			fmt.Fprintln(Out, note)
+		}
+	}
+	fmt.Fprintln(Out, "Please note it may take 24 hours for SecurityHub to update.")
+	return result, nil
+}
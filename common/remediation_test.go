@@ -0,0 +1,96 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func remediation(applied *[]string) Remediation[[]string, string] {
+	failing := map[string][]string{"eu-west-1": {"a", "b", "broken"}, "us-east-1": {"c"}}
+	return Remediation[[]string, string]{
+		ControlId: "TEST.1",
+		Noun:      "widgets",
+		Action:    "fix widgets",
+		Evaluate: func(_ context.Context, region string) ([]string, error) {
+			return failing[region], nil
+		},
+		Find: func(_ context.Context, f FailingResources) ([]string, error) {
+			if f.Region == "us-east-1" {
+				return nil, errors.New("access denied")
+			}
+			return f.Arns, nil
+		},
+		Plan: func(widgets []string) ([]string, []StackPatch) {
+			return widgets, nil
+		},
+		Apply: func(_ context.Context, change Change[string]) error {
+			if change.Item == "broken" {
+				return errors.New("widget is broken")
+			}
+			*applied = append(*applied, change.Item)
+			return nil
+		},
+		Audit: func(widget string) (string, string, map[string]any) {
+			return "test:FixWidget", widget, nil
+		},
+		Verify: func(_ context.Context, change Change[string]) (bool, error) {
+			return slices.Contains(*applied, change.Item), nil
+		},
+		Id: func(widget string) string { return widget },
+	}
+}
+
+func TestRemediate(t *testing.T) {
+	Out = io.Discard
+	defer func() { Out = os.Stdout }()
+
+	sess := &Session{AccountId: "123456789012", Regions: []string{"eu-west-1", "us-east-1"}}
+	opts := Options{Execute: true, Concurrency: 1, Source: SourceDirect, Confirm: Confirmation{Yes: true}}
+	var applied []string
+	result, err := Remediate(context.Background(), sess, opts, remediation(&applied))
+	if err != nil {
+		t.Fatalf("Error remediating: %v", err)
+	}
+	if !reflect.DeepEqual(applied, []string{"a", "b"}) {
+		t.Errorf("Error remediating. Expected a and b to be fixed, got %v", applied)
+	}
+	// One region failed, and one change failed and was left remaining
+	if result.Remaining != 1 || len(result.Failures) != 2 {
+		t.Errorf("Error remediating. Expected 1 remaining and 2 failures, got %d and %v", result.Remaining, result.Failures)
+	}
+}
+
+func TestRemediateDryRun(t *testing.T) {
+	Out = io.Discard
+	defer func() { Out = os.Stdout }()
+
+	sess := &Session{AccountId: "123456789012", Regions: []string{"eu-west-1"}}
+	opts := Options{Concurrency: 1, Source: SourceDirect}
+	var applied []string
+	result, err := Remediate(context.Background(), sess, opts, remediation(&applied))
+	if err != nil || len(applied) != 0 || result.Remaining != 3 {
+		t.Errorf("Error in dry run. Expected nothing applied and 3 remaining, got %v, %d, %v", applied, result.Remaining, err)
+	}
+}
+
+func TestRemediateCountsResourcesSkippedByLimit(t *testing.T) {
+	Out = io.Discard
+	defer func() { Out = os.Stdout }()
+
+	sess := &Session{AccountId: "123456789012", Regions: []string{"eu-west-1"}}
+	opts := Options{Execute: true, Limit: 2, Concurrency: 1, Source: SourceDirect, Confirm: Confirmation{Yes: true}}
+	var applied []string
+	result, err := Remediate(context.Background(), sess, opts, remediation(&applied))
+	if err != nil {
+		t.Fatalf("Error remediating: %v", err)
+	}
+	// Everything looked at was fixed, but -limit held one failing widget back
+	if !reflect.DeepEqual(applied, []string{"a", "b"}) || result.Remaining != 1 || len(result.Failures) != 0 {
+		t.Errorf("Error remediating with -limit. Expected a and b fixed and 1 remaining, got %v, %d, %v", applied, result.Remaining, result.Failures)
+	}
+}
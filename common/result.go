@@ -0,0 +1,79 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes, so that scheduled runs can tell the outcomes apart.
+const (
+	ExitCompliant      = 0 // Nothing left to fix
+	ExitError          = 1 // The run could not complete
+	ExitFindingsRemain = 2 // There are resources left to fix, e.g. in a dry run
+	ExitPartialFailure = 3 // Some regions or changes failed
+)
+
+// Result is the outcome of fixing a control across every region.
+type Result struct {
+	Remaining int     // Failing resources not fixed in this run, e.g. in a dry run, held back by -limit or deferred by limits
+	Failures  []error // Regions that could not be processed, and changes that failed or could not be verified
+}
+
+// Fail records a failure that didn't stop the run. A nil err is ignored.
+func (r *Result) Fail(err error) {
+	if err != nil {
+		r.Failures = append(r.Failures, err)
+	}
+}
+
+// RecordVerifications counts every change that could not be verified as a failure.
+func (r *Result) RecordVerifications(verifications []Verification) {
+	for _, v := range verifications {
+		if !v.Verified {
+			r.Fail(fmt.Errorf("%s in %s: %w", v.Id, v.Region, ErrUnverified))
+		}
+	}
+}
+
+func (r Result) Err() error {
+	return errors.Join(r.Failures...)
+}
+
+// PrintFailures lists every failure, split by whether re-running the tool
+// might fix it.
+func (r Result) PrintFailures() {
+	var retryable, terminal []error
+	for _, err := range r.Failures {
+		if IsRetryable(err) {
+			retryable = append(retryable, err)
+		} else {
+			terminal = append(terminal, err)
+		}
+	}
+	if len(retryable) > 0 {
+		fmt.Fprintln(Out, "\nFailures which may succeed if you re-run:")
+		for _, err := range retryable {
+			fmt.Fprintln(Out, "  "+err.Error())
+		}
+	}
+	if len(terminal) > 0 {
+		fmt.Fprintln(Out, "\nFailures which need fixing before re-running:")
+		for _, err := range terminal {
+			fmt.Fprintln(Out, "  "+err.Error())
+		}
+	}
+}
+
+// ExitCode maps the outcome of a run to the process exit code. err is an
+// error which stopped the run.
+func ExitCode(result Result, err error) int {
+	switch {
+	case err != nil:
+		return ExitError
+	case len(result.Failures) > 0:
+		return ExitPartialFailure
+	case result.Remaining > 0:
+		return ExitFindingsRemain
+	}
+	return ExitCompliant
+}
@@ -0,0 +1,43 @@
+package common
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	failed := Result{}
+	failed.Fail(errors.New("AccessDenied"))
+	failed.Fail(nil)
+
+	cases := []struct {
+		name     string
+		result   Result
+		err      error
+		expected int
+	}{
+		{"nothing to do", Result{}, nil, ExitCompliant},
+		{"dry run with findings", Result{Remaining: 3}, nil, ExitFindingsRemain},
+		{"some changes failed", failed, nil, ExitPartialFailure},
+		{"run stopped", failed, errors.New("could not read input"), ExitError},
+	}
+	for _, c := range cases {
+		if got := ExitCode(c.result, c.err); got != c.expected {
+			t.Errorf("Error mapping %s to an exit code. Expected %d, got %d", c.name, c.expected, got)
+		}
+	}
+	if len(failed.Failures) != 1 {
+		t.Errorf("Error recording failures. Expected nil errors to be ignored, got %v", failed.Failures)
+	}
+}
+
+func TestRecordVerifications(t *testing.T) {
+	result := Result{}
+	result.RecordVerifications([]Verification{
+		{Region: "eu-west-1", Id: "a", Verified: true},
+		{Region: "eu-west-1", Id: "b"},
+	})
+	if len(result.Failures) != 1 {
+		t.Errorf("Error recording verifications. Expected 1 failure, got %v", result.Failures)
+	}
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+const (
+	RetryModeStandard = "standard"
+	RetryModeAdaptive = "adaptive" // Also slows down client-side when throttled
+)
+
+// RetryPolicy controls how the SDK retries failed calls. Every client gets
+// its own retryer, so each service in each region has its own token bucket,
+// and throttling by one doesn't starve the others.
+type RetryPolicy struct {
+	Mode        string
+	MaxAttempts int
+	MaxBackoff  time.Duration
+	Tokens      uint // The retry token bucket's size. Each retry costs some tokens
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Mode:        RetryModeAdaptive,
+	MaxAttempts: 8,
+	MaxBackoff:  30 * time.Second,
+	Tokens:      retry.DefaultRetryRateTokens,
+}
+
+func (p RetryPolicy) Validate() error {
+	if p.Mode != RetryModeStandard && p.Mode != RetryModeAdaptive {
+		return fmt.Errorf("unknown retry mode %q. Expected %s or %s", p.Mode, RetryModeStandard, RetryModeAdaptive)
+	}
+	if p.MaxAttempts < 1 {
+		return errors.New("please provide at least 1 attempt")
+	}
+	// With fewer tokens than one retry costs, every retry would fail at once
+	if p.Tokens < retry.DefaultRetryCost {
+		return fmt.Errorf("please provide at least %d retry tokens, the cost of one retry", retry.DefaultRetryCost)
+	}
+	return nil
+}
+
+func (p RetryPolicy) retryer() aws.Retryer {
+	standardOptions := func(o *retry.StandardOptions) {
+		o.MaxAttempts = p.MaxAttempts
+		o.MaxBackoff = p.MaxBackoff
+		o.RateLimiter = ratelimit.NewTokenRateLimit(p.Tokens)
+	}
+	if p.Mode == RetryModeAdaptive {
+		return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+			o.StandardOptions = append(o.StandardOptions, standardOptions)
+		})
+	}
+	return retry.NewStandard(standardOptions)
+}
+
+// IsRetryable reports whether re-running might succeed where err failed,
+// e.g. after throttling or a network error, rather than needing someone to
+// fix permissions or configuration first.
+func IsRetryable(err error) bool {
+	var quotaErr ratelimit.QuotaExceededError
+	if errors.Is(err, ErrUnverified) || errors.As(err, &quotaErr) {
+		return true
+	}
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
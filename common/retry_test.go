@@ -0,0 +1,60 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+)
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"throttling", &smithy.GenericAPIError{Code: "Throttling"}, true},
+		{"request limit exceeded", &smithy.GenericAPIError{Code: "RequestLimitExceeded"}, true},
+		{"retries exhausted", &retry.MaxAttemptsError{Attempt: 8, Err: &smithy.GenericAPIError{Code: "ThrottlingException"}}, true},
+		{"retry quota exhausted", fmt.Errorf("failed to get rate limit token, %w", ratelimit.QuotaExceededError{}), true},
+		{"unverified", fmt.Errorf("my-bucket in eu-west-1: %w", ErrUnverified), true},
+		{"access denied", &smithy.GenericAPIError{Code: "AccessDenied"}, false},
+		{"canary failed", ErrCanaryFailed, false},
+		{"other", errors.New("bucket not found"), false},
+	}
+	for _, c := range cases {
+		if got := IsRetryable(fmt.Errorf("eu-west-1: %w", c.err)); got != c.expected {
+			t.Errorf("Error classifying %s. Expected retryable %v, got %v", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	if err := DefaultRetryPolicy.Validate(); err != nil {
+		t.Errorf("Error validating the default retry policy: %v", err)
+	}
+	if err := (RetryPolicy{Mode: "legacy", MaxAttempts: 3}).Validate(); err == nil {
+		t.Errorf("Error validating retry policy. Expected an error for an unknown mode")
+	}
+	if err := (RetryPolicy{Mode: RetryModeStandard}).Validate(); err == nil {
+		t.Errorf("Error validating retry policy. Expected an error for 0 attempts")
+	}
+	for _, tokens := range []uint{0, 4} {
+		if err := (RetryPolicy{Mode: RetryModeStandard, MaxAttempts: 3, Tokens: tokens}).Validate(); err == nil {
+			t.Errorf("Error validating retry policy. Expected an error for %d tokens", tokens)
+		}
+	}
+}
+
+func TestRetryPolicyMaxAttempts(t *testing.T) {
+	for _, mode := range []string{RetryModeStandard, RetryModeAdaptive} {
+		policy := DefaultRetryPolicy
+		policy.Mode = mode
+		if got := policy.retryer().MaxAttempts(); got != policy.MaxAttempts {
+			t.Errorf("Error building %s retryer. Expected %d attempts, got %d", mode, policy.MaxAttempts, got)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/securityhub"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Session is created once per run. It holds the caller identity and the
+// regions to operate on, and hands out clients for each region, creating
+// each one at most once.
+type Session struct {
+	Profile   string
+	AccountId string
+	Arn       string
+	Regions   []string
+
+	cfg     aws.Config
+	mu      sync.Mutex
+	clients map[string]any
+}
+
+func NewSession(ctx context.Context, profile string, region string, retryPolicy RetryPolicy) (*Session, error) {
+	cfg, err := Auth(ctx, profile, region, retryPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate with AWS: %w", err)
+	}
+
+	identity, err := validateCredentials(ctx, sts.NewFromConfig(cfg), profile)
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []string
+	if region == "" {
+		regions, err = listEnabledRegions(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list enabled regions: %w", err)
+		}
+	} else {
+		regions = []string{region}
+	}
+
+	return &Session{
+		Profile:   profile,
+		AccountId: *identity.Account,
+		Arn:       *identity.Arn,
+		Regions:   regions,
+		cfg:       cfg,
+		clients:   map[string]any{},
+	}, nil
+}
+
+// Config returns a copy of the session's config, pointed at region.
+func (s *Session) Config(region string) aws.Config {
+	cfg := s.cfg.Copy()
+	cfg.Region = region
+	return cfg
+}
+
+func client[T any](s *Session, service string, region string, newClient func(aws.Config) T) T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := service + "/" + region
+	if c, ok := s.clients[key]; ok {
+		return c.(T)
+	}
+	c := newClient(s.Config(region))
+	s.clients[key] = c
+	return c
+}
+
+func (s *Session) SecurityHub(region string) *securityhub.Client {
+	return client(s, "securityhub", region, func(cfg aws.Config) *securityhub.Client {
+		return securityhub.NewFromConfig(cfg)
+	})
+}
+
+func (s *Session) S3(region string) *s3.Client {
+	return client(s, "s3", region, func(cfg aws.Config) *s3.Client {
+		return s3.NewFromConfig(cfg)
+	})
+}
+
+func (s *Session) CloudFormation(region string) *cloudformation.Client {
+	return client(s, "cloudformation", region, func(cfg aws.Config) *cloudformation.Client {
+		return cloudformation.NewFromConfig(cfg)
+	})
+}
+
+func (s *Session) EC2(region string) *ec2.Client {
+	return client(s, "ec2", region, func(cfg aws.Config) *ec2.Client {
+		return ec2.NewFromConfig(cfg)
+	})
+}
+
+func (s *Session) KMS(region string) *kms.Client {
+	return client(s, "kms", region, func(cfg aws.Config) *kms.Client {
+		return kms.NewFromConfig(cfg)
+	})
+}
+
+func (s *Session) CloudWatch(region string) *cloudwatch.Client {
+	return client(s, "cloudwatch", region, func(cfg aws.Config) *cloudwatch.Client {
+		return cloudwatch.NewFromConfig(cfg)
+	})
+}
+
+func (s *Session) CloudWatchLogs(region string) *cloudwatchlogs.Client {
+	return client(s, "logs", region, func(cfg aws.Config) *cloudwatchlogs.Client {
+		return cloudwatchlogs.NewFromConfig(cfg)
+	})
+}
+
+func (s *Session) DynamoDB(region string) *dynamodb.Client {
+	return client(s, "dynamodb", region, func(cfg aws.Config) *dynamodb.Client {
+		return dynamodb.NewFromConfig(cfg)
+	})
+}
+
+func (s *Session) Lambda(region string) *lambda.Client {
+	return client(s, "lambda", region, func(cfg aws.Config) *lambda.Client {
+		return lambda.NewFromConfig(cfg)
+	})
+}
+
+func (s *Session) SNS(region string) *sns.Client {
+	return client(s, "sns", region, func(cfg aws.Config) *sns.Client {
+		return sns.NewFromConfig(cfg)
+	})
+}
+
+func (s *Session) SQS(region string) *sqs.Client {
+	return client(s, "sqs", region, func(cfg aws.Config) *sqs.Client {
+		return sqs.NewFromConfig(cfg)
+	})
+}
+
+func (s *Session) ECR(region string) *ecr.Client {
+	return client(s, "ecr", region, func(cfg aws.Config) *ecr.Client {
+		return ecr.NewFromConfig(cfg)
+	})
+}
+
+// CloudFront is a global service, so its client always uses us-east-1.
+func (s *Session) CloudFront() *cloudfront.Client {
+	return client(s, "cloudfront", defaultRegion, func(cfg aws.Config) *cloudfront.Client {
+		return cloudfront.NewFromConfig(cfg)
+	})
+}
+
+// IAM is a global service, so its client always uses us-east-1.
+func (s *Session) IAM() *iam.Client {
+	return client(s, "iam", defaultRegion, func(cfg aws.Config) *iam.Client {
+		return iam.NewFromConfig(cfg)
+	})
+}
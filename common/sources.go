@@ -0,0 +1,65 @@
+package common
+
+import (
+	"context"
+	"fmt"
+)
+
+// Where to find out which resources are failing a control.
+const (
+	SourceSecurityHub = "securityhub"
+	SourceDirect      = "direct" // Read the resources' configuration, for accounts without Security Hub
+	SourceBoth        = "both"   // Read both, and report where they disagree
+)
+
+func ValidateSource(source string) error {
+	switch source {
+	case SourceSecurityHub, SourceDirect, SourceBoth:
+		return nil
+	}
+	return fmt.Errorf("unknown source %q. Expected %s, %s or %s", source, SourceSecurityHub, SourceDirect, SourceBoth)
+}
+
+// Evaluator checks a control against live configuration, returning the ARNs
+// of failing resources in the same form as Security Hub.
+type Evaluator func(ctx context.Context, region string) ([]string, error)
+
+// FindFailing finds the resources failing a control in one region, from the
+// source chosen in the options. When comparing sources, live configuration
+// wins, since Security Hub can lag by up to a day.
+func (o Options) FindFailing(ctx context.Context, sess *Session, controlId string, evaluate Evaluator, region string) FailingResources {
+	var securityHub, direct []string
+	var err error
+
+	if o.Source == SourceSecurityHub {
+		securityHub, more, err := securityHubArns(ctx, sess.SecurityHub(region), controlId, sess.AccountId, region, o.Limit)
+		if err != nil {
+			return FailingResources{Region: region, Err: err}
+		}
+		failing := limitArns(region, securityHub, o.Limit)
+		if more {
+			// Count one more, so Skipped shows there are resources left for a later run
+			failing.Total++
+			failing.Partial = true
+		}
+		return failing
+	}
+	if o.Source == SourceBoth {
+		// Every finding is needed to compare against the live configuration
+		securityHub, _, err = securityHubArns(ctx, sess.SecurityHub(region), controlId, sess.AccountId, region, 0)
+		if err != nil {
+			return FailingResources{Region: region, Err: err}
+		}
+	}
+
+	direct, err = evaluate(ctx, region)
+	if err != nil {
+		return FailingResources{Region: region, Err: fmt.Errorf("failed to evaluate %s: %w", controlId, err)}
+	}
+	failing := limitArns(region, direct, o.Limit)
+	if o.Source == SourceBoth {
+		failing.OnlyInSecurityHub = Without(securityHub, direct)
+		failing.OnlyInDirect = Without(direct, securityHub)
+	}
+	return failing
+}
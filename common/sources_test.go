@@ -0,0 +1,14 @@
+package common
+
+import "testing"
+
+func TestValidateSource(t *testing.T) {
+	for _, source := range []string{SourceSecurityHub, SourceDirect, SourceBoth} {
+		if err := ValidateSource(source); err != nil {
+			t.Errorf("Error validating source %s: %v", source, err)
+		}
+	}
+	if err := ValidateSource("config"); err == nil {
+		t.Errorf("Error validating source. Expected an error for an unknown source")
+	}
+}
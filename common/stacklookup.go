@@ -0,0 +1,188 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	StackLookupAuto       = "auto"
+	StackLookupScan       = "scan"
+	StackLookupPhysicalId = "physical-id"
+)
+
+// StackLookup decides how to work out which resources belong to a
+// CloudFormation stack. Scanning reads every stack in the region, so its cost
+// grows with the number of stacks. Looking up by physical ID makes one call
+// per resource, so its cost grows with the number of findings. Auto picks
+// whichever needs fewer calls.
+type StackLookup struct {
+	Strategy string
+	Cache    StackCache
+}
+
+func ValidateStackLookupStrategy(strategy string) error {
+	if !slices.Contains([]string{StackLookupAuto, StackLookupScan, StackLookupPhysicalId}, strategy) {
+		return fmt.Errorf("unknown stack lookup strategy %q, expected one of auto, scan or physical-id", strategy)
+	}
+	return nil
+}
+
+// StackResources lists the resources a single stack manages.
+type StackResources struct {
+	StackName   string
+	PhysicalIds []string
+	LogicalIds  map[string]string // Keyed by physical ID
+}
+
+// FindResourcesInStacks works out which of ids, the physical IDs of resources
+// of type resourceType, are managed by a CloudFormation stack. The result is
+// grouped by stack, in a stable order.
+func (l StackLookup) FindResourcesInStacks(ctx context.Context, cfnClient *cloudformation.Client, accountId string, region string, resourceType string, ids []string, concurrency int) ([]StackResources, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	if l.Strategy == StackLookupPhysicalId {
+		slog.Debug("Looking up stacks by physical ID", "region", region, "resources", len(ids))
+		return findResourcesByPhysicalId(ctx, cfnClient, resourceType, ids, concurrency)
+	}
+
+	liveStacks, err := listLiveStacks(ctx, cfnClient)
+	if err != nil {
+		return nil, err
+	}
+	if stale := l.Cache.staleStackCount(liveStacks, accountId, region); l.Strategy == StackLookupAuto && len(ids) < stale {
+		slog.Debug("Looking up stacks by physical ID, as it is cheaper than a scan", "region", region, "resources", len(ids), "staleStacks", stale)
+		return findResourcesByPhysicalId(ctx, cfnClient, resourceType, ids, concurrency)
+	}
+
+	slog.Debug("Scanning stack resources", "region", region, "stacks", len(liveStacks))
+
+	inventory, err := buildStackInventory(ctx, cfnClient, l.Cache, liveStacks, accountId, region, concurrency)
+	if err != nil {
+		return nil, err
+	}
+	return filterInventory(inventory, resourceType, ids), nil
+}
+
+func filterInventory(inventory StackInventory, resourceType string, ids []string) []StackResources {
+	var res []StackResources
+	for _, stack := range inventory.Stacks {
+		matching := StackResources{StackName: stack.Name, LogicalIds: map[string]string{}}
+		for _, resource := range stack.Resources {
+			if resource.Type == resourceType && slices.Contains(ids, resource.PhysicalId) {
+				matching.PhysicalIds = append(matching.PhysicalIds, resource.PhysicalId)
+				matching.LogicalIds[resource.PhysicalId] = resource.LogicalId
+			}
+		}
+		if len(matching.PhysicalIds) > 0 {
+			res = append(res, matching)
+		}
+	}
+	return res
+}
+
+func isNotInStackError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) &&
+		apiErr.ErrorCode() == "ValidationError" &&
+		strings.Contains(apiErr.ErrorMessage(), "does not exist")
+}
+
+// stackForPhysicalId returns the name of the stack managing a resource, and
+// the resource's logical ID within it, or empty strings if it isn't managed by one.
+func stackForPhysicalId(ctx context.Context, cfnClient *cloudformation.Client, resourceType string, id string) (string, string, error) {
+	resp, err := cfnClient.DescribeStackResources(ctx, &cloudformation.DescribeStackResourcesInput{
+		PhysicalResourceId: &id,
+	})
+	if isNotInStackError(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to look up stack for %s: %w", id, err)
+	}
+	if len(resp.StackResources) == 0 {
+		return "", "", nil
+	}
+
+	for _, resource := range resp.StackResources {
+		if valueOrEmpty(resource.PhysicalResourceId) == id && valueOrEmpty(resource.ResourceType) == resourceType {
+			return valueOrEmpty(resource.StackName), valueOrEmpty(resource.LogicalResourceId), nil
+		}
+	}
+
+	// DescribeStackResources returns at most 100 of the owning stack's
+	// resources, so the resource may be past the cut-off. Page through the
+	// whole stack rather than treat it as unmanaged.
+	stackName := valueOrEmpty(resp.StackResources[0].StackName)
+	resources, err := getAllStackResources(ctx, cfnClient, stackName)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to look up stack for %s: %w", id, err)
+	}
+	for _, resource := range resources {
+		if resource.PhysicalId == id && resource.Type == resourceType {
+			return stackName, resource.LogicalId, nil
+		}
+	}
+	return "", "", nil
+}
+
+func findResourcesByPhysicalId(ctx context.Context, cfnClient *cloudformation.Client, resourceType string, ids []string, concurrency int) ([]StackResources, error) {
+	type lookupResult struct {
+		stackName string
+		logicalId string
+		err       error
+	}
+	results := ParallelMap(ctx, concurrency, ids, func(ctx context.Context, id string) lookupResult {
+		stackName, logicalId, err := stackForPhysicalId(ctx, cfnClient, resourceType, id)
+		return lookupResult{stackName: stackName, logicalId: logicalId, err: err}
+	})
+
+	var errs []error
+	stackNames := []string{}
+	byStack := map[string]*StackResources{}
+	for i, res := range results {
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		if res.stackName == "" {
+			continue
+		}
+		stack, seen := byStack[res.stackName]
+		if !seen {
+			stack = &StackResources{StackName: res.stackName, LogicalIds: map[string]string{}}
+			byStack[res.stackName] = stack
+			stackNames = append(stackNames, res.stackName)
+		}
+		stack.PhysicalIds = append(stack.PhysicalIds, ids[i])
+		stack.LogicalIds[ids[i]] = res.logicalId
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	slices.Sort(stackNames)
+	var res []StackResources
+	for _, name := range stackNames {
+		res = append(res, *byStack[name])
+	}
+	return res, nil
+}
+
+// ResourcesInStacks flattens grouped stack resources into a single list of IDs.
+func ResourcesInStacks(stackResources []StackResources) []string {
+	var ids []string
+	for _, stack := range stackResources {
+		ids = append(ids, stack.PhysicalIds...)
+	}
+	return ids
+}
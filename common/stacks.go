@@ -0,0 +1,251 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cfnTypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+type StackResource struct {
+	LogicalId  string
+	PhysicalId string
+	Type       string
+}
+
+type Stack struct {
+	Name        string
+	LastUpdated time.Time // Creation time, if the stack has never been updated
+	FetchedAt   time.Time
+	Resources   []StackResource
+}
+
+// StackInventory maps every live stack in a region to the resources it manages.
+type StackInventory struct {
+	AccountId string
+	Region    string
+	Stacks    []Stack
+}
+
+// PhysicalIds returns the physical IDs of every resource in the stack of the
+// given type, e.g. AWS::S3::Bucket.
+func (s Stack) PhysicalIds(resourceType string) []string {
+	var ids []string
+	for _, resource := range s.Resources {
+		if resource.Type == resourceType && resource.PhysicalId != "" {
+			ids = append(ids, resource.PhysicalId)
+		}
+	}
+	return ids
+}
+
+// StackCache persists stack inventories between runs. A cached stack is only
+// reused if it is younger than TTL and hasn't been updated since it was cached.
+// A zero TTL disables the cache.
+type StackCache struct {
+	Dir string
+	TTL time.Duration
+}
+
+func DefaultStackCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "fsbp-fix", "stacks")
+}
+
+func (c StackCache) path(accountId string, region string) string {
+	return filepath.Join(c.Dir, accountId, region+".json")
+}
+
+func (c StackCache) load(accountId string, region string) map[string]Stack {
+	cached := map[string]Stack{}
+	if c.TTL <= 0 {
+		return cached
+	}
+
+	data, err := os.ReadFile(c.path(accountId, region))
+	if errors.Is(err, os.ErrNotExist) {
+		return cached
+	}
+	if err != nil {
+		WarnOnError(err, "Failed to read stack cache")
+		return cached
+	}
+
+	var inventory StackInventory
+	if err := json.Unmarshal(data, &inventory); err != nil {
+		WarnOnError(err, "Ignoring corrupt stack cache")
+		return cached
+	}
+	for _, stack := range inventory.Stacks {
+		cached[stack.Name] = stack
+	}
+	return cached
+}
+
+func (c StackCache) save(inventory StackInventory) error {
+	if c.TTL <= 0 {
+		return nil
+	}
+
+	path := c.path(inventory.AccountId, inventory.Region)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(inventory)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename, so a concurrent or interrupted run never sees half a file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (c StackCache) reusable(cached Stack, lastUpdated time.Time, now time.Time) bool {
+	return cached.LastUpdated.Equal(lastUpdated) && now.Sub(cached.FetchedAt) < c.TTL
+}
+
+func stackLastUpdated(summary cfnTypes.StackSummary) time.Time {
+	if summary.LastUpdatedTime != nil {
+		return *summary.LastUpdatedTime
+	}
+	if summary.CreationTime != nil {
+		return *summary.CreationTime
+	}
+	return time.Time{}
+}
+
+func getAllStackSummaries(ctx context.Context, cfnClient *cloudformation.Client) ([]cfnTypes.StackSummary, error) {
+	var allStackSummaries []cfnTypes.StackSummary
+
+	input := &cloudformation.ListStacksInput{}
+	stackPaginator := cloudformation.NewListStacksPaginator(cfnClient, input)
+	for stackPaginator.HasMorePages() {
+		page, err := stackPaginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list stacks: %w", err)
+		}
+		allStackSummaries = append(allStackSummaries, page.StackSummaries...)
+	}
+
+	return allStackSummaries, nil
+}
+
+func getAllStackResources(ctx context.Context, cfnClient *cloudformation.Client, stackName string) ([]StackResource, error) {
+
+	allStackResources := []StackResource{}
+	stackResourcePaginator := cloudformation.NewListStackResourcesPaginator(cfnClient, &cloudformation.ListStackResourcesInput{StackName: &stackName})
+
+	for stackResourcePaginator.HasMorePages() { // We fetch 1MB at a time, so more than one page is unlikely
+		page, err := stackResourcePaginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get stack resources for stack %s: %w", stackName, err)
+		}
+		for _, summary := range page.StackResourceSummaries {
+			allStackResources = append(allStackResources, StackResource{
+				LogicalId:  valueOrEmpty(summary.LogicalResourceId),
+				PhysicalId: valueOrEmpty(summary.PhysicalResourceId),
+				Type:       valueOrEmpty(summary.ResourceType),
+			})
+		}
+	}
+
+	return allStackResources, nil
+}
+
+func valueOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func listLiveStacks(ctx context.Context, cfnClient *cloudformation.Client) ([]cfnTypes.StackSummary, error) {
+	allStackSummaries, err := getAllStackSummaries(ctx, cfnClient)
+	if err != nil {
+		return nil, err
+	}
+
+	var liveStacks []cfnTypes.StackSummary
+	for _, stack := range allStackSummaries {
+		if stack.StackStatus != cfnTypes.StackStatusDeleteComplete {
+			liveStacks = append(liveStacks, stack)
+		}
+	}
+	return liveStacks, nil
+}
+
+// buildStackInventory lists the resources in each of liveStacks. Stacks which
+// haven't changed since they were cached are not re-fetched. Any failure is
+// returned, rather than an incomplete inventory, because a missing stack would
+// make its resources look safe to change.
+func buildStackInventory(ctx context.Context, cfnClient *cloudformation.Client, cache StackCache, liveStacks []cfnTypes.StackSummary, accountId string, region string, concurrency int) (StackInventory, error) {
+	cached := cache.load(accountId, region)
+	now := time.Now()
+
+	type stackResult struct {
+		stack Stack
+		err   error
+	}
+	results := ParallelMap(ctx, concurrency, liveStacks, func(ctx context.Context, summary cfnTypes.StackSummary) stackResult {
+		lastUpdated := stackLastUpdated(summary)
+		if stack, ok := cached[*summary.StackName]; ok && cache.reusable(stack, lastUpdated, now) {
+			return stackResult{stack: stack}
+		}
+
+		resources, err := getAllStackResources(ctx, cfnClient, *summary.StackName)
+		return stackResult{
+			stack: Stack{
+				Name:        *summary.StackName,
+				LastUpdated: lastUpdated,
+				FetchedAt:   now,
+				Resources:   resources,
+			},
+			err: err,
+		}
+	})
+
+	inventory := StackInventory{AccountId: accountId, Region: region}
+	var errs []error
+	for _, res := range results {
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		inventory.Stacks = append(inventory.Stacks, res.stack)
+	}
+	if len(errs) > 0 {
+		return StackInventory{}, errors.Join(errs...)
+	}
+
+	WarnOnError(cache.save(inventory), "Failed to write stack cache")
+	return inventory, nil
+}
+
+// staleStackCount is the number of ListStackResources calls needed to build
+// an inventory from liveStacks, given what is already cached.
+func (c StackCache) staleStackCount(liveStacks []cfnTypes.StackSummary, accountId string, region string) int {
+	cached := c.load(accountId, region)
+	now := time.Now()
+
+	count := 0
+	for _, summary := range liveStacks {
+		stack, ok := cached[*summary.StackName]
+		if !ok || !c.reusable(stack, stackLastUpdated(summary), now) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStackCacheRoundTrip(t *testing.T) {
+	cache := StackCache{Dir: t.TempDir(), TTL: time.Hour}
+	stack := Stack{
+		Name:        "myStack",
+		LastUpdated: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		FetchedAt:   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		Resources:   []StackResource{{LogicalId: "Bucket", PhysicalId: "my-bucket", Type: "AWS::S3::Bucket"}},
+	}
+	err := cache.save(StackInventory{AccountId: "123456789012", Region: "eu-west-1", Stacks: []Stack{stack}})
+	if err != nil {
+		t.Fatalf("Error saving stack cache: %v", err)
+	}
+
+	cached := cache.load("123456789012", "eu-west-1")
+	if !reflect.DeepEqual(cached["myStack"], stack) {
+		t.Errorf("Error loading stack cache. Expected %v, got %v", stack, cached["myStack"])
+	}
+
+	if len(cache.load("123456789012", "us-east-1")) != 0 {
+		t.Errorf("Found cached stacks for a region that was never cached")
+	}
+}
+
+func TestDisabledStackCache(t *testing.T) {
+	cache := StackCache{Dir: t.TempDir(), TTL: 0}
+	err := cache.save(StackInventory{AccountId: "123456789012", Region: "eu-west-1", Stacks: []Stack{{Name: "myStack"}}})
+	if err != nil {
+		t.Fatalf("Error saving stack cache: %v", err)
+	}
+	if len(cache.load("123456789012", "eu-west-1")) != 0 {
+		t.Errorf("Found cached stacks when the cache is disabled")
+	}
+}
+
+func TestStackCacheReusable(t *testing.T) {
+	cache := StackCache{TTL: time.Hour}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	lastUpdated := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cached := Stack{Name: "myStack", LastUpdated: lastUpdated, FetchedAt: now.Add(-time.Minute)}
+
+	if !cache.reusable(cached, lastUpdated, now) {
+		t.Errorf("Expected an unchanged, recently fetched stack to be reusable")
+	}
+	if cache.reusable(cached, lastUpdated.Add(time.Second), now) {
+		t.Errorf("Expected a stack updated since it was cached to be refetched")
+	}
+	if cache.reusable(cached, lastUpdated, now.Add(2*time.Hour)) {
+		t.Errorf("Expected a stack cached longer than the TTL to be refetched")
+	}
+}
+
+func TestPhysicalIdsOfType(t *testing.T) {
+	stack := Stack{
+		Name: "myStack",
+		Resources: []StackResource{
+			{LogicalId: "Bucket", PhysicalId: "my-bucket", Type: "AWS::S3::Bucket"},
+			{LogicalId: "Queue", PhysicalId: "my-queue", Type: "AWS::SQS::Queue"},
+			{LogicalId: "Pending", PhysicalId: "", Type: "AWS::S3::Bucket"},
+		},
+	}
+	result := stack.PhysicalIds("AWS::S3::Bucket")
+	evaluateResult(t, result, []string{"my-bucket"}, "Error finding physical IDs of type")
+}
+
+func TestFilterInventory(t *testing.T) {
+	inventory := StackInventory{
+		Stacks: []Stack{
+			{Name: "stackA", Resources: []StackResource{
+				{LogicalId: "BucketA", PhysicalId: "bucket-a", Type: "AWS::S3::Bucket"},
+				{LogicalId: "BucketB", PhysicalId: "bucket-b", Type: "AWS::S3::Bucket"},
+			}},
+			{Name: "stackB", Resources: []StackResource{
+				{LogicalId: "BucketC", PhysicalId: "bucket-c", Type: "AWS::S3::Bucket"},
+			}},
+			{Name: "stackC", Resources: []StackResource{
+				{LogicalId: "Group", PhysicalId: "bucket-a", Type: "AWS::EC2::SecurityGroup"},
+			}},
+		},
+	}
+	result := filterInventory(inventory, "AWS::S3::Bucket", []string{"bucket-a", "bucket-c", "bucket-d"})
+	expected := []StackResources{
+		{StackName: "stackA", PhysicalIds: []string{"bucket-a"}, LogicalIds: map[string]string{"bucket-a": "BucketA"}},
+		{StackName: "stackB", PhysicalIds: []string{"bucket-c"}, LogicalIds: map[string]string{"bucket-c": "BucketC"}},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Error filtering inventory. Expected %v, got %v", expected, result)
+	}
+}
+
+func TestResourcesInStacks(t *testing.T) {
+	stackResources := []StackResources{
+		{StackName: "stackA", PhysicalIds: []string{"a", "b"}},
+		{StackName: "stackB", PhysicalIds: []string{"c"}},
+	}
+	evaluateResult(t, ResourcesInStacks(stackResources), []string{"a", "b", "c"}, "Error flattening stack resources")
+}
@@ -0,0 +1,286 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cfnTypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	TemplateFormatJson = "json"
+	TemplateFormatYaml = "yaml"
+)
+
+// StackTemplate is a parsed CloudFormation template. YAML short-form
+// intrinsic functions such as !Ref are read as their plain values, which is
+// enough to find resources and their properties.
+type StackTemplate struct {
+	Format string
+	Body   map[string]any
+}
+
+func ParseTemplate(body string) (StackTemplate, error) {
+	format := TemplateFormatYaml
+	if strings.HasPrefix(strings.TrimSpace(body), "{") {
+		format = TemplateFormatJson
+	}
+
+	// JSON is a subset of YAML, so one parser handles both
+	var parsed map[string]any
+	if err := yaml.Unmarshal([]byte(body), &parsed); err != nil {
+		return StackTemplate{}, fmt.Errorf("failed to parse template: %w", err)
+	}
+	return StackTemplate{Format: format, Body: parsed}, nil
+}
+
+func GetStackTemplate(ctx context.Context, cfnClient *cloudformation.Client, stackName string) (StackTemplate, error) {
+	resp, err := cfnClient.GetTemplate(ctx, &cloudformation.GetTemplateInput{
+		StackName:     &stackName,
+		TemplateStage: cfnTypes.TemplateStageOriginal,
+	})
+	if err != nil {
+		return StackTemplate{}, fmt.Errorf("failed to get template for stack %s: %w", stackName, err)
+	}
+	return ParseTemplate(valueOrEmpty(resp.TemplateBody))
+}
+
+func (t StackTemplate) resources() map[string]any {
+	resources, _ := t.Body["Resources"].(map[string]any)
+	return resources
+}
+
+func (t StackTemplate) Resource(logicalId string) (map[string]any, bool) {
+	resource, ok := t.resources()[logicalId].(map[string]any)
+	return resource, ok
+}
+
+// Properties returns a resource's properties, or nil if it has none.
+func (t StackTemplate) Properties(logicalId string) map[string]any {
+	resource, _ := t.Resource(logicalId)
+	properties, _ := resource["Properties"].(map[string]any)
+	return properties
+}
+
+// CdkPath returns the construct path of a resource synthesised by the CDK,
+// which tells owners where to make the change, or an empty string.
+func (t StackTemplate) CdkPath(logicalId string) string {
+	resource, _ := t.Resource(logicalId)
+	metadata, _ := resource["Metadata"].(map[string]any)
+	path, _ := metadata["aws:cdk:path"].(string)
+	return path
+}
+
+// References reports whether value is a Ref or GetAtt pointing at logicalId,
+// in either long or short form, or a list containing one.
+func References(value any, logicalId string) bool {
+	switch v := value.(type) {
+	case []any:
+		return slices.ContainsFunc(v, func(item any) bool { return References(item, logicalId) })
+	case string:
+		// Short-form !Ref X and !GetAtt X.Attr are read as plain strings
+		return v == logicalId || strings.HasPrefix(v, logicalId+".")
+	case map[string]any:
+		if ref, ok := v["Ref"]; ok {
+			return ref == logicalId
+		}
+		if getAtt, ok := v["Fn::GetAtt"].([]any); ok && len(getAtt) > 0 {
+			return getAtt[0] == logicalId
+		}
+		if getAtt, ok := v["Fn::GetAtt"].(string); ok {
+			return strings.HasPrefix(getAtt, logicalId+".")
+		}
+	}
+	return false
+}
+
+// ResourcesWithProperty returns the logical IDs of resources of the given type
+// whose property references logicalId, e.g. every AWS::EC2::SecurityGroupIngress
+// whose GroupId points at a security group. The result is sorted.
+func (t StackTemplate) ResourcesWithProperty(resourceType string, property string, logicalId string) []string {
+	var ids []string
+	for id, r := range t.resources() {
+		resource, _ := r.(map[string]any)
+		if resource["Type"] != resourceType {
+			continue
+		}
+		if References(t.Properties(id)[property], logicalId) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Render formats a template fragment in the same format as the template.
+func (t StackTemplate) Render(value any) string {
+	if t.Format == TemplateFormatJson {
+		out, _ := json.MarshalIndent(value, "", "  ")
+		return string(out)
+	}
+	out, _ := yaml.Marshal(value)
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+// PatchOp is a single RFC 6902 JSON Patch operation.
+type PatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+// ResourcePointer returns the JSON Pointer to a resource, or to a path within it.
+func ResourcePointer(logicalId string, path ...string) string {
+	escape := strings.NewReplacer("~", "~0", "/", "~1")
+	pointer := "/Resources/" + escape.Replace(logicalId)
+	for _, p := range path {
+		pointer += "/" + escape.Replace(p)
+	}
+	return pointer
+}
+
+type ResourcePatch struct {
+	LogicalId  string
+	PhysicalId string
+	CdkPath    string
+	Ops        []PatchOp
+}
+
+// StackPatch is a proposed change to a stack's template, for owners to apply
+// in the code that defines the stack.
+type StackPatch struct {
+	StackName string
+	Region    string
+	Template  StackTemplate
+	Resources []ResourcePatch
+	Err       error
+}
+
+// PatchBuilder works out the changes needed to fix one resource in a template.
+type PatchBuilder func(template StackTemplate, logicalId string) []PatchOp
+
+// SetOp sets key within parent, the object at path in resource logicalId,
+// replacing the value if parent already has one.
+func SetOp(parent map[string]any, key string, value any, logicalId string, path ...string) PatchOp {
+	op := "add"
+	if _, exists := parent[key]; exists {
+		op = "replace"
+	}
+	return PatchOp{
+		Op:    op,
+		Path:  ResourcePointer(logicalId, slices.Concat(path, []string{key})...),
+		Value: value,
+	}
+}
+
+// PropertiesPatch proposes the template change that sets each of values as a
+// property of a stack-managed resource, in key order.
+func PropertiesPatch(values map[string]any) PatchBuilder {
+	return func(template StackTemplate, logicalId string) []PatchOp {
+		properties := template.Properties(logicalId)
+		if properties == nil {
+			return []PatchOp{{
+				Op:    "add",
+				Path:  ResourcePointer(logicalId, "Properties"),
+				Value: values,
+			}}
+		}
+		var ops []PatchOp
+		for _, key := range slices.Sorted(maps.Keys(values)) {
+			ops = append(ops, SetOp(properties, key, values[key], logicalId, "Properties"))
+		}
+		return ops
+	}
+}
+
+// PropertyPatch proposes the template change that sets one property of a
+// stack-managed resource to value.
+func PropertyPatch(property string, value any) PatchBuilder {
+	return PropertiesPatch(map[string]any{property: value})
+}
+
+// BuildStackPatches fetches the template of every stack, and proposes a patch
+// fixing each of the given resources in it.
+func BuildStackPatches(ctx context.Context, cfnClient *cloudformation.Client, region string, stacks []StackResources, build PatchBuilder, concurrency int) []StackPatch {
+	return ParallelMap(ctx, concurrency, stacks, func(ctx context.Context, stack StackResources) StackPatch {
+		template, err := GetStackTemplate(ctx, cfnClient, stack.StackName)
+		if err != nil {
+			return StackPatch{StackName: stack.StackName, Region: region, Err: err}
+		}
+
+		patch := StackPatch{StackName: stack.StackName, Region: region, Template: template}
+		for _, physicalId := range stack.PhysicalIds {
+			logicalId := stack.LogicalIds[physicalId]
+			if _, ok := template.Resource(logicalId); !ok {
+				continue // e.g. created by a nested stack or a custom resource
+			}
+			ops := build(template, logicalId)
+			if len(ops) == 0 {
+				continue
+			}
+			patch.Resources = append(patch.Resources, ResourcePatch{
+				LogicalId:  logicalId,
+				PhysicalId: physicalId,
+				CdkPath:    template.CdkPath(logicalId),
+				Ops:        ops,
+			})
+		}
+		return patch
+	})
+}
+
+func (p StackPatch) Ops() []PatchOp {
+	var ops []PatchOp
+	for _, resource := range p.Resources {
+		ops = append(ops, resource.Ops...)
+	}
+	return ops
+}
+
+// Describe explains the patch for a human, showing each change as a fragment
+// in the template's own format.
+func (p StackPatch) Describe() string {
+	if p.Err != nil {
+		return fmt.Sprintf("Stack %s: could not propose a patch: %v\n", p.StackName, p.Err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Stack %s: proposed %s template changes\n", p.StackName, p.Template.Format)
+	for _, resource := range p.Resources {
+		fmt.Fprintf(&b, "  %s (%s)\n", resource.LogicalId, resource.PhysicalId)
+		if resource.CdkPath != "" {
+			fmt.Fprintf(&b, "  Defined by CDK construct %s\n", resource.CdkPath)
+		}
+		for _, op := range resource.Ops {
+			fmt.Fprintf(&b, "    %s %s\n", op.Op, op.Path)
+			if op.Value != nil {
+				for _, line := range strings.Split(p.Template.Render(op.Value), "\n") {
+					fmt.Fprintf(&b, "      %s\n", line)
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+// WriteStackPatch saves a patch as a JSON Patch document named after the
+// stack and its region.
+func WriteStackPatch(dir string, patch StackPatch) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(patch.Ops(), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s.%s.patch.json", patch.StackName, patch.Region))
+	return path, os.WriteFile(path, data, 0o644)
+}
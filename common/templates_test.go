@@ -0,0 +1,95 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+const exampleYamlTemplate = `
+Resources:
+  Group:
+    Type: AWS::EC2::SecurityGroup
+    Metadata:
+      aws:cdk:path: MyStack/Group/Resource
+    Properties:
+      VpcId: !Ref Vpc
+  IngressShort:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !GetAtt Group.GroupId
+  IngressLong:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId:
+        Ref: Group
+  OtherIngress:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !Ref OtherGroup
+  Topic:
+    Type: AWS::SNS::Topic
+  TopicPolicy:
+    Type: AWS::SNS::TopicPolicy
+    Properties:
+      Topics:
+        - !Ref OtherTopic
+        - !Ref Topic
+`
+
+func TestParseTemplateFormat(t *testing.T) {
+	yamlTemplate, err := ParseTemplate(exampleYamlTemplate)
+	if err != nil || yamlTemplate.Format != TemplateFormatYaml {
+		t.Errorf("Error parsing YAML template: %v", err)
+	}
+
+	jsonTemplate, err := ParseTemplate(`{"Resources": {"Bucket": {"Type": "AWS::S3::Bucket"}}}`)
+	if err != nil || jsonTemplate.Format != TemplateFormatJson {
+		t.Errorf("Error parsing JSON template: %v", err)
+	}
+	if _, ok := jsonTemplate.Resource("Bucket"); !ok {
+		t.Errorf("Error finding resource in JSON template")
+	}
+}
+
+func TestCdkPath(t *testing.T) {
+	template, _ := ParseTemplate(exampleYamlTemplate)
+	if path := template.CdkPath("Group"); path != "MyStack/Group/Resource" {
+		t.Errorf("Error finding CDK path. Expected MyStack/Group/Resource, got %s", path)
+	}
+	if path := template.CdkPath("IngressLong"); path != "" {
+		t.Errorf("Error finding CDK path. Expected none, got %s", path)
+	}
+}
+
+func TestResourcesWithProperty(t *testing.T) {
+	template, _ := ParseTemplate(exampleYamlTemplate)
+	result := template.ResourcesWithProperty("AWS::EC2::SecurityGroupIngress", "GroupId", "Group")
+	evaluateResult(t, result, []string{"IngressLong", "IngressShort"}, "Error finding resources referencing a security group")
+
+	result = template.ResourcesWithProperty("AWS::SNS::TopicPolicy", "Topics", "Topic")
+	evaluateResult(t, result, []string{"TopicPolicy"}, "Error finding resources referencing a topic in a list")
+}
+
+func TestResourcePointerEscaping(t *testing.T) {
+	if pointer := ResourcePointer("a/b~c", "Properties"); pointer != "/Resources/a~1b~0c/Properties" {
+		t.Errorf("Error escaping JSON pointer. Got %s", pointer)
+	}
+}
+
+func TestPropertiesPatch(t *testing.T) {
+	template, _ := ParseTemplate(exampleYamlTemplate)
+	build := PropertiesPatch(map[string]any{"VpcId": "vpc-1", "GroupDescription": "Example"})
+
+	expected := []PatchOp{
+		{Op: "add", Path: "/Resources/Group/Properties/GroupDescription", Value: "Example"},
+		{Op: "replace", Path: "/Resources/Group/Properties/VpcId", Value: "vpc-1"},
+	}
+	if result := build(template, "Group"); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Error setting properties. Expected %v, got %v", expected, result)
+	}
+
+	expected = []PatchOp{{Op: "add", Path: "/Resources/Topic/Properties", Value: map[string]any{"VpcId": "vpc-1", "GroupDescription": "Example"}}}
+	if result := build(template, "Topic"); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Error setting properties of a resource without any. Expected %v, got %v", expected, result)
+	}
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Backoff controls how long we keep re-reading a resource, waiting for a
+// change to become visible.
+type Backoff struct {
+	Attempts int
+	Initial  time.Duration
+	Max      time.Duration
+}
+
+var DefaultBackoff = Backoff{Attempts: 6, Initial: time.Second, Max: 16 * time.Second}
+
+// Check reports whether a resource is now in the desired state.
+type Check func(ctx context.Context) (bool, error)
+
+// Poll runs check until it passes, doubling the delay between attempts. It
+// returns the last error if the check never passed.
+func (b Backoff) Poll(ctx context.Context, check Check) (bool, error) {
+	delay := b.Initial
+	var err error
+	for attempt := 1; ; attempt++ {
+		var ok bool
+		ok, err = check(ctx)
+		if ok && err == nil {
+			return true, nil
+		}
+		if attempt >= b.Attempts {
+			return false, err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+		delay = min(delay*2, b.Max)
+	}
+}
+
+var ErrUnverified = errors.New("change could not be verified")
+
+// Verification is whether a change was confirmed by re-reading the resource.
+type Verification struct {
+	Region   string
+	Id       string
+	Verified bool
+	Err      error
+}
+
+// VerifyChanges re-reads every changed resource, concurrently, and reports
+// whether each is now in the desired state.
+func VerifyChanges[T any](ctx context.Context, b Backoff, concurrency int, changes []Change[T], id func(T) string, check func(Change[T]) Check) []Verification {
+	return ParallelMap(ctx, concurrency, changes, func(ctx context.Context, change Change[T]) Verification {
+		verified, err := b.Poll(ctx, check(change))
+		return Verification{Region: change.Region, Id: id(change.Item), Verified: verified, Err: err}
+	})
+}
+
+// PrintVerifications is the final report of which changes were verified.
+func PrintVerifications(verifications []Verification, noun string) {
+	verified := 0
+	for _, v := range verifications {
+		if v.Verified {
+			verified++
+		}
+	}
+	fmt.Fprintf(Out, "\nVerified %d of %d changed %s.\n", verified, len(verifications), noun)
+	for _, v := range verifications {
+		if v.Verified {
+			continue
+		}
+		reason := "still not in the desired state"
+		if v.Err != nil {
+			reason = v.Err.Error()
+		}
+		fmt.Fprintf(Out, "  Unverified: %s (%s): %s\n", v.Id, v.Region, reason)
+	}
+}
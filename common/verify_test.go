@@ -0,0 +1,45 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testBackoff = Backoff{Attempts: 3, Initial: time.Millisecond, Max: time.Millisecond}
+
+func TestPollRetriesUntilVerified(t *testing.T) {
+	calls := 0
+	verified, err := testBackoff.Poll(context.Background(), func(_ context.Context) (bool, error) {
+		calls++
+		return calls == 2, nil
+	})
+	if !verified || err != nil || calls != 2 {
+		t.Errorf("Error polling. Expected verified after 2 calls, got %v, %v after %d", verified, err, calls)
+	}
+}
+
+func TestPollGivesUp(t *testing.T) {
+	calls := 0
+	denied := errors.New("AccessDenied")
+	verified, err := testBackoff.Poll(context.Background(), func(_ context.Context) (bool, error) {
+		calls++
+		return false, denied
+	})
+	if verified || !errors.Is(err, denied) || calls != 3 {
+		t.Errorf("Error polling. Expected to give up with the last error after 3 calls, got %v, %v after %d", verified, err, calls)
+	}
+}
+
+func TestVerifyChanges(t *testing.T) {
+	changes := changesIn("eu-west-1", "us-east-1")
+	verifications := VerifyChanges(context.Background(), testBackoff, 2, changes,
+		func(i int) string { return string(rune('a' + i)) },
+		func(c Change[int]) Check {
+			return func(_ context.Context) (bool, error) { return c.Region == "eu-west-1", nil }
+		})
+	if !verifications[0].Verified || verifications[1].Verified || verifications[1].Id != "b" {
+		t.Errorf("Error verifying changes. Got %v", verifications)
+	}
+}
@@ -0,0 +1,227 @@
+package dynamodbutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// pitrPricePerGbMonth is roughly what point-in-time recovery costs per GB of
+// table each month, in US dollars. Prices vary a little between regions, so
+// this is only a guide.
+const pitrPricePerGbMonth = 0.20
+
+// table is a failing table, and what enabling point-in-time recovery would cost.
+type table struct {
+	Name       string
+	Arn        string
+	SizeBytes  int64
+	Replicas   []string // Regions of the other replicas, if it's a global table
+	Tags       map[string]string
+	SkipReason string // Why we won't enable point-in-time recovery, if we won't
+}
+
+// MonthlyCost estimates what point-in-time recovery will cost for the table.
+func (t table) MonthlyCost() float64 {
+	return float64(t.SizeBytes) / (1024 * 1024 * 1024) * pitrPricePerGbMonth
+}
+
+// Describe is one line identifying a table and its cost, e.g.
+// orders: 12.0 GiB, about $2.40 a month (global table, also in us-east-1).
+func (t table) Describe() string {
+	description := fmt.Sprintf("%s: %s, about $%.2f a month", t.Name, common.FormatBytes(t.SizeBytes), t.MonthlyCost())
+	if len(t.Replicas) > 0 {
+		description += fmt.Sprintf(" (global table, also in %s, which are fixed separately)", strings.Join(t.Replicas, ", "))
+	}
+	return description
+}
+
+func getTableTags(ctx context.Context, dynamodbClient *dynamodb.Client, arn string) (map[string]string, error) {
+	tags := map[string]string{}
+	input := &dynamodb.ListTagsOfResourceInput{ResourceArn: &arn}
+	for {
+		resp, err := dynamodbClient.ListTagsOfResource(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tags for table %s: %w", tableName(arn), err)
+		}
+		for _, tag := range resp.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+		if resp.NextToken == nil {
+			return tags, nil
+		}
+		input.NextToken = resp.NextToken
+	}
+}
+
+// toTable reads what we need from a table's description. Replicas in region
+// are left out, as that is the table itself.
+func toTable(description *dynamodbTypes.TableDescription, region string) table {
+	t := table{
+		Name:      aws.ToString(description.TableName),
+		Arn:       aws.ToString(description.TableArn),
+		SizeBytes: aws.ToInt64(description.TableSizeBytes),
+	}
+	for _, replica := range description.Replicas {
+		if replicaRegion := aws.ToString(replica.RegionName); replicaRegion != region {
+			t.Replicas = append(t.Replicas, replicaRegion)
+		}
+	}
+	if description.TableStatus != dynamodbTypes.TableStatusActive {
+		t.SkipReason = fmt.Sprintf("table is %s", description.TableStatus)
+	}
+	return t
+}
+
+func getTable(ctx context.Context, dynamodbClient *dynamodb.Client, name string, region string) (table, error) {
+	resp, err := dynamodbClient.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &name})
+	var notFound *dynamodbTypes.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return table{Name: name, SkipReason: "no longer exists"}, nil
+	}
+	if err != nil {
+		return table{}, fmt.Errorf("failed to describe table %s: %w", name, err)
+	}
+
+	t := toTable(resp.Table, region)
+	if t.SkipReason != "" {
+		return t, nil
+	}
+	t.Tags, err = getTableTags(ctx, dynamodbClient, t.Arn)
+	if err != nil {
+		return table{}, err
+	}
+	return t, nil
+}
+
+// RegionTables is everything we need to know about a region to decide which
+// tables to protect. It is gathered concurrently and printed afterwards.
+type RegionTables struct {
+	Region         string
+	FailingTables  []table
+	TablesInStacks []common.StackResources
+	ManagedInCode  []common.ManagedBy // Managed by IaC other than CloudFormation
+	StackPatches   []common.StackPatch
+	Err            error
+}
+
+func FindRegionTables(ctx context.Context, dynamodbClient *dynamodb.Client, cfnClient *cloudformation.Client, opts common.Options, failing common.FailingResources, accountId string) RegionTables {
+	region := failing.Region
+	var names []string
+	for _, arn := range failing.Arns {
+		names = append(names, tableName(arn))
+	}
+
+	type tableResult struct {
+		table table
+		err   error
+	}
+	results := common.ParallelMap(ctx, opts.Concurrency, names, func(ctx context.Context, name string) tableResult {
+		t, err := getTable(ctx, dynamodbClient, name, region)
+		return tableResult{table: t, err: err}
+	})
+	var failingTables []table
+	var fixable []string
+	var errs []error
+	for _, res := range results {
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		failingTables = append(failingTables, res.table)
+		if res.table.SkipReason == "" {
+			fixable = append(fixable, res.table.Name)
+		}
+	}
+	if len(errs) > 0 {
+		return RegionTables{Region: region, Err: fmt.Errorf("could not describe failing tables: %w", errors.Join(errs...))}
+	}
+
+	// Global tables may be defined as either type
+	var tablesInStacks []common.StackResources
+	for _, resourceType := range []string{common.ResourceTypeDynamoDbTable, common.ResourceTypeGlobalTable} {
+		inStacks, err := opts.StackLookup.FindResourcesInStacks(ctx, cfnClient, accountId, region, resourceType, fixable, opts.Concurrency)
+		if err != nil {
+			return RegionTables{Region: region, Err: fmt.Errorf("could not determine which tables are in CloudFormation stacks: %w", err)}
+		}
+		tablesInStacks = append(tablesInStacks, inStacks...)
+	}
+
+	notInStacks := common.Without(fixable, common.ResourcesInStacks(tablesInStacks))
+	var resources []common.ManagedResource
+	for _, t := range failingTables {
+		if slices.Contains(notInStacks, t.Name) {
+			resources = append(resources, common.ManagedResource{Id: t.Name, Type: common.ResourceTypeDynamoDbTable, Tags: t.Tags})
+		}
+	}
+
+	return RegionTables{
+		Region:         region,
+		FailingTables:  failingTables,
+		TablesInStacks: tablesInStacks,
+		ManagedInCode:  opts.Detectors.Detect(resources),
+		StackPatches:   common.BuildStackPatches(ctx, cfnClient, region, tablesInStacks, PitrPatch(region), opts.Concurrency),
+	}
+}
+
+// FindTablesToProtect prints what we found in a region, with what each table
+// will cost, and returns the tables to enable point-in-time recovery for.
+func FindTablesToProtect(regionTables RegionTables) []string {
+	var skipped []common.Skipped
+	for _, t := range regionTables.FailingTables {
+		if t.SkipReason != "" {
+			skipped = append(skipped, common.Skipped{Name: t.Name, Reason: t.SkipReason})
+		}
+	}
+	excluded := common.PrintExcluded("tables", skipped, regionTables.TablesInStacks, regionTables.ManagedInCode)
+
+	var toProtect []table
+	for _, t := range regionTables.FailingTables {
+		if t.SkipReason == "" && !slices.Contains(excluded, t.Name) {
+			toProtect = append(toProtect, t)
+		}
+	}
+
+	var names []string
+	if len(toProtect) > 0 {
+		var totalCost float64
+		fmt.Fprintln(common.Out, "\nEnabling point-in-time recovery for the following tables:")
+		for idx, t := range toProtect {
+			fmt.Fprintln(common.Out, idx+1, t.Describe())
+			totalCost += t.MonthlyCost()
+			names = append(names, t.Name)
+		}
+		fmt.Fprintf(common.Out, "Estimated total cost: about $%.2f a month, at $%.2f per GB.\n\n", totalCost, pitrPricePerGbMonth)
+	}
+
+	failingTableCount := len(regionTables.FailingTables)
+	fmt.Fprintln(common.Out, failingTableCount, "failing tables found.")
+	fmt.Fprintln(common.Out, len(toProtect), "to protect, and", failingTableCount-len(toProtect), "to skip.")
+	return names
+}
+
+// pitrParameters are what enablePitr sets, for the audit log.
+var pitrParameters = map[string]any{"PointInTimeRecoveryEnabled": true}
+
+func enablePitr(ctx context.Context, dynamodbClient *dynamodb.Client, name string) error {
+	_, err := dynamodbClient.UpdateContinuousBackups(ctx, &dynamodb.UpdateContinuousBackupsInput{
+		TableName: &name,
+		PointInTimeRecoverySpecification: &dynamodbTypes.PointInTimeRecoverySpecification{
+			PointInTimeRecoveryEnabled: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Enabled point-in-time recovery", "table", name)
+	return nil
+}
@@ -0,0 +1,71 @@
+package dynamodbutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func TestTableName(t *testing.T) {
+	cases := map[string]string{
+		"arn:aws:dynamodb:eu-west-1:123456789012:table/orders":                      "orders",
+		"arn:aws:dynamodb:eu-west-1:123456789012:table/orders/stream/2024-01-01T00": "orders",
+	}
+	for arn, expected := range cases {
+		if got := tableName(arn); got != expected {
+			t.Errorf("Error getting table name from %s. Expected %s, got %s", arn, expected, got)
+		}
+	}
+}
+
+func TestToTable(t *testing.T) {
+	description := &dynamodbTypes.TableDescription{
+		TableName:      aws.String("orders"),
+		TableArn:       aws.String("arn:aws:dynamodb:eu-west-1:123456789012:table/orders"),
+		TableSizeBytes: aws.Int64(10 * 1024 * 1024 * 1024),
+		TableStatus:    dynamodbTypes.TableStatusActive,
+		Replicas: []dynamodbTypes.ReplicaDescription{
+			{RegionName: aws.String("eu-west-1")},
+			{RegionName: aws.String("us-east-1")},
+		},
+	}
+	result := toTable(description, "eu-west-1")
+	expected := table{
+		Name:      "orders",
+		Arn:       "arn:aws:dynamodb:eu-west-1:123456789012:table/orders",
+		SizeBytes: 10 * 1024 * 1024 * 1024,
+		Replicas:  []string{"us-east-1"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Error reading table. Expected %v, got %v", expected, result)
+	}
+	if cost := result.MonthlyCost(); cost != 2.0 {
+		t.Errorf("Error estimating cost of a 10 GiB table. Expected 2.0, got %v", cost)
+	}
+
+	description.TableStatus = dynamodbTypes.TableStatusUpdating
+	if result := toTable(description, "eu-west-1"); result.SkipReason == "" {
+		t.Errorf("Expected a table that isn't active to be skipped")
+	}
+}
+
+func TestFindTablesToProtect(t *testing.T) {
+	regionTables := RegionTables{
+		Region: "eu-west-1",
+		FailingTables: []table{
+			{Name: "fixable"},
+			{Name: "creating", SkipReason: "table is CREATING"},
+			{Name: "in-stack"},
+			{Name: "in-terraform"},
+		},
+		TablesInStacks: []common.StackResources{{StackName: "app", PhysicalIds: []string{"in-stack"}}},
+		ManagedInCode:  []common.ManagedBy{{Id: "in-terraform", Reason: "tagged terraform=true"}},
+	}
+	expected := []string{"fixable"}
+	if got := FindTablesToProtect(regionTables); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error finding tables to protect. Expected %v, got %v", expected, got)
+	}
+}
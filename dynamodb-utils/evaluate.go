@@ -0,0 +1,68 @@
+package dynamodbutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// tableName turns the ARN of a failing table into its name.
+func tableName(arn string) string {
+	if i := strings.Index(arn, ":table/"); i >= 0 {
+		arn = arn[i+len(":table/"):]
+	}
+	return strings.Split(arn, "/")[0]
+}
+
+func pitrEnabled(ctx context.Context, dynamodbClient *dynamodb.Client, table string) (bool, error) {
+	resp, err := dynamodbClient.DescribeContinuousBackups(ctx, &dynamodb.DescribeContinuousBackupsInput{TableName: &table})
+	if err != nil {
+		return false, fmt.Errorf("failed to describe continuous backups for table %s: %w", table, err)
+	}
+	description := resp.ContinuousBackupsDescription
+	return description != nil && description.PointInTimeRecoveryDescription != nil &&
+		description.PointInTimeRecoveryDescription.PointInTimeRecoveryStatus == dynamodbTypes.PointInTimeRecoveryStatusEnabled, nil
+}
+
+// EvaluateDynamoDB_2 finds the tables in a region without point-in-time
+// recovery, without relying on Security Hub. Each replica of a global table
+// is evaluated in its own region.
+func EvaluateDynamoDB_2(sess *common.Session, concurrency int) common.Evaluator {
+	return func(ctx context.Context, region string) ([]string, error) {
+		dynamodbClient := sess.DynamoDB(region)
+		var tables []string
+		paginator := dynamodb.NewListTablesPaginator(dynamodbClient, &dynamodb.ListTablesInput{})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list tables: %w", err)
+			}
+			tables = append(tables, page.TableNames...)
+		}
+
+		type result struct {
+			enabled bool
+			err     error
+		}
+		results := common.ParallelMap(ctx, concurrency, tables, func(ctx context.Context, table string) result {
+			enabled, err := pitrEnabled(ctx, dynamodbClient, table)
+			return result{enabled: enabled, err: err}
+		})
+
+		var failing []string
+		var errs []error
+		for i, res := range results {
+			if res.err != nil {
+				errs = append(errs, res.err)
+			} else if !res.enabled {
+				failing = append(failing, fmt.Sprintf("arn:aws:dynamodb:%s:%s:table/%s", region, sess.AccountId, tables[i]))
+			}
+		}
+		return failing, errors.Join(errs...)
+	}
+}
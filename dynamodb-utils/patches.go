@@ -0,0 +1,30 @@
+package dynamodbutils
+
+import (
+	"fmt"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+var pitrSpecification = map[string]any{"PointInTimeRecoveryEnabled": true}
+
+// PitrPatch proposes the template change that enables point-in-time recovery
+// for a stack-managed table. For a global table, only the replica in region
+// is changed, as other replicas are fixed in their own regions.
+func PitrPatch(region string) common.PatchBuilder {
+	return func(template common.StackTemplate, logicalId string) []common.PatchOp {
+		resource, _ := template.Resource(logicalId)
+		if resource["Type"] != common.ResourceTypeGlobalTable {
+			return common.PropertyPatch("PointInTimeRecoverySpecification", pitrSpecification)(template, logicalId)
+		}
+
+		replicas, _ := template.Properties(logicalId)["Replicas"].([]any)
+		for i, r := range replicas {
+			replica, _ := r.(map[string]any)
+			if replica["Region"] == region {
+				return []common.PatchOp{common.SetOp(replica, "PointInTimeRecoverySpecification", pitrSpecification, logicalId, "Properties", "Replicas", fmt.Sprint(i))}
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,45 @@
+package dynamodbutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func TestPitrPatch(t *testing.T) {
+	template, err := common.ParseTemplate(`
+Resources:
+  BareTable:
+    Type: AWS::DynamoDB::Table
+  Table:
+    Type: AWS::DynamoDB::Table
+    Properties:
+      PointInTimeRecoverySpecification:
+        PointInTimeRecoveryEnabled: false
+  GlobalTable:
+    Type: AWS::DynamoDB::GlobalTable
+    Properties:
+      Replicas:
+        - Region: us-east-1
+        - Region: eu-west-1
+`)
+	if err != nil {
+		t.Fatalf("Error parsing template: %v", err)
+	}
+
+	cases := map[string][]common.PatchOp{
+		"BareTable":   {{Op: "add", Path: "/Resources/BareTable/Properties", Value: map[string]any{"PointInTimeRecoverySpecification": pitrSpecification}}},
+		"Table":       {{Op: "replace", Path: "/Resources/Table/Properties/PointInTimeRecoverySpecification", Value: pitrSpecification}},
+		"GlobalTable": {{Op: "add", Path: "/Resources/GlobalTable/Properties/Replicas/1/PointInTimeRecoverySpecification", Value: pitrSpecification}},
+	}
+	for logicalId, expected := range cases {
+		if got := PitrPatch("eu-west-1")(template, logicalId); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Error patching %s. Expected %v, got %v", logicalId, expected, got)
+		}
+	}
+
+	if got := PitrPatch("ap-southeast-2")(template, "GlobalTable"); got != nil {
+		t.Errorf("Expected no patch for a global table without a replica in the region, got %v", got)
+	}
+}
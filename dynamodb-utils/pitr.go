@@ -0,0 +1,35 @@
+package dynamodbutils
+
+import (
+	"context"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// FixDynamoDB_2 enables point-in-time recovery for failing tables in every
+// region.
+func FixDynamoDB_2(ctx context.Context, sess *common.Session, opts common.Options) (common.Result, error) {
+	return common.Remediate(ctx, sess, opts, common.Remediation[RegionTables, string]{
+		ControlId: "DynamoDB.2",
+		Noun:      "tables",
+		Action:    "enable point-in-time recovery",
+		Evaluate:  EvaluateDynamoDB_2(sess, opts.Concurrency),
+		Find: func(ctx context.Context, f common.FailingResources) (RegionTables, error) {
+			regionTables := FindRegionTables(ctx, sess.DynamoDB(f.Region), sess.CloudFormation(f.Region), opts, f, sess.AccountId)
+			return regionTables, regionTables.Err
+		},
+		Plan: func(regionTables RegionTables) ([]string, []common.StackPatch) {
+			return FindTablesToProtect(regionTables), regionTables.StackPatches
+		},
+		Apply: func(ctx context.Context, change common.Change[string]) error {
+			return enablePitr(ctx, sess.DynamoDB(change.Region), change.Item)
+		},
+		Audit: func(table string) (string, string, map[string]any) {
+			return "dynamodb:UpdateContinuousBackups", table, pitrParameters
+		},
+		Verify: func(ctx context.Context, change common.Change[string]) (bool, error) {
+			return pitrEnabled(ctx, sess.DynamoDB(change.Region), change.Item)
+		},
+		Id: func(table string) string { return table },
+	})
+}
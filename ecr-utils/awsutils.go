@@ -0,0 +1,334 @@
+package ecrutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// movingTags are tags that pushes usually move to the new image, which
+// immutable tags would reject.
+var movingTags = []string{"latest"}
+
+type image struct {
+	Tags     []string
+	PushedAt time.Time
+}
+
+// repository is a failing repository, with its images so the impact of a
+// change can be seen before it is made.
+type repository struct {
+	Name               string
+	Arn                string
+	ScanOnPush         bool
+	TagMutability      ecrTypes.ImageTagMutability
+	HasLifecyclePolicy bool
+	Images             []image
+	Tags               map[string]string
+	SkipReason         string
+}
+
+// ImageCounts returns the number of tagged and untagged images.
+func (r repository) ImageCounts() (tagged int, untagged int) {
+	for _, img := range r.Images {
+		if len(img.Tags) > 0 {
+			tagged++
+		} else {
+			untagged++
+		}
+	}
+	return tagged, untagged
+}
+
+// Describe is one line identifying a repository and its images, e.g.
+// frontend: 120 images (100 tagged, 20 untagged).
+func (r repository) Describe() string {
+	tagged, untagged := r.ImageCounts()
+	return fmt.Sprintf("%s: %d images (%d tagged, %d untagged)", r.Name, len(r.Images), tagged, untagged)
+}
+
+func toRepository(repo ecrTypes.Repository) repository {
+	return repository{
+		Name:          aws.ToString(repo.RepositoryName),
+		Arn:           aws.ToString(repo.RepositoryArn),
+		ScanOnPush:    scanOnPush(repo),
+		TagMutability: repo.ImageTagMutability,
+	}
+}
+
+func getRepositoryTags(ctx context.Context, ecrClient *ecr.Client, arn string) (map[string]string, error) {
+	resp, err := ecrClient.ListTagsForResource(ctx, &ecr.ListTagsForResourceInput{ResourceArn: &arn})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags for repository %s: %w", repositoryName(arn), err)
+	}
+	tags := map[string]string{}
+	for _, tag := range resp.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
+func getImages(ctx context.Context, ecrClient *ecr.Client, name string) ([]image, error) {
+	var images []image
+	paginator := ecr.NewDescribeImagesPaginator(ecrClient, &ecr.DescribeImagesInput{RepositoryName: &name})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list images in repository %s: %w", name, err)
+		}
+		for _, detail := range page.ImageDetails {
+			images = append(images, image{Tags: detail.ImageTags, PushedAt: aws.ToTime(detail.ImagePushedAt)})
+		}
+	}
+	return images, nil
+}
+
+func getRepository(ctx context.Context, ecrClient *ecr.Client, name string) (repository, error) {
+	description, err := describeRepository(ctx, ecrClient, name)
+	var notFound *ecrTypes.RepositoryNotFoundException
+	if errors.As(err, &notFound) {
+		return repository{Name: name, SkipReason: "no longer exists"}, nil
+	}
+	if err != nil {
+		return repository{}, fmt.Errorf("failed to describe repository %s: %w", name, err)
+	}
+
+	r := toRepository(description)
+	if r.HasLifecyclePolicy, err = hasLifecyclePolicy(ctx, ecrClient, name); err != nil {
+		return repository{}, err
+	}
+	if r.Images, err = getImages(ctx, ecrClient, name); err != nil {
+		return repository{}, err
+	}
+	if r.Tags, err = getRepositoryTags(ctx, ecrClient, r.Arn); err != nil {
+		return repository{}, err
+	}
+	return r, nil
+}
+
+// RegionRepositories is everything we need to know about a region to decide
+// which repositories to fix. It is gathered concurrently and printed
+// afterwards.
+type RegionRepositories struct {
+	Region               string
+	FailingRepositories  []repository
+	RepositoriesInStacks []common.StackResources
+	ManagedInCode        []common.ManagedBy // Managed by IaC other than CloudFormation
+	StackPatches         []common.StackPatch
+	Err                  error
+}
+
+// FindRegionRepositories gathers the failing repositories in a region, and
+// proposes patches for those in stacks with build.
+func FindRegionRepositories(ctx context.Context, ecrClient *ecr.Client, cfnClient *cloudformation.Client, opts common.Options, failing common.FailingResources, accountId string, build common.PatchBuilder) RegionRepositories {
+	region := failing.Region
+	var names []string
+	for _, arn := range failing.Arns {
+		names = append(names, repositoryName(arn))
+	}
+
+	type repositoryResult struct {
+		repository repository
+		err        error
+	}
+	results := common.ParallelMap(ctx, opts.Concurrency, names, func(ctx context.Context, name string) repositoryResult {
+		r, err := getRepository(ctx, ecrClient, name)
+		return repositoryResult{repository: r, err: err}
+	})
+	var failingRepositories []repository
+	var existing []string
+	var errs []error
+	for _, res := range results {
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		failingRepositories = append(failingRepositories, res.repository)
+		if res.repository.SkipReason == "" {
+			existing = append(existing, res.repository.Name)
+		}
+	}
+	if len(errs) > 0 {
+		return RegionRepositories{Region: region, Err: fmt.Errorf("could not describe failing repositories: %w", errors.Join(errs...))}
+	}
+
+	repositoriesInStacks, err := opts.StackLookup.FindResourcesInStacks(ctx, cfnClient, accountId, region, common.ResourceTypeEcrRepository, existing, opts.Concurrency)
+	if err != nil {
+		return RegionRepositories{Region: region, Err: fmt.Errorf("could not determine which repositories are in CloudFormation stacks: %w", err)}
+	}
+
+	notInStacks := common.Without(existing, common.ResourcesInStacks(repositoriesInStacks))
+	var resources []common.ManagedResource
+	for _, r := range failingRepositories {
+		if slices.Contains(notInStacks, r.Name) {
+			resources = append(resources, common.ManagedResource{Id: r.Name, Type: common.ResourceTypeEcrRepository, Tags: r.Tags})
+		}
+	}
+
+	return RegionRepositories{
+		Region:               region,
+		FailingRepositories:  failingRepositories,
+		RepositoriesInStacks: repositoriesInStacks,
+		ManagedInCode:        opts.Detectors.Detect(resources),
+		StackPatches:         common.BuildStackPatches(ctx, cfnClient, region, repositoriesInStacks, build, opts.Concurrency),
+	}
+}
+
+// findRegionRepositories looks up repositories the same way for ECR.1, ECR.2
+// and ECR.3, which only differ in the stack patch they propose.
+func findRegionRepositories(sess *common.Session, opts common.Options, build common.PatchBuilder) func(context.Context, common.FailingResources) (RegionRepositories, error) {
+	return func(ctx context.Context, f common.FailingResources) (RegionRepositories, error) {
+		regionRepositories := FindRegionRepositories(ctx, sess.ECR(f.Region), sess.CloudFormation(f.Region), opts, f, sess.AccountId, build)
+		return regionRepositories, regionRepositories.Err
+	}
+}
+
+// findRepositories prints what we found in a region, and returns the
+// repositories to fix, which are those without a skipReason that aren't
+// managed in code.
+func findRepositories(regionRepositories RegionRepositories, skipReason func(repository) string) []repository {
+	var skipped []common.Skipped
+	var candidates []repository
+	for _, r := range regionRepositories.FailingRepositories {
+		reason := r.SkipReason
+		if reason == "" {
+			reason = skipReason(r)
+		}
+		if reason != "" {
+			skipped = append(skipped, common.Skipped{Name: r.Name, Reason: reason})
+		} else {
+			candidates = append(candidates, r)
+		}
+	}
+	excluded := common.PrintExcluded("repositories", skipped, regionRepositories.RepositoriesInStacks, regionRepositories.ManagedInCode)
+
+	var toFix []repository
+	for _, r := range candidates {
+		if !slices.Contains(excluded, r.Name) {
+			toFix = append(toFix, r)
+		}
+	}
+	return toFix
+}
+
+// printRepositories prints the plan for a region, and returns the names of
+// the repositories in it.
+func printRepositories(regionRepositories RegionRepositories, toFix []repository, heading string, describe func(repository) string, verb string) []string {
+	var names []string
+	if len(toFix) > 0 {
+		fmt.Fprintf(common.Out, "\n%s:\n", heading)
+		for idx, r := range toFix {
+			fmt.Fprintln(common.Out, idx+1, describe(r))
+			names = append(names, r.Name)
+		}
+		fmt.Fprint(common.Out, "\n")
+	}
+
+	failingRepositoryCount := len(regionRepositories.FailingRepositories)
+	fmt.Fprintln(common.Out, failingRepositoryCount, "failing repositories found.")
+	fmt.Fprintln(common.Out, len(toFix), verb+", and", failingRepositoryCount-len(toFix), "to skip.")
+	return names
+}
+
+// FindRepositoriesToScan prints what we found in a region, and returns the
+// repositories to turn on scan on push for.
+func FindRepositoriesToScan(regionRepositories RegionRepositories) []string {
+	toFix := findRepositories(regionRepositories, func(r repository) string {
+		if r.ScanOnPush {
+			return "already scans on push"
+		}
+		return ""
+	})
+	return printRepositories(regionRepositories, toFix, "Turning on scan on push for the following repositories", repository.Describe, "to scan")
+}
+
+// immutabilitySkipReason explains why a repository's tags shouldn't be made
+// immutable, or is empty.
+func immutabilitySkipReason(r repository) string {
+	if tagsImmutable(r.TagMutability) {
+		return "tags are already immutable"
+	}
+	for _, img := range r.Images {
+		for _, tag := range img.Tags {
+			if slices.Contains(movingTags, tag) {
+				return fmt.Sprintf("an image is tagged %s, which is usually moved by each push. Stop using it, then make tags immutable", tag)
+			}
+		}
+	}
+	return ""
+}
+
+// FindRepositoriesToMakeImmutable prints what we found in a region, and
+// returns the repositories whose tags to make immutable.
+func FindRepositoriesToMakeImmutable(regionRepositories RegionRepositories) []string {
+	toFix := findRepositories(regionRepositories, immutabilitySkipReason)
+	return printRepositories(regionRepositories, toFix, "Making tags immutable for the following repositories", repository.Describe, "to make immutable")
+}
+
+// FindRepositoriesToExpire prints what we found in a region, with how many
+// images lifecycle would expire from each repository if it were evaluated at
+// now, and returns the repositories to give it to.
+func FindRepositoriesToExpire(regionRepositories RegionRepositories, lifecycle LifecyclePolicy, now time.Time) []string {
+	toFix := findRepositories(regionRepositories, func(r repository) string {
+		if r.HasLifecyclePolicy {
+			return "already has a lifecycle policy"
+		}
+		return ""
+	})
+
+	var expiring int
+	names := printRepositories(regionRepositories, toFix, fmt.Sprintf("Setting a lifecycle policy to %s, for the following repositories", lifecycle), func(r repository) string {
+		tagged, untagged := lifecycle.Expiring(r.Images, now)
+		expiring += tagged + untagged
+		return fmt.Sprintf("%s, expiring %d tagged and %d untagged", r.Describe(), tagged, untagged)
+	}, "to set a lifecycle policy for")
+	if len(names) > 0 {
+		fmt.Fprintln(common.Out, expiring, "images to expire in total, usually within a day of the policies being set.")
+	}
+	return names
+}
+
+func enableScanOnPush(ctx context.Context, ecrClient *ecr.Client, name string) error {
+	_, err := ecrClient.PutImageScanningConfiguration(ctx, &ecr.PutImageScanningConfigurationInput{
+		RepositoryName:             &name,
+		ImageScanningConfiguration: &ecrTypes.ImageScanningConfiguration{ScanOnPush: true},
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Turned on scan on push", "repository", name)
+	return nil
+}
+
+func makeTagsImmutable(ctx context.Context, ecrClient *ecr.Client, name string) error {
+	_, err := ecrClient.PutImageTagMutability(ctx, &ecr.PutImageTagMutabilityInput{
+		RepositoryName:     &name,
+		ImageTagMutability: ecrTypes.ImageTagMutabilityImmutable,
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Made tags immutable", "repository", name)
+	return nil
+}
+
+func putLifecyclePolicy(ctx context.Context, ecrClient *ecr.Client, name string, lifecycle LifecyclePolicy) error {
+	_, err := ecrClient.PutLifecyclePolicy(ctx, &ecr.PutLifecyclePolicyInput{
+		RepositoryName:      &name,
+		LifecyclePolicyText: aws.String(lifecycle.Text()),
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Set lifecycle policy", "repository", name, "policy", lifecycle.String())
+	return nil
+}
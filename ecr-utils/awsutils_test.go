@@ -0,0 +1,90 @@
+package ecrutils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+var now = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func daysAgo(days int) time.Time {
+	return now.Add(-time.Duration(days) * 24 * time.Hour)
+}
+
+func TestRepositoryName(t *testing.T) {
+	cases := map[string]string{
+		"arn:aws:ecr:eu-west-1:123456789012:repository/frontend":      "frontend",
+		"arn:aws:ecr:eu-west-1:123456789012:repository/team/frontend": "team/frontend",
+		"frontend": "frontend",
+	}
+	for arn, expected := range cases {
+		if got := repositoryName(arn); got != expected {
+			t.Errorf("Error getting repository name from %s. Expected %s, got %s", arn, expected, got)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	r := repository{Name: "frontend", Images: []image{
+		{Tags: []string{"v1"}},
+		{Tags: []string{"v2", "main"}},
+		{},
+	}}
+	expected := "frontend: 3 images (2 tagged, 1 untagged)"
+	if got := r.Describe(); got != expected {
+		t.Errorf("Error describing repository. Expected %s, got %s", expected, got)
+	}
+}
+
+func regionRepositories(failing ...repository) RegionRepositories {
+	return RegionRepositories{
+		Region: "eu-west-1",
+		FailingRepositories: append([]repository{
+			{Name: "deleted", SkipReason: "no longer exists"},
+			{Name: "in-stack"},
+			{Name: "in-terraform"},
+		}, failing...),
+		RepositoriesInStacks: []common.StackResources{{StackName: "app", PhysicalIds: []string{"in-stack"}}},
+		ManagedInCode:        []common.ManagedBy{{Id: "in-terraform", Reason: "in Terraform state"}},
+	}
+}
+
+func TestFindRepositoriesToScan(t *testing.T) {
+	regionRepositories := regionRepositories(
+		repository{Name: "fixable"},
+		repository{Name: "scanning", ScanOnPush: true},
+	)
+	expected := []string{"fixable"}
+	if got := FindRepositoriesToScan(regionRepositories); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error finding repositories to scan. Expected %v, got %v", expected, got)
+	}
+}
+
+func TestFindRepositoriesToMakeImmutable(t *testing.T) {
+	regionRepositories := regionRepositories(
+		repository{Name: "fixable", TagMutability: ecrTypes.ImageTagMutabilityMutable, Images: []image{{Tags: []string{"v1"}}}},
+		repository{Name: "latest", TagMutability: ecrTypes.ImageTagMutabilityMutable, Images: []image{{Tags: []string{"v1", "latest"}}}},
+		repository{Name: "immutable", TagMutability: ecrTypes.ImageTagMutabilityImmutable},
+		repository{Name: "excluded", TagMutability: ecrTypes.ImageTagMutabilityImmutableWithExclusion},
+	)
+	expected := []string{"fixable"}
+	if got := FindRepositoriesToMakeImmutable(regionRepositories); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error finding repositories to make immutable. Expected %v, got %v", expected, got)
+	}
+}
+
+func TestFindRepositoriesToExpire(t *testing.T) {
+	regionRepositories := regionRepositories(
+		repository{Name: "fixable", Images: []image{{Tags: []string{"v1"}, PushedAt: daysAgo(100)}, {PushedAt: daysAgo(30)}}},
+		repository{Name: "empty"},
+		repository{Name: "has-policy", HasLifecyclePolicy: true},
+	)
+	expected := []string{"fixable", "empty"}
+	if got := FindRepositoriesToExpire(regionRepositories, DefaultLifecyclePolicy, now); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error finding repositories to set a lifecycle policy for. Expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,84 @@
+package ecrutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// LifecyclePolicy is the lifecycle policy given to repositories without one.
+// It keeps the most recently pushed tagged images, and expires untagged images
+// once they are old enough.
+type LifecyclePolicy struct {
+	KeepTagged         int // The number of tagged images to keep
+	ExpireUntaggedDays int // Days after they are pushed to expire untagged images
+}
+
+var DefaultLifecyclePolicy = LifecyclePolicy{KeepTagged: 100, ExpireUntaggedDays: 14}
+
+func (p LifecyclePolicy) Validate() error {
+	if p.KeepTagged < 1 {
+		return fmt.Errorf("the number of tagged images to keep must be at least 1, got %d", p.KeepTagged)
+	}
+	if p.ExpireUntaggedDays < 1 {
+		return fmt.Errorf("the days to keep untagged images for must be at least 1, got %d", p.ExpireUntaggedDays)
+	}
+	return nil
+}
+
+type lifecycleSelection struct {
+	TagStatus      string   `json:"tagStatus"`
+	TagPatternList []string `json:"tagPatternList,omitempty"`
+	CountType      string   `json:"countType"`
+	CountUnit      string   `json:"countUnit,omitempty"`
+	CountNumber    int      `json:"countNumber"`
+}
+
+type lifecycleAction struct {
+	Type string `json:"type"`
+}
+
+type lifecycleRule struct {
+	RulePriority int                `json:"rulePriority"`
+	Description  string             `json:"description"`
+	Selection    lifecycleSelection `json:"selection"`
+	Action       lifecycleAction    `json:"action"`
+}
+
+// Text is the policy in the JSON ECR expects.
+func (p LifecyclePolicy) Text() string {
+	rules := []lifecycleRule{
+		{
+			RulePriority: 1,
+			Description:  fmt.Sprintf("Expire untagged images %d days after they are pushed", p.ExpireUntaggedDays),
+			Selection:    lifecycleSelection{TagStatus: "untagged", CountType: "sinceImagePushed", CountUnit: "days", CountNumber: p.ExpireUntaggedDays},
+			Action:       lifecycleAction{Type: "expire"},
+		},
+		{
+			RulePriority: 2,
+			Description:  fmt.Sprintf("Keep the last %d tagged images", p.KeepTagged),
+			Selection:    lifecycleSelection{TagStatus: "tagged", TagPatternList: []string{"*"}, CountType: "imageCountMoreThan", CountNumber: p.KeepTagged},
+			Action:       lifecycleAction{Type: "expire"},
+		},
+	}
+	text, _ := json.Marshal(map[string]any{"rules": rules})
+	return string(text)
+}
+
+func (p LifecyclePolicy) String() string {
+	return fmt.Sprintf("keep the last %d tagged images, and expire untagged images after %d days", p.KeepTagged, p.ExpireUntaggedDays)
+}
+
+// Expiring estimates how many of a repository's images the policy would
+// expire, if it were evaluated at now.
+func (p LifecyclePolicy) Expiring(images []image, now time.Time) (tagged int, untagged int) {
+	for _, img := range images {
+		if len(img.Tags) > 0 {
+			tagged++
+		} else if now.Sub(img.PushedAt) > time.Duration(p.ExpireUntaggedDays)*24*time.Hour {
+			untagged++
+		}
+	}
+	// Only the most recently pushed tagged images are kept
+	return max(tagged-p.KeepTagged, 0), untagged
+}
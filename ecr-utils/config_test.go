@@ -0,0 +1,69 @@
+package ecrutils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLifecyclePolicyValidate(t *testing.T) {
+	if err := DefaultLifecyclePolicy.Validate(); err != nil {
+		t.Errorf("Expected the default lifecycle policy to be valid, got %v", err)
+	}
+	invalid := []LifecyclePolicy{
+		{KeepTagged: 0, ExpireUntaggedDays: 14},
+		{KeepTagged: 10, ExpireUntaggedDays: 0},
+		{KeepTagged: -1, ExpireUntaggedDays: -1},
+	}
+	for _, lifecycle := range invalid {
+		if err := lifecycle.Validate(); err == nil {
+			t.Errorf("Expected lifecycle policy %+v to be invalid", lifecycle)
+		}
+	}
+}
+
+func TestLifecyclePolicyText(t *testing.T) {
+	var got map[string]any
+	if err := json.Unmarshal([]byte(LifecyclePolicy{KeepTagged: 30, ExpireUntaggedDays: 7}.Text()), &got); err != nil {
+		t.Fatalf("Error parsing lifecycle policy text: %v", err)
+	}
+
+	var expected map[string]any
+	json.Unmarshal([]byte(`{"rules": [
+  {"rulePriority": 1, "description": "Expire untagged images 7 days after they are pushed",
+   "selection": {"tagStatus": "untagged", "countType": "sinceImagePushed", "countUnit": "days", "countNumber": 7},
+   "action": {"type": "expire"}},
+  {"rulePriority": 2, "description": "Keep the last 30 tagged images",
+   "selection": {"tagStatus": "tagged", "tagPatternList": ["*"], "countType": "imageCountMoreThan", "countNumber": 30},
+   "action": {"type": "expire"}}
+]}`), &expected)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error writing lifecycle policy. Expected %v, got %v", expected, got)
+	}
+}
+
+func TestLifecyclePolicyExpiring(t *testing.T) {
+	images := []image{
+		{Tags: []string{"v1"}, PushedAt: daysAgo(300)},
+		{Tags: []string{"v2"}, PushedAt: daysAgo(200)},
+		{Tags: []string{"v3", "main"}, PushedAt: daysAgo(1)},
+		{PushedAt: daysAgo(30)},
+		{PushedAt: daysAgo(8)},
+		{PushedAt: daysAgo(2)},
+	}
+	cases := []struct {
+		lifecycle LifecyclePolicy
+		tagged    int
+		untagged  int
+	}{
+		{LifecyclePolicy{KeepTagged: 100, ExpireUntaggedDays: 14}, 0, 1},
+		{LifecyclePolicy{KeepTagged: 2, ExpireUntaggedDays: 7}, 1, 2},
+		{LifecyclePolicy{KeepTagged: 1, ExpireUntaggedDays: 1}, 2, 3},
+	}
+	for _, c := range cases {
+		tagged, untagged := c.lifecycle.Expiring(images, now)
+		if tagged != c.tagged || untagged != c.untagged {
+			t.Errorf("Error estimating images expired by %+v. Expected %d tagged and %d untagged, got %d and %d", c.lifecycle, c.tagged, c.untagged, tagged, untagged)
+		}
+	}
+}
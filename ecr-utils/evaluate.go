@@ -0,0 +1,134 @@
+package ecrutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// repositoryName turns the ARN of a failing repository into its name, which
+// may contain slashes.
+func repositoryName(arn string) string {
+	if i := strings.Index(arn, ":repository/"); i >= 0 {
+		return arn[i+len(":repository/"):]
+	}
+	return arn
+}
+
+// tagsImmutable reports whether pushes can't move a repository's tags. Tags
+// excluded from immutability are allowed, as they are chosen deliberately.
+func tagsImmutable(mutability ecrTypes.ImageTagMutability) bool {
+	return mutability == ecrTypes.ImageTagMutabilityImmutable || mutability == ecrTypes.ImageTagMutabilityImmutableWithExclusion
+}
+
+func scanOnPush(repo ecrTypes.Repository) bool {
+	return repo.ImageScanningConfiguration != nil && repo.ImageScanningConfiguration.ScanOnPush
+}
+
+func listRepositories(ctx context.Context, ecrClient *ecr.Client) ([]ecrTypes.Repository, error) {
+	var repos []ecrTypes.Repository
+	paginator := ecr.NewDescribeRepositoriesPaginator(ecrClient, &ecr.DescribeRepositoriesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list repositories: %w", err)
+		}
+		repos = append(repos, page.Repositories...)
+	}
+	return repos, nil
+}
+
+func describeRepository(ctx context.Context, ecrClient *ecr.Client, name string) (ecrTypes.Repository, error) {
+	resp, err := ecrClient.DescribeRepositories(ctx, &ecr.DescribeRepositoriesInput{RepositoryNames: []string{name}})
+	if err != nil {
+		return ecrTypes.Repository{}, err
+	}
+	if len(resp.Repositories) == 0 {
+		return ecrTypes.Repository{}, &ecrTypes.RepositoryNotFoundException{Message: aws.String(name)}
+	}
+	return resp.Repositories[0], nil
+}
+
+func hasLifecyclePolicy(ctx context.Context, ecrClient *ecr.Client, name string) (bool, error) {
+	resp, err := ecrClient.GetLifecyclePolicy(ctx, &ecr.GetLifecyclePolicyInput{RepositoryName: &name})
+	var notFound *ecrTypes.LifecyclePolicyNotFoundException
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get lifecycle policy of repository %s: %w", name, err)
+	}
+	return aws.ToString(resp.LifecyclePolicyText) != "", nil
+}
+
+// evaluateRepositories finds the repositories in a region that fail a check,
+// without relying on Security Hub.
+func evaluateRepositories(sess *common.Session, concurrency int, failed func(ctx context.Context, ecrClient *ecr.Client, repo ecrTypes.Repository) (bool, error)) common.Evaluator {
+	return func(ctx context.Context, region string) ([]string, error) {
+		ecrClient := sess.ECR(region)
+		repos, err := listRepositories(ctx, ecrClient)
+		if err != nil {
+			return nil, err
+		}
+
+		type result struct {
+			failed bool
+			err    error
+		}
+		results := common.ParallelMap(ctx, concurrency, repos, func(ctx context.Context, repo ecrTypes.Repository) result {
+			failed, err := failed(ctx, ecrClient, repo)
+			return result{failed: failed, err: err}
+		})
+
+		var failing []string
+		var errs []error
+		for i, res := range results {
+			if res.err != nil {
+				errs = append(errs, res.err)
+			} else if res.failed {
+				failing = append(failing, aws.ToString(repos[i].RepositoryArn))
+			}
+		}
+		return failing, errors.Join(errs...)
+	}
+}
+
+// EvaluateECR_1 finds the repositories in a region that don't scan images
+// when they are pushed.
+func EvaluateECR_1(sess *common.Session, concurrency int) common.Evaluator {
+	return evaluateRepositories(sess, concurrency, func(ctx context.Context, ecrClient *ecr.Client, repo ecrTypes.Repository) (bool, error) {
+		return !scanOnPush(repo), nil
+	})
+}
+
+// EvaluateECR_2 finds the repositories in a region whose tags can be moved.
+func EvaluateECR_2(sess *common.Session, concurrency int) common.Evaluator {
+	return evaluateRepositories(sess, concurrency, func(ctx context.Context, ecrClient *ecr.Client, repo ecrTypes.Repository) (bool, error) {
+		return !tagsImmutable(repo.ImageTagMutability), nil
+	})
+}
+
+// EvaluateECR_3 finds the repositories in a region without a lifecycle
+// policy.
+func EvaluateECR_3(sess *common.Session, concurrency int) common.Evaluator {
+	return evaluateRepositories(sess, concurrency, func(ctx context.Context, ecrClient *ecr.Client, repo ecrTypes.Repository) (bool, error) {
+		has, err := hasLifecyclePolicy(ctx, ecrClient, aws.ToString(repo.RepositoryName))
+		return err == nil && !has, err
+	})
+}
+
+func repositoryScanning(ctx context.Context, ecrClient *ecr.Client, name string) (bool, error) {
+	repo, err := describeRepository(ctx, ecrClient, name)
+	return scanOnPush(repo), err
+}
+
+func repositoryImmutable(ctx context.Context, ecrClient *ecr.Client, name string) (bool, error) {
+	repo, err := describeRepository(ctx, ecrClient, name)
+	return tagsImmutable(repo.ImageTagMutability), err
+}
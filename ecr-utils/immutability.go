@@ -0,0 +1,33 @@
+package ecrutils
+
+import (
+	"context"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// FixECR_2 makes the tags of failing repositories immutable in every region.
+// Repositories with a tag that pushes usually move, like latest, are skipped,
+// as those pushes would start failing.
+func FixECR_2(ctx context.Context, sess *common.Session, opts common.Options) (common.Result, error) {
+	return common.Remediate(ctx, sess, opts, common.Remediation[RegionRepositories, string]{
+		ControlId: "ECR.2",
+		Noun:      "repositories",
+		Action:    "make tags immutable",
+		Evaluate:  EvaluateECR_2(sess, opts.Concurrency),
+		Find:      findRegionRepositories(sess, opts, TagImmutabilityPatch),
+		Plan: func(regionRepositories RegionRepositories) ([]string, []common.StackPatch) {
+			return FindRepositoriesToMakeImmutable(regionRepositories), regionRepositories.StackPatches
+		},
+		Apply: func(ctx context.Context, change common.Change[string]) error {
+			return makeTagsImmutable(ctx, sess.ECR(change.Region), change.Item)
+		},
+		Audit: func(name string) (string, string, map[string]any) {
+			return "ecr:PutImageTagMutability", name, map[string]any{"ImageTagMutability": "IMMUTABLE"}
+		},
+		Verify: func(ctx context.Context, change common.Change[string]) (bool, error) {
+			return repositoryImmutable(ctx, sess.ECR(change.Region), change.Item)
+		},
+		Id: func(name string) string { return name },
+	})
+}
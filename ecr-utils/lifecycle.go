@@ -0,0 +1,35 @@
+package ecrutils
+
+import (
+	"context"
+	"time"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// FixECR_3 sets lifecycle as the lifecycle policy of failing repositories in
+// every region. The plan shows how many images each repository would lose,
+// as ECR expires them without asking.
+func FixECR_3(ctx context.Context, sess *common.Session, opts common.Options, lifecycle LifecyclePolicy) (common.Result, error) {
+	now := time.Now()
+	return common.Remediate(ctx, sess, opts, common.Remediation[RegionRepositories, string]{
+		ControlId: "ECR.3",
+		Noun:      "repositories",
+		Action:    "set lifecycle policies",
+		Evaluate:  EvaluateECR_3(sess, opts.Concurrency),
+		Find:      findRegionRepositories(sess, opts, LifecyclePolicyPatch(lifecycle)),
+		Plan: func(regionRepositories RegionRepositories) ([]string, []common.StackPatch) {
+			return FindRepositoriesToExpire(regionRepositories, lifecycle, now), regionRepositories.StackPatches
+		},
+		Apply: func(ctx context.Context, change common.Change[string]) error {
+			return putLifecyclePolicy(ctx, sess.ECR(change.Region), change.Item, lifecycle)
+		},
+		Audit: func(name string) (string, string, map[string]any) {
+			return "ecr:PutLifecyclePolicy", name, map[string]any{"LifecyclePolicyText": lifecycle.Text()}
+		},
+		Verify: func(ctx context.Context, change common.Change[string]) (bool, error) {
+			return hasLifecyclePolicy(ctx, sess.ECR(change.Region), change.Item)
+		},
+		Id: func(name string) string { return name },
+	})
+}
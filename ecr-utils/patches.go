@@ -0,0 +1,19 @@
+package ecrutils
+
+import (
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// ScanOnPushPatch proposes the template change that scans a stack-managed
+// repository's images when they are pushed.
+var ScanOnPushPatch = common.PropertyPatch("ImageScanningConfiguration", map[string]any{"ScanOnPush": true})
+
+// TagImmutabilityPatch proposes the template change that makes a
+// stack-managed repository's tags immutable.
+var TagImmutabilityPatch = common.PropertyPatch("ImageTagMutability", "IMMUTABLE")
+
+// LifecyclePolicyPatch proposes the template change that sets lifecycle as the
+// lifecycle policy of a stack-managed repository.
+func LifecyclePolicyPatch(lifecycle LifecyclePolicy) common.PatchBuilder {
+	return common.PropertyPatch("LifecyclePolicy", map[string]any{"LifecyclePolicyText": lifecycle.Text()})
+}
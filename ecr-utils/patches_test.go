@@ -0,0 +1,46 @@
+package ecrutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func TestPatches(t *testing.T) {
+	template, err := common.ParseTemplate(`
+Resources:
+  BareRepository:
+    Type: AWS::ECR::Repository
+  Repository:
+    Type: AWS::ECR::Repository
+    Properties:
+      RepositoryName: frontend
+      ImageTagMutability: MUTABLE
+      ImageScanningConfiguration:
+        ScanOnPush: false
+`)
+	if err != nil {
+		t.Fatalf("Error parsing template: %v", err)
+	}
+
+	scanning := map[string]any{"ScanOnPush": true}
+	lifecycle := map[string]any{"LifecyclePolicyText": DefaultLifecyclePolicy.Text()}
+	cases := []struct {
+		name      string
+		build     common.PatchBuilder
+		logicalId string
+		expected  []common.PatchOp
+	}{
+		{"scan on push", ScanOnPushPatch, "BareRepository", []common.PatchOp{{Op: "add", Path: "/Resources/BareRepository/Properties", Value: map[string]any{"ImageScanningConfiguration": scanning}}}},
+		{"scan on push", ScanOnPushPatch, "Repository", []common.PatchOp{{Op: "replace", Path: "/Resources/Repository/Properties/ImageScanningConfiguration", Value: scanning}}},
+		{"tag immutability", TagImmutabilityPatch, "BareRepository", []common.PatchOp{{Op: "add", Path: "/Resources/BareRepository/Properties", Value: map[string]any{"ImageTagMutability": "IMMUTABLE"}}}},
+		{"tag immutability", TagImmutabilityPatch, "Repository", []common.PatchOp{{Op: "replace", Path: "/Resources/Repository/Properties/ImageTagMutability", Value: "IMMUTABLE"}}},
+		{"lifecycle policy", LifecyclePolicyPatch(DefaultLifecyclePolicy), "Repository", []common.PatchOp{{Op: "add", Path: "/Resources/Repository/Properties/LifecyclePolicy", Value: lifecycle}}},
+	}
+	for _, c := range cases {
+		if got := c.build(template, c.logicalId); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("Error patching %s for %s. Expected %v, got %v", c.logicalId, c.name, c.expected, got)
+		}
+	}
+}
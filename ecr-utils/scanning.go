@@ -0,0 +1,31 @@
+package ecrutils
+
+import (
+	"context"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// FixECR_1 turns on scan on push for failing repositories in every region.
+func FixECR_1(ctx context.Context, sess *common.Session, opts common.Options) (common.Result, error) {
+	return common.Remediate(ctx, sess, opts, common.Remediation[RegionRepositories, string]{
+		ControlId: "ECR.1",
+		Noun:      "repositories",
+		Action:    "turn on scan on push",
+		Evaluate:  EvaluateECR_1(sess, opts.Concurrency),
+		Find:      findRegionRepositories(sess, opts, ScanOnPushPatch),
+		Plan: func(regionRepositories RegionRepositories) ([]string, []common.StackPatch) {
+			return FindRepositoriesToScan(regionRepositories), regionRepositories.StackPatches
+		},
+		Apply: func(ctx context.Context, change common.Change[string]) error {
+			return enableScanOnPush(ctx, sess.ECR(change.Region), change.Item)
+		},
+		Audit: func(name string) (string, string, map[string]any) {
+			return "ecr:PutImageScanningConfiguration", name, map[string]any{"ScanOnPush": true}
+		},
+		Verify: func(ctx context.Context, change common.Change[string]) (bool, error) {
+			return repositoryScanning(ctx, sess.ECR(change.Region), change.Item)
+		},
+		Id: func(name string) string { return name },
+	})
+}
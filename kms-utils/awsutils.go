@@ -0,0 +1,230 @@
+package kmsutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// ownerTagKeys are the tags that tell us who owns a key, in the order we show
+// them. Stack, Stage and App are the Guardian's own conventions.
+var ownerTagKeys = []string{"Stack", "Stage", "App", "Owner", "Team"}
+
+// keyId turns the ARN of a failing key into its key ID, which is also its
+// CloudFormation physical ID.
+func keyId(arn string) string {
+	if i := strings.LastIndex(arn, ":key/"); i >= 0 {
+		return arn[i+len(":key/"):]
+	}
+	return arn
+}
+
+// keyDetails is what we show about a key, so owners can recognise it.
+type keyDetails struct {
+	Id         string
+	Aliases    []string
+	Tags       map[string]string
+	SkipReason string // Why rotation can't be turned on, if it can't
+}
+
+// OwnerTags formats the tags identifying a key's owner, e.g. Stack=deploy, App=riff-raff.
+func (k keyDetails) OwnerTags() string {
+	var tags []string
+	for _, key := range ownerTagKeys {
+		if value, ok := k.Tags[key]; ok {
+			tags = append(tags, key+"="+value)
+		}
+	}
+	return strings.Join(tags, ", ")
+}
+
+// Describe is one line identifying a key, e.g. 1234abcd-... (alias/my-app) [Stack=deploy].
+func (k keyDetails) Describe() string {
+	description := k.Id
+	if len(k.Aliases) > 0 {
+		description += " (" + strings.Join(k.Aliases, ", ") + ")"
+	}
+	if owner := k.OwnerTags(); owner != "" {
+		description += " [" + owner + "]"
+	}
+	return description
+}
+
+func getKeyAliases(ctx context.Context, kmsClient *kms.Client, keyId string) ([]string, error) {
+	var aliases []string
+	paginator := kms.NewListAliasesPaginator(kmsClient, &kms.ListAliasesInput{KeyId: &keyId})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list aliases for key %s: %w", keyId, err)
+		}
+		for _, alias := range page.Aliases {
+			aliases = append(aliases, aws.ToString(alias.AliasName))
+		}
+	}
+	slices.Sort(aliases)
+	return aliases, nil
+}
+
+func getKeyTags(ctx context.Context, kmsClient *kms.Client, keyId string) (map[string]string, error) {
+	tags := map[string]string{}
+	paginator := kms.NewListResourceTagsPaginator(kmsClient, &kms.ListResourceTagsInput{KeyId: &keyId})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tags for key %s: %w", keyId, err)
+		}
+		for _, tag := range page.Tags {
+			tags[aws.ToString(tag.TagKey)] = aws.ToString(tag.TagValue)
+		}
+	}
+	return tags, nil
+}
+
+func getKeyDetails(ctx context.Context, kmsClient *kms.Client, keyId string) (keyDetails, error) {
+	metadata, err := describeKey(ctx, kmsClient, keyId)
+	if err != nil {
+		return keyDetails{}, err
+	}
+	details := keyDetails{Id: keyId, SkipReason: skipReason(metadata)}
+	if details.SkipReason != "" {
+		return details, nil
+	}
+
+	details.Aliases, err = getKeyAliases(ctx, kmsClient, keyId)
+	if err != nil {
+		return keyDetails{}, err
+	}
+	details.Tags, err = getKeyTags(ctx, kmsClient, keyId)
+	if err != nil {
+		return keyDetails{}, err
+	}
+	return details, nil
+}
+
+// RegionKeys is everything we need to know about a region to decide which
+// keys to rotate. It is gathered concurrently and printed afterwards.
+type RegionKeys struct {
+	Region        string
+	FailingKeys   []keyDetails
+	KeysInStacks  []common.StackResources
+	ManagedInCode []common.ManagedBy // Managed by IaC other than CloudFormation
+	StackPatches  []common.StackPatch
+	Err           error
+}
+
+func FindRegionKeys(ctx context.Context, kmsClient *kms.Client, cfnClient *cloudformation.Client, opts common.Options, failing common.FailingResources, accountId string, rotationPeriod int32) RegionKeys {
+	region := failing.Region
+	var keyIds []string
+	for _, arn := range failing.Arns {
+		keyIds = append(keyIds, keyId(arn))
+	}
+
+	type detailsResult struct {
+		details keyDetails
+		err     error
+	}
+	results := common.ParallelMap(ctx, opts.Concurrency, keyIds, func(ctx context.Context, keyId string) detailsResult {
+		details, err := getKeyDetails(ctx, kmsClient, keyId)
+		return detailsResult{details: details, err: err}
+	})
+	var failingKeys []keyDetails
+	var rotatable []string
+	var errs []error
+	for _, res := range results {
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		failingKeys = append(failingKeys, res.details)
+		if res.details.SkipReason == "" {
+			rotatable = append(rotatable, res.details.Id)
+		}
+	}
+	if len(errs) > 0 {
+		return RegionKeys{Region: region, Err: fmt.Errorf("could not describe failing keys: %w", errors.Join(errs...))}
+	}
+
+	keysInStacks, err := opts.StackLookup.FindResourcesInStacks(ctx, cfnClient, accountId, region, common.ResourceTypeKmsKey, rotatable, opts.Concurrency)
+	if err != nil {
+		return RegionKeys{Region: region, Err: fmt.Errorf("could not determine which keys are in CloudFormation stacks: %w", err)}
+	}
+
+	notInStacks := common.Without(rotatable, common.ResourcesInStacks(keysInStacks))
+	var resources []common.ManagedResource
+	for _, key := range failingKeys {
+		if slices.Contains(notInStacks, key.Id) {
+			resources = append(resources, common.ManagedResource{Id: key.Id, Type: common.ResourceTypeKmsKey, Tags: key.Tags})
+		}
+	}
+
+	return RegionKeys{
+		Region:        region,
+		FailingKeys:   failingKeys,
+		KeysInStacks:  keysInStacks,
+		ManagedInCode: opts.Detectors.Detect(resources),
+		StackPatches:  common.BuildStackPatches(ctx, cfnClient, region, keysInStacks, KeyRotationPatch(rotationPeriod), opts.Concurrency),
+	}
+}
+
+// FindKeysToRotate prints what we found in a region, and returns the keys to
+// turn rotation on for.
+func FindKeysToRotate(regionKeys RegionKeys) []keyDetails {
+	var skipped []common.Skipped
+	for _, key := range regionKeys.FailingKeys {
+		if key.SkipReason != "" {
+			skipped = append(skipped, common.Skipped{Name: key.Id, Reason: key.SkipReason})
+		}
+	}
+	excluded := common.PrintExcluded("keys", skipped, regionKeys.KeysInStacks, regionKeys.ManagedInCode)
+
+	var toRotate []keyDetails
+	for _, key := range regionKeys.FailingKeys {
+		if key.SkipReason == "" && !slices.Contains(excluded, key.Id) {
+			toRotate = append(toRotate, key)
+		}
+	}
+
+	if len(toRotate) > 0 {
+		fmt.Fprintln(common.Out, "\nTurning on rotation for the following keys:")
+		for idx, key := range toRotate {
+			fmt.Fprintln(common.Out, idx+1, key.Describe())
+		}
+		fmt.Fprint(common.Out, "\n")
+	}
+
+	failingKeyCount := len(regionKeys.FailingKeys)
+	fmt.Fprintln(common.Out, failingKeyCount, "failing keys found.")
+	fmt.Fprintln(common.Out, len(toRotate), "to rotate, and", failingKeyCount-len(toRotate), "to skip.")
+	return toRotate
+}
+
+// rotationParameters are what enableKeyRotation sets, for the audit log.
+func rotationParameters(rotationPeriod int32) map[string]any {
+	if rotationPeriod == 0 {
+		return map[string]any{}
+	}
+	return map[string]any{"RotationPeriodInDays": rotationPeriod}
+}
+
+// enableKeyRotation turns on automatic rotation. A rotation period of 0 uses
+// the KMS default of 365 days.
+func enableKeyRotation(ctx context.Context, kmsClient *kms.Client, keyId string, rotationPeriod int32) error {
+	input := &kms.EnableKeyRotationInput{KeyId: &keyId}
+	if rotationPeriod != 0 {
+		input.RotationPeriodInDays = &rotationPeriod
+	}
+	if _, err := kmsClient.EnableKeyRotation(ctx, input); err != nil {
+		return err
+	}
+	slog.Info("Enabled key rotation", "key", keyId)
+	return nil
+}
@@ -0,0 +1,48 @@
+package kmsutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func TestKeyId(t *testing.T) {
+	arn := "arn:aws:kms:eu-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	if got := keyId(arn); got != "1234abcd-12ab-34cd-56ef-1234567890ab" {
+		t.Errorf("Error getting key ID from ARN. Got %s", got)
+	}
+}
+
+func TestDescribeKey(t *testing.T) {
+	key := keyDetails{
+		Id:      "1234abcd",
+		Aliases: []string{"alias/deploy", "alias/riff-raff"},
+		Tags:    map[string]string{"App": "riff-raff", "Stack": "deploy", "Name": "ignored"},
+	}
+	expected := "1234abcd (alias/deploy, alias/riff-raff) [Stack=deploy, App=riff-raff]"
+	if got := key.Describe(); got != expected {
+		t.Errorf("Error describing key. Expected %q, got %q", expected, got)
+	}
+	if got := (keyDetails{Id: "1234abcd"}).Describe(); got != "1234abcd" {
+		t.Errorf("Error describing key without aliases or tags. Got %q", got)
+	}
+}
+
+func TestFindKeysToRotate(t *testing.T) {
+	regionKeys := RegionKeys{
+		Region: "eu-west-1",
+		FailingKeys: []keyDetails{
+			{Id: "rotatable"},
+			{Id: "pending-deletion", SkipReason: "pending deletion"},
+			{Id: "in-stack"},
+			{Id: "in-terraform"},
+		},
+		KeysInStacks:  []common.StackResources{{StackName: "deploy", PhysicalIds: []string{"in-stack"}}},
+		ManagedInCode: []common.ManagedBy{{Id: "in-terraform", Reason: "tagged terraform=true"}},
+	}
+	expected := []keyDetails{{Id: "rotatable"}}
+	if got := FindKeysToRotate(regionKeys); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error finding keys to rotate. Expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,95 @@
+package kmsutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsTypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// skipReason explains why a key cannot have automatic rotation turned on, or
+// returns an empty string if it can. Only enabled, customer-managed symmetric
+// encryption keys with key material generated by KMS can be rotated.
+func skipReason(m *kmsTypes.KeyMetadata) string {
+	switch {
+	case m == nil:
+		return "no key metadata"
+	case m.KeyManager != kmsTypes.KeyManagerTypeCustomer:
+		return "managed by AWS"
+	case m.KeyState == kmsTypes.KeyStatePendingDeletion || m.KeyState == kmsTypes.KeyStatePendingReplicaDeletion:
+		return "pending deletion"
+	case m.KeyState != kmsTypes.KeyStateEnabled:
+		return fmt.Sprintf("key state is %s", m.KeyState)
+	case m.KeySpec != kmsTypes.KeySpecSymmetricDefault:
+		return fmt.Sprintf("key spec %s does not support rotation", m.KeySpec)
+	case aws.ToString(m.CustomKeyStoreId) != "" || m.Origin != kmsTypes.OriginTypeAwsKms:
+		return fmt.Sprintf("key material from %s does not support rotation", m.Origin)
+	case m.MultiRegionConfiguration != nil && m.MultiRegionConfiguration.MultiRegionKeyType == kmsTypes.MultiRegionKeyTypeReplica:
+		return "multi-Region replica, rotate the primary key instead"
+	}
+	return ""
+}
+
+func describeKey(ctx context.Context, kmsClient *kms.Client, keyId string) (*kmsTypes.KeyMetadata, error) {
+	resp, err := kmsClient.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: &keyId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe key %s: %w", keyId, err)
+	}
+	return resp.KeyMetadata, nil
+}
+
+func rotationEnabled(ctx context.Context, kmsClient *kms.Client, keyId string) (bool, error) {
+	resp, err := kmsClient.GetKeyRotationStatus(ctx, &kms.GetKeyRotationStatusInput{KeyId: &keyId})
+	if err != nil {
+		return false, fmt.Errorf("failed to get rotation status for key %s: %w", keyId, err)
+	}
+	return resp.KeyRotationEnabled, nil
+}
+
+// EvaluateKMS_4 finds the keys in a region which could be rotated but aren't,
+// without relying on Security Hub.
+func EvaluateKMS_4(sess *common.Session, concurrency int) common.Evaluator {
+	return func(ctx context.Context, region string) ([]string, error) {
+		kmsClient := sess.KMS(region)
+		var keyIds []string
+		paginator := kms.NewListKeysPaginator(kmsClient, &kms.ListKeysInput{})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list keys: %w", err)
+			}
+			for _, key := range page.Keys {
+				keyIds = append(keyIds, aws.ToString(key.KeyId))
+			}
+		}
+
+		type result struct {
+			arn     string
+			failing bool
+			err     error
+		}
+		results := common.ParallelMap(ctx, concurrency, keyIds, func(ctx context.Context, keyId string) result {
+			metadata, err := describeKey(ctx, kmsClient, keyId)
+			if err != nil || skipReason(metadata) != "" {
+				return result{err: err}
+			}
+			enabled, err := rotationEnabled(ctx, kmsClient, keyId)
+			return result{arn: aws.ToString(metadata.Arn), failing: !enabled, err: err}
+		})
+
+		var failing []string
+		var errs []error
+		for _, res := range results {
+			if res.err != nil {
+				errs = append(errs, res.err)
+			} else if res.failing {
+				failing = append(failing, res.arn)
+			}
+		}
+		return failing, errors.Join(errs...)
+	}
+}
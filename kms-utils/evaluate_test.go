@@ -0,0 +1,52 @@
+package kmsutils
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmsTypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+func TestSkipReason(t *testing.T) {
+	rotatable := kmsTypes.KeyMetadata{
+		KeyManager: kmsTypes.KeyManagerTypeCustomer,
+		KeyState:   kmsTypes.KeyStateEnabled,
+		KeySpec:    kmsTypes.KeySpecSymmetricDefault,
+		Origin:     kmsTypes.OriginTypeAwsKms,
+	}
+	with := func(change func(m *kmsTypes.KeyMetadata)) *kmsTypes.KeyMetadata {
+		m := rotatable
+		change(&m)
+		return &m
+	}
+
+	cases := []struct {
+		name      string
+		metadata  *kmsTypes.KeyMetadata
+		rotatable bool
+	}{
+		{"customer-managed symmetric key", &rotatable, true},
+		{"AWS managed key", with(func(m *kmsTypes.KeyMetadata) { m.KeyManager = kmsTypes.KeyManagerTypeAws }), false},
+		{"key pending deletion", with(func(m *kmsTypes.KeyMetadata) { m.KeyState = kmsTypes.KeyStatePendingDeletion }), false},
+		{"disabled key", with(func(m *kmsTypes.KeyMetadata) { m.KeyState = kmsTypes.KeyStateDisabled }), false},
+		{"asymmetric key", with(func(m *kmsTypes.KeyMetadata) { m.KeySpec = kmsTypes.KeySpecRsa2048 }), false},
+		{"HMAC key", with(func(m *kmsTypes.KeyMetadata) { m.KeySpec = kmsTypes.KeySpecHmac256 }), false},
+		{"imported key material", with(func(m *kmsTypes.KeyMetadata) { m.Origin = kmsTypes.OriginTypeExternal }), false},
+		{"CloudHSM key store", with(func(m *kmsTypes.KeyMetadata) {
+			m.Origin = kmsTypes.OriginTypeAwsCloudhsm
+			m.CustomKeyStoreId = aws.String("cks-1234567890abcdef0")
+		}), false},
+		{"multi-Region replica", with(func(m *kmsTypes.KeyMetadata) {
+			m.MultiRegionConfiguration = &kmsTypes.MultiRegionConfiguration{MultiRegionKeyType: kmsTypes.MultiRegionKeyTypeReplica}
+		}), false},
+		{"multi-Region primary", with(func(m *kmsTypes.KeyMetadata) {
+			m.MultiRegionConfiguration = &kmsTypes.MultiRegionConfiguration{MultiRegionKeyType: kmsTypes.MultiRegionKeyTypePrimary}
+		}), true},
+		{"no metadata", nil, false},
+	}
+	for _, c := range cases {
+		if got := skipReason(c.metadata) == ""; got != c.rotatable {
+			t.Errorf("Error evaluating %s. Expected rotatable %v, got reason %q", c.name, c.rotatable, skipReason(c.metadata))
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package kmsutils
+
+import (
+	"context"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// FixKMS_4 turns on automatic rotation for failing keys in every region,
+// with rotationPeriod in days, or the KMS default if 0.
+func FixKMS_4(ctx context.Context, sess *common.Session, opts common.Options, rotationPeriod int32) (common.Result, error) {
+	return common.Remediate(ctx, sess, opts, common.Remediation[RegionKeys, string]{
+		ControlId: "KMS.4",
+		Noun:      "keys",
+		Action:    "turn on key rotation",
+		Evaluate:  EvaluateKMS_4(sess, opts.Concurrency),
+		Find: func(ctx context.Context, f common.FailingResources) (RegionKeys, error) {
+			regionKeys := FindRegionKeys(ctx, sess.KMS(f.Region), sess.CloudFormation(f.Region), opts, f, sess.AccountId, rotationPeriod)
+			return regionKeys, regionKeys.Err
+		},
+		Plan: func(regionKeys RegionKeys) ([]string, []common.StackPatch) {
+			var ids []string
+			for _, key := range FindKeysToRotate(regionKeys) {
+				ids = append(ids, key.Id)
+			}
+			return ids, regionKeys.StackPatches
+		},
+		Apply: func(ctx context.Context, change common.Change[string]) error {
+			return enableKeyRotation(ctx, sess.KMS(change.Region), change.Item, rotationPeriod)
+		},
+		Audit: func(key string) (string, string, map[string]any) {
+			return "kms:EnableKeyRotation", key, rotationParameters(rotationPeriod)
+		},
+		Verify: func(ctx context.Context, change common.Change[string]) (bool, error) {
+			return rotationEnabled(ctx, sess.KMS(change.Region), change.Item)
+		},
+		Id: func(key string) string { return key },
+	})
+}
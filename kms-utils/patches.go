@@ -0,0 +1,30 @@
+package kmsutils
+
+import (
+	"fmt"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// The rotation periods KMS accepts, in days
+const (
+	MinRotationPeriod = 90
+	MaxRotationPeriod = 2560
+)
+
+func ValidateRotationPeriod(days int) error {
+	if days != 0 && (days < MinRotationPeriod || days > MaxRotationPeriod) {
+		return fmt.Errorf("rotation period must be between %d and %d days, or 0 for the KMS default, got %d", MinRotationPeriod, MaxRotationPeriod, days)
+	}
+	return nil
+}
+
+// KeyRotationPatch proposes the template change that turns on rotation for a
+// stack-managed key, with the given period in days, or the default if 0.
+func KeyRotationPatch(rotationPeriod int32) common.PatchBuilder {
+	wanted := map[string]any{"EnableKeyRotation": true}
+	if rotationPeriod != 0 {
+		wanted["RotationPeriodInDays"] = int(rotationPeriod)
+	}
+	return common.PropertiesPatch(wanted)
+}
@@ -0,0 +1,58 @@
+package kmsutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/commontest"
+)
+
+func TestPatchKeyWithoutProperties(t *testing.T) {
+	template := commontest.Template(t, `
+Resources:
+  Key:
+    Type: AWS::KMS::Key
+`)
+	result := KeyRotationPatch(0)(template, "Key")
+	expected := []common.PatchOp{{
+		Op:    "add",
+		Path:  "/Resources/Key/Properties",
+		Value: map[string]any{"EnableKeyRotation": true},
+	}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Error patching key without properties. Expected %v, got %v", expected, result)
+	}
+}
+
+func TestPatchKeyWithRotationPeriod(t *testing.T) {
+	template := commontest.Template(t, `
+Resources:
+  Key:
+    Type: AWS::KMS::Key
+    Properties:
+      EnableKeyRotation: false
+      KeyPolicy: {}
+`)
+	result := KeyRotationPatch(180)(template, "Key")
+	expected := []common.PatchOp{
+		{Op: "replace", Path: "/Resources/Key/Properties/EnableKeyRotation", Value: true},
+		{Op: "add", Path: "/Resources/Key/Properties/RotationPeriodInDays", Value: 180},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Error patching key with rotation period. Expected %v, got %v", expected, result)
+	}
+}
+
+func TestValidateRotationPeriod(t *testing.T) {
+	for _, days := range []int{0, 90, 365, 2560} {
+		if err := ValidateRotationPeriod(days); err != nil {
+			t.Errorf("Expected rotation period %d to be valid, got %v", days, err)
+		}
+	}
+	for _, days := range []int{-1, 89, 2561} {
+		if err := ValidateRotationPeriod(days); err == nil {
+			t.Errorf("Expected rotation period %d to be invalid", days)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package lambdautils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/policy"
+)
+
+// publicStatement is a statement to remove from a function's policy.
+type publicStatement struct {
+	Function  string
+	Statement policy.Statement
+}
+
+// publicFunction is a failing function, and the statements making it public.
+type publicFunction struct {
+	Name       string
+	Arn        string
+	Statements []policy.Statement
+	Tags       map[string]string
+}
+
+func getFunctionTags(ctx context.Context, lambdaClient *lambda.Client, arn string) (map[string]string, error) {
+	resp, err := lambdaClient.ListTags(ctx, &lambda.ListTagsInput{Resource: &arn})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags for function %s: %w", functionName(arn), err)
+	}
+	return resp.Tags, nil
+}
+
+func getPublicFunction(ctx context.Context, lambdaClient *lambda.Client, arn string) (publicFunction, error) {
+	function := publicFunction{Name: functionName(arn), Arn: arn}
+	document, err := getPolicy(ctx, lambdaClient, function.Name)
+	if err != nil {
+		return publicFunction{}, err
+	}
+	function.Statements = document.PublicStatements()
+	if len(function.Statements) == 0 {
+		return function, nil
+	}
+	function.Tags, err = getFunctionTags(ctx, lambdaClient, arn)
+	if err != nil {
+		return publicFunction{}, err
+	}
+	return function, nil
+}
+
+// RegionFunctions is everything we need to know about a region to decide
+// which statements to remove. It is gathered concurrently and printed
+// afterwards.
+type RegionFunctions struct {
+	Region              string
+	FailingFunctions    []publicFunction
+	PermissionsInStacks []common.StackResources // Keyed by statement ID, which is a permission's physical ID
+	ManagedInCode       []common.ManagedBy      // Managed by IaC other than CloudFormation
+	StackPatches        []common.StackPatch
+	Err                 error
+}
+
+func FindRegionFunctions(ctx context.Context, lambdaClient *lambda.Client, cfnClient *cloudformation.Client, opts common.Options, failing common.FailingResources, accountId string) RegionFunctions {
+	region := failing.Region
+	type functionResult struct {
+		function publicFunction
+		err      error
+	}
+	results := common.ParallelMap(ctx, opts.Concurrency, failing.Arns, func(ctx context.Context, arn string) functionResult {
+		function, err := getPublicFunction(ctx, lambdaClient, arn)
+		return functionResult{function: function, err: err}
+	})
+	var functions []publicFunction
+	var statementIds []string
+	var errs []error
+	for _, res := range results {
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		functions = append(functions, res.function)
+		for _, statement := range res.function.Statements {
+			statementIds = append(statementIds, statement.Sid)
+		}
+	}
+	if len(errs) > 0 {
+		return RegionFunctions{Region: region, Err: fmt.Errorf("could not read failing function policies: %w", errors.Join(errs...))}
+	}
+
+	// CloudFormation uses a permission's physical ID as its statement ID, so
+	// we can tell exactly which statements a stack owns
+	permissionsInStacks, err := opts.StackLookup.FindResourcesInStacks(ctx, cfnClient, accountId, region, common.ResourceTypeLambdaPermission, statementIds, opts.Concurrency)
+	if err != nil {
+		return RegionFunctions{Region: region, Err: fmt.Errorf("could not determine which permissions are in CloudFormation stacks: %w", err)}
+	}
+
+	var resources []common.ManagedResource
+	for _, function := range functions {
+		if len(function.Statements) > 0 {
+			resources = append(resources, common.ManagedResource{Id: function.Name, Type: common.ResourceTypeLambdaFunction, Tags: function.Tags})
+		}
+	}
+
+	return RegionFunctions{
+		Region:              region,
+		FailingFunctions:    functions,
+		PermissionsInStacks: permissionsInStacks,
+		ManagedInCode:       opts.Detectors.Detect(resources),
+		StackPatches:        common.BuildStackPatches(ctx, cfnClient, region, permissionsInStacks, RemovePermissionPatch, opts.Concurrency),
+	}
+}
+
+// FindStatementsToRemove prints each offending statement, and returns those
+// to remove.
+func FindStatementsToRemove(regionFunctions RegionFunctions) []publicStatement {
+	var skipped []common.Skipped
+	for _, function := range regionFunctions.FailingFunctions {
+		if len(function.Statements) == 0 {
+			skipped = append(skipped, common.Skipped{Name: function.Name, Reason: "its policy is no longer public"})
+		}
+	}
+	common.PrintExcluded("permissions", skipped, regionFunctions.PermissionsInStacks, regionFunctions.ManagedInCode)
+	// Permissions are in stacks by statement ID, but managed in code by function
+	inStacks := common.ResourcesInStacks(regionFunctions.PermissionsInStacks)
+	managedInCode := common.ManagedIds(regionFunctions.ManagedInCode)
+
+	var toRemove []publicStatement
+	for _, function := range regionFunctions.FailingFunctions {
+		if slices.Contains(managedInCode, function.Name) {
+			continue
+		}
+		for _, statement := range function.Statements {
+			if !slices.Contains(inStacks, statement.Sid) {
+				toRemove = append(toRemove, publicStatement{Function: function.Name, Statement: statement})
+			}
+		}
+	}
+
+	if len(toRemove) > 0 {
+		fmt.Fprintln(common.Out, "\nRemoving the following public statements:")
+		for idx, statement := range toRemove {
+			fmt.Fprintf(common.Out, "%d %s: %s\n", idx+1, statement.Function, statement.Statement)
+		}
+		fmt.Fprint(common.Out, "\n")
+	}
+
+	fmt.Fprintln(common.Out, len(regionFunctions.FailingFunctions), "failing functions found.")
+	fmt.Fprintln(common.Out, len(toRemove), "public statements to remove.")
+	return toRemove
+}
+
+// removalParameters record a removed statement in full, with the
+// AddPermission parameters that would put it back. If AddPermission can't
+// recreate the statement exactly, they say so instead, and the statement
+// itself is the only record of what to restore.
+func removalParameters(statement policy.Statement) map[string]any {
+	parameters := map[string]any{
+		"StatementId": statement.Sid,
+		"Statement":   statement,
+	}
+	if addPermission, ok := addPermissionParameters(statement); ok {
+		parameters["AddPermission"] = addPermission
+	} else {
+		parameters["RollBack"] = "AddPermission can't recreate this statement exactly. See Statement for what was removed"
+	}
+	return parameters
+}
+
+func removePermission(ctx context.Context, lambdaClient *lambda.Client, statement publicStatement) error {
+	_, err := lambdaClient.RemovePermission(ctx, &lambda.RemovePermissionInput{
+		FunctionName: &statement.Function,
+		StatementId:  &statement.Statement.Sid,
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Removed public statement", "function", statement.Function, "statement", statement.Statement.Sid)
+	return nil
+}
+
+func statementRemoved(ctx context.Context, lambdaClient *lambda.Client, statement publicStatement) (bool, error) {
+	document, err := getPolicy(ctx, lambdaClient, statement.Function)
+	if err != nil {
+		return false, err
+	}
+	return !slices.ContainsFunc(document.Statement, func(s policy.Statement) bool { return s.Sid == statement.Statement.Sid }), nil
+}
@@ -0,0 +1,47 @@
+package lambdautils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/policy"
+)
+
+func TestFunctionName(t *testing.T) {
+	cases := map[string]string{
+		"arn:aws:lambda:eu-west-1:123456789012:function:api":      "api",
+		"arn:aws:lambda:eu-west-1:123456789012:function:api:live": "api",
+	}
+	for arn, expected := range cases {
+		if got := functionName(arn); got != expected {
+			t.Errorf("Error getting function name from %s. Expected %s, got %s", arn, expected, got)
+		}
+	}
+}
+
+func TestFindStatementsToRemove(t *testing.T) {
+	public := policy.Statement{Sid: "public", Effect: "Allow", Principal: policy.Principals{"AWS": {"*"}}, Action: policy.Values{"lambda:InvokeFunction"}}
+	inStack := policy.Statement{Sid: "app-ApiPermission-ABC123", Effect: "Allow", Principal: policy.Principals{"AWS": {"*"}}, Action: policy.Values{"lambda:InvokeFunction"}}
+	regionFunctions := RegionFunctions{
+		Region: "eu-west-1",
+		FailingFunctions: []publicFunction{
+			{Name: "api", Statements: []policy.Statement{public, inStack}},
+			{Name: "fixed-already"},
+			{Name: "in-terraform", Statements: []policy.Statement{public}},
+		},
+		PermissionsInStacks: []common.StackResources{{StackName: "app", PhysicalIds: []string{"app-ApiPermission-ABC123"}}},
+		ManagedInCode:       []common.ManagedBy{{Id: "in-terraform", Reason: "in Terraform state"}},
+	}
+	expected := []publicStatement{{Function: "api", Statement: public}}
+	if got := FindStatementsToRemove(regionFunctions); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error finding statements to remove. Expected %v, got %v", expected, got)
+	}
+}
+
+func TestRemovePermissionPatch(t *testing.T) {
+	expected := []common.PatchOp{{Op: "remove", Path: "/Resources/ApiPermission"}}
+	if got := RemovePermissionPatch(common.StackTemplate{}, "ApiPermission"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error patching ApiPermission. Expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,77 @@
+package lambdautils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/policy"
+)
+
+// functionName turns the ARN of a failing function into its name, dropping
+// any version or alias.
+func functionName(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) >= 7 && parts[5] == "function" {
+		return parts[6]
+	}
+	return arn
+}
+
+// getPolicy returns a function's resource-based policy, which is empty if
+// it has none.
+func getPolicy(ctx context.Context, lambdaClient *lambda.Client, function string) (policy.Document, error) {
+	resp, err := lambdaClient.GetPolicy(ctx, &lambda.GetPolicyInput{FunctionName: &function})
+	var notFound *lambdaTypes.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return policy.Document{}, nil
+	}
+	if err != nil {
+		return policy.Document{}, fmt.Errorf("failed to get policy for function %s: %w", function, err)
+	}
+	return policy.Parse(aws.ToString(resp.Policy))
+}
+
+// EvaluateLambda_1 finds the functions in a region whose policy lets anyone
+// invoke them, without relying on Security Hub.
+func EvaluateLambda_1(sess *common.Session, concurrency int) common.Evaluator {
+	return func(ctx context.Context, region string) ([]string, error) {
+		lambdaClient := sess.Lambda(region)
+		var arns []string
+		paginator := lambda.NewListFunctionsPaginator(lambdaClient, &lambda.ListFunctionsInput{})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list functions: %w", err)
+			}
+			for _, function := range page.Functions {
+				arns = append(arns, aws.ToString(function.FunctionArn))
+			}
+		}
+
+		type result struct {
+			public bool
+			err    error
+		}
+		results := common.ParallelMap(ctx, concurrency, arns, func(ctx context.Context, arn string) result {
+			document, err := getPolicy(ctx, lambdaClient, functionName(arn))
+			return result{public: len(document.PublicStatements()) > 0, err: err}
+		})
+
+		var failing []string
+		var errs []error
+		for i, res := range results {
+			if res.err != nil {
+				errs = append(errs, res.err)
+			} else if res.public {
+				failing = append(failing, arns[i])
+			}
+		}
+		return failing, errors.Join(errs...)
+	}
+}
@@ -0,0 +1,14 @@
+package lambdautils
+
+import (
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// RemovePermissionPatch proposes removing a stack-managed permission that
+// makes a function public.
+func RemovePermissionPatch(template common.StackTemplate, logicalId string) []common.PatchOp {
+	return []common.PatchOp{{
+		Op:   "remove",
+		Path: common.ResourcePointer(logicalId),
+	}}
+}
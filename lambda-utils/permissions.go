@@ -0,0 +1,44 @@
+package lambdautils
+
+import (
+	"context"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// FixLambda_1 removes the statements that make failing functions public, in
+// every region. Statements scoped by a source ARN or account are kept. Each
+// removed statement is recorded in the audit log, with the AddPermission
+// parameters needed to restore it.
+func FixLambda_1(ctx context.Context, sess *common.Session, opts common.Options) (common.Result, error) {
+	return common.Remediate(ctx, sess, opts, common.Remediation[RegionFunctions, publicStatement]{
+		ControlId: "Lambda.1",
+		Noun:      "functions",
+		Changes:   "function policy statements",
+		Action:    "remove public statements",
+		Evaluate:  EvaluateLambda_1(sess, opts.Concurrency),
+		Find: func(ctx context.Context, f common.FailingResources) (RegionFunctions, error) {
+			regionFunctions := FindRegionFunctions(ctx, sess.Lambda(f.Region), sess.CloudFormation(f.Region), opts, f, sess.AccountId)
+			return regionFunctions, regionFunctions.Err
+		},
+		Plan: func(regionFunctions RegionFunctions) ([]publicStatement, []common.StackPatch) {
+			return FindStatementsToRemove(regionFunctions), regionFunctions.StackPatches
+		},
+		Apply: func(ctx context.Context, change common.Change[publicStatement]) error {
+			return removePermission(ctx, sess.Lambda(change.Region), change.Item)
+		},
+		Audit: func(statement publicStatement) (string, string, map[string]any) {
+			return "lambda:RemovePermission", statement.Function, removalParameters(statement.Statement)
+		},
+		Verify: func(ctx context.Context, change common.Change[publicStatement]) (bool, error) {
+			return statementRemoved(ctx, sess.Lambda(change.Region), change.Item)
+		},
+		Id: func(statement publicStatement) string { return statement.Function + "/" + statement.Statement.Sid },
+		Notes: func(removed []common.Change[publicStatement]) []string {
+			if len(removed) == 0 {
+				return nil
+			}
+			return []string{"Each removed statement is recorded in the audit log, with the AddPermission parameters to restore it."}
+		},
+	})
+}
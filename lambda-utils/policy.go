@@ -0,0 +1,101 @@
+package lambdautils
+
+import (
+	"strings"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/policy"
+)
+
+// principal returns the single principal AddPermission expects, or false if
+// the statement has more than one, or one of a type AddPermission can't set.
+func principal(s policy.Statement) (string, bool) {
+	if s.Principal.Everyone() {
+		return "*", len(s.Principal) == 1 && len(s.Principal["AWS"]) == 1
+	}
+	if len(s.Principal) != 1 {
+		return "", false
+	}
+	for _, kind := range []string{"AWS", "Service"} {
+		if values := s.Principal[kind]; len(values) == 1 {
+			return values[0], true
+		}
+	}
+	return "", false
+}
+
+// addPermissionCondition is a condition AddPermission sets from a parameter.
+type addPermissionCondition struct {
+	operators []string
+	key       string // Lower case, as policy.Conditions stores it
+}
+
+var addPermissionConditions = map[string]addPermissionCondition{
+	"SourceArn":             {[]string{"ArnLike", "ArnEquals"}, "aws:sourcearn"},
+	"SourceAccount":         {[]string{"StringEquals"}, "aws:sourceaccount"},
+	"PrincipalOrgID":        {[]string{"StringEquals"}, "aws:principalorgid"},
+	"EventSourceToken":      {[]string{"StringEquals"}, "lambda:eventsourcetoken"},
+	"FunctionUrlAuthType":   {[]string{"StringEquals"}, "lambda:functionurlauthtype"},
+	"InvokedViaFunctionUrl": {[]string{"Bool"}, "lambda:invokedviafunctionurl"},
+}
+
+// conditionParameter returns the AddPermission parameter that sets a
+// condition, or false if there isn't one.
+func conditionParameter(operator string, key string) (string, bool) {
+	for parameter, condition := range addPermissionConditions {
+		for _, o := range condition.operators {
+			if strings.EqualFold(o, operator) && condition.key == key {
+				return parameter, true
+			}
+		}
+	}
+	return "", false
+}
+
+// qualifier returns the version or alias a statement's resource names, e.g.
+// prod in arn:aws:lambda:eu-west-1:123456789012:function:api:prod.
+func qualifier(resource string) string {
+	if parts := strings.Split(resource, ":"); len(parts) == 8 {
+		return parts[7]
+	}
+	return ""
+}
+
+// addPermissionParameters are the AddPermission parameters that would put a
+// removed statement back, so the audit log can be used to roll it back. It
+// returns false if AddPermission can't recreate the statement exactly, e.g.
+// one with several principals, or a condition AddPermission has no parameter
+// for.
+func addPermissionParameters(s policy.Statement) (map[string]any, bool) {
+	principal, ok := principal(s)
+	// Function policies have one action and resource per statement
+	simple := s.Effect == "Allow" && s.NotPrincipal == nil && s.NotAction == nil && s.NotResource == nil &&
+		len(s.Action) == 1 && len(s.Resource) <= 1
+	if !ok || !simple {
+		return nil, false
+	}
+
+	parameters := map[string]any{
+		"StatementId": s.Sid,
+		"Principal":   principal,
+		"Action":      s.Action[0],
+	}
+	if len(s.Resource) == 1 {
+		if qualifier := qualifier(s.Resource[0]); qualifier != "" {
+			parameters["Qualifier"] = qualifier
+		}
+	}
+	for operator, keys := range s.Condition {
+		for key, values := range keys {
+			parameter, ok := conditionParameter(operator, key)
+			if _, set := parameters[parameter]; !ok || set || len(values) != 1 {
+				return nil, false
+			}
+			if parameter == "InvokedViaFunctionUrl" {
+				parameters[parameter] = strings.EqualFold(values[0], "true")
+			} else {
+				parameters[parameter] = values[0]
+			}
+		}
+	}
+	return parameters, true
+}
@@ -0,0 +1,65 @@
+package lambdautils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/policy"
+)
+
+func TestAddPermissionParameters(t *testing.T) {
+	document, err := policy.Parse(`{
+  "Version": "2012-10-17",
+  "Statement": [
+    {"Sid": "url", "Effect": "Allow", "Principal": "*", "Action": "lambda:InvokeFunctionUrl", "Resource": "arn:aws:lambda:eu-west-1:123456789012:function:api",
+     "Condition": {"StringEquals": {"lambda:FunctionUrlAuthType": "NONE"}}},
+    {"Sid": "events", "Effect": "Allow", "Principal": {"Service": "events.amazonaws.com"}, "Action": "lambda:InvokeFunction", "Resource": "arn:aws:lambda:eu-west-1:123456789012:function:api:prod",
+     "Condition": {"ArnLike": {"AWS:SourceArn": "arn:aws:events:eu-west-1:123456789012:rule/*"}, "StringEquals": {"AWS:SourceAccount": "123456789012"}}},
+    {"Sid": "org", "Effect": "Allow", "Principal": "*", "Action": "lambda:InvokeFunction", "Resource": "arn:aws:lambda:eu-west-1:123456789012:function:api",
+     "Condition": {"StringEquals": {"aws:PrincipalOrgID": "o-abc123"}, "Bool": {"lambda:InvokedViaFunctionUrl": "true"}}},
+    {"Sid": "two-principals", "Effect": "Allow", "Principal": {"AWS": ["*", "arn:aws:iam::111122223333:root"]}, "Action": "lambda:InvokeFunction", "Resource": "arn:aws:lambda:eu-west-1:123456789012:function:api"},
+    {"Sid": "ip", "Effect": "Allow", "Principal": "*", "Action": "lambda:InvokeFunction", "Resource": "arn:aws:lambda:eu-west-1:123456789012:function:api",
+     "Condition": {"IpAddress": {"aws:SourceIp": "0.0.0.0/0"}}},
+    {"Sid": "two-arns", "Effect": "Allow", "Principal": "*", "Action": "lambda:InvokeFunction", "Resource": "arn:aws:lambda:eu-west-1:123456789012:function:api",
+     "Condition": {"ArnLike": {"AWS:SourceArn": ["arn:aws:s3:::a", "arn:aws:s3:::b"]}}}
+  ]
+}`)
+	if err != nil {
+		t.Fatalf("Error parsing policy: %v", err)
+	}
+
+	cases := map[string]map[string]any{
+		"url": {
+			"StatementId":         "url",
+			"Action":              "lambda:InvokeFunctionUrl",
+			"Principal":           "*",
+			"FunctionUrlAuthType": "NONE",
+		},
+		"events": {
+			"StatementId":   "events",
+			"Action":        "lambda:InvokeFunction",
+			"Principal":     "events.amazonaws.com",
+			"Qualifier":     "prod",
+			"SourceArn":     "arn:aws:events:eu-west-1:123456789012:rule/*",
+			"SourceAccount": "123456789012",
+		},
+		"org": {
+			"StatementId":           "org",
+			"Action":                "lambda:InvokeFunction",
+			"Principal":             "*",
+			"PrincipalOrgID":        "o-abc123",
+			"InvokedViaFunctionUrl": true,
+		},
+		// AddPermission can't recreate these, so there are no parameters
+		"two-principals": nil,
+		"ip":             nil,
+		"two-arns":       nil,
+	}
+	for _, statement := range document.Statement {
+		expected := cases[statement.Sid]
+		got, ok := addPermissionParameters(statement)
+		if ok != (expected != nil) || !reflect.DeepEqual(got, expected) {
+			t.Errorf("Error building AddPermission parameters for %s. Expected %v, got %v", statement.Sid, expected, got)
+		}
+	}
+}
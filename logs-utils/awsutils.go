@@ -0,0 +1,199 @@
+package logsutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	logsTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// logGroup is a failing log group, and the retention we would give it.
+type logGroup struct {
+	Name        string
+	StoredBytes int64
+	Days        int32
+	SkipReason  string // Why we won't set a retention, if we won't
+}
+
+// retentionChange is the retention to set on one log group.
+type retentionChange struct {
+	Name string
+	Days int32
+}
+
+func getLogGroupTags(ctx context.Context, logsClient *cloudwatchlogs.Client, arn string) (map[string]string, error) {
+	resp, err := logsClient.ListTagsForResource(ctx, &cloudwatchlogs.ListTagsForResourceInput{ResourceArn: &arn})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags for log group %s: %w", logGroupName(arn), err)
+	}
+	return resp.Tags, nil
+}
+
+func findLogGroupsManagedInCode(ctx context.Context, logsClient *cloudwatchlogs.Client, detectors common.ManagedByDetectors, arns []string, concurrency int) ([]common.ManagedBy, error) {
+	type tagResult struct {
+		resource common.ManagedResource
+		err      error
+	}
+	results := common.ParallelMap(ctx, concurrency, arns, func(ctx context.Context, arn string) tagResult {
+		tags, err := getLogGroupTags(ctx, logsClient, arn)
+		return tagResult{
+			resource: common.ManagedResource{Id: logGroupName(arn), Type: common.ResourceTypeLogGroup, Tags: tags},
+			err:      err,
+		}
+	})
+
+	var resources []common.ManagedResource
+	var errs []error
+	for _, res := range results {
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		resources = append(resources, res.resource)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return detectors.Detect(resources), nil
+}
+
+// failingLogGroups matches failing names against the region's log groups,
+// choosing a retention for each group that never expires.
+func failingLogGroups(names []string, groups []logsTypes.LogGroup, config RetentionConfig) []logGroup {
+	byName := map[string]logsTypes.LogGroup{}
+	for _, group := range groups {
+		byName[aws.ToString(group.LogGroupName)] = group
+	}
+
+	var failing []logGroup
+	for _, name := range names {
+		group, ok := byName[name]
+		switch {
+		case !ok:
+			failing = append(failing, logGroup{Name: name, SkipReason: "no longer exists"})
+		case !neverExpires(group):
+			failing = append(failing, logGroup{Name: name, SkipReason: fmt.Sprintf("already expires after %d days", aws.ToInt32(group.RetentionInDays))})
+		default:
+			failing = append(failing, logGroup{Name: name, StoredBytes: aws.ToInt64(group.StoredBytes), Days: config.Retention(name)})
+		}
+	}
+	return failing
+}
+
+// RegionLogGroups is everything we need to know about a region to decide
+// which log groups to set a retention on. It is gathered concurrently and
+// printed afterwards.
+type RegionLogGroups struct {
+	Region         string
+	FailingGroups  []logGroup
+	GroupsInStacks []common.StackResources
+	ManagedInCode  []common.ManagedBy // Managed by IaC other than CloudFormation
+	StackPatches   []common.StackPatch
+	Err            error
+}
+
+func FindRegionLogGroups(ctx context.Context, logsClient *cloudwatchlogs.Client, cfnClient *cloudformation.Client, opts common.Options, failing common.FailingResources, accountId string, config RetentionConfig) RegionLogGroups {
+	region := failing.Region
+	groups, err := listLogGroups(ctx, logsClient, "")
+	if err != nil {
+		return RegionLogGroups{Region: region, Err: err}
+	}
+
+	var names []string
+	for _, arn := range failing.Arns {
+		names = append(names, logGroupName(arn))
+	}
+	failingGroups := failingLogGroups(names, groups, config)
+
+	var fixable []string
+	arns := map[string]string{}
+	for _, group := range groups {
+		arns[aws.ToString(group.LogGroupName)] = aws.ToString(group.LogGroupArn)
+	}
+	for _, group := range failingGroups {
+		if group.SkipReason == "" {
+			fixable = append(fixable, group.Name)
+		}
+	}
+
+	groupsInStacks, err := opts.StackLookup.FindResourcesInStacks(ctx, cfnClient, accountId, region, common.ResourceTypeLogGroup, fixable, opts.Concurrency)
+	if err != nil {
+		return RegionLogGroups{Region: region, Err: fmt.Errorf("could not determine which log groups are in CloudFormation stacks: %w", err)}
+	}
+
+	var notInStacks []string
+	for _, name := range common.Without(fixable, common.ResourcesInStacks(groupsInStacks)) {
+		notInStacks = append(notInStacks, arns[name])
+	}
+	managedInCode, err := findLogGroupsManagedInCode(ctx, logsClient, opts.Detectors, notInStacks, opts.Concurrency)
+	if err != nil {
+		return RegionLogGroups{Region: region, Err: fmt.Errorf("could not determine which log groups are managed in code: %w", err)}
+	}
+
+	stackPatches := common.ParallelMap(ctx, opts.Concurrency, groupsInStacks, func(ctx context.Context, stack common.StackResources) common.StackPatch {
+		return common.BuildStackPatches(ctx, cfnClient, region, []common.StackResources{stack}, RetentionPatch(stack, config), 1)[0]
+	})
+
+	return RegionLogGroups{
+		Region:         region,
+		FailingGroups:  failingGroups,
+		GroupsInStacks: groupsInStacks,
+		ManagedInCode:  managedInCode,
+		StackPatches:   stackPatches,
+	}
+}
+
+// FindLogGroupsToRetain prints what we found in a region, with how much each
+// group stores, and returns the retention to set on each group.
+func FindLogGroupsToRetain(regionGroups RegionLogGroups) []retentionChange {
+	var skipped []common.Skipped
+	for _, group := range regionGroups.FailingGroups {
+		if group.SkipReason != "" {
+			skipped = append(skipped, common.Skipped{Name: group.Name, Reason: group.SkipReason})
+		}
+	}
+	excluded := common.PrintExcluded("log groups", skipped, regionGroups.GroupsInStacks, regionGroups.ManagedInCode)
+
+	var toRetain []logGroup
+	for _, group := range regionGroups.FailingGroups {
+		if group.SkipReason == "" && !slices.Contains(excluded, group.Name) {
+			toRetain = append(toRetain, group)
+		}
+	}
+
+	var changes []retentionChange
+	if len(toRetain) > 0 {
+		var totalBytes int64
+		fmt.Fprintln(common.Out, "\nSetting the retention of the following log groups:")
+		for idx, group := range toRetain {
+			fmt.Fprintf(common.Out, "%d %s: %d days (%s stored)\n", idx+1, group.Name, group.Days, common.FormatBytes(group.StoredBytes))
+			totalBytes += group.StoredBytes
+			changes = append(changes, retentionChange{Name: group.Name, Days: group.Days})
+		}
+		fmt.Fprintf(common.Out, "These log groups store %s in total. Events older than their new retention will be deleted.\n\n", common.FormatBytes(totalBytes))
+	}
+
+	failingGroupCount := len(regionGroups.FailingGroups)
+	fmt.Fprintln(common.Out, failingGroupCount, "failing log groups found.")
+	fmt.Fprintln(common.Out, len(toRetain), "to set a retention on, and", failingGroupCount-len(toRetain), "to skip.")
+	return changes
+}
+
+func putRetentionPolicy(ctx context.Context, logsClient *cloudwatchlogs.Client, change retentionChange) error {
+	_, err := logsClient.PutRetentionPolicy(ctx, &cloudwatchlogs.PutRetentionPolicyInput{
+		LogGroupName:    &change.Name,
+		RetentionInDays: &change.Days,
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Set log group retention", "logGroup", change.Name, "days", change.Days)
+	return nil
+}
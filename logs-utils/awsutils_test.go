@@ -0,0 +1,58 @@
+package logsutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	logsTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func TestLogGroupName(t *testing.T) {
+	cases := map[string]string{
+		"arn:aws:logs:eu-west-1:123456789012:log-group:/aws/lambda/my-function:*": "/aws/lambda/my-function",
+		"arn:aws:logs:eu-west-1:123456789012:log-group:/aws/lambda/my-function":   "/aws/lambda/my-function",
+		"arn:aws:logs:eu-west-1:123456789012:log-group:a:b":                       "a:b",
+	}
+	for arn, expected := range cases {
+		if got := logGroupName(arn); got != expected {
+			t.Errorf("Error getting log group name from %s. Expected %s, got %s", arn, expected, got)
+		}
+	}
+}
+
+func TestFailingLogGroups(t *testing.T) {
+	groups := []logsTypes.LogGroup{
+		{LogGroupName: aws.String("/aws/lambda/forever"), StoredBytes: aws.Int64(2048)},
+		{LogGroupName: aws.String("/ecs/short"), RetentionInDays: aws.Int32(7)},
+	}
+	config := RetentionConfig{Default: 365, Rules: []RetentionRule{{Pattern: "/aws/lambda/*", Days: 30}}}
+	result := failingLogGroups([]string{"/aws/lambda/forever", "/ecs/short", "/deleted"}, groups, config)
+	expected := []logGroup{
+		{Name: "/aws/lambda/forever", StoredBytes: 2048, Days: 30},
+		{Name: "/ecs/short", SkipReason: "already expires after 7 days"},
+		{Name: "/deleted", SkipReason: "no longer exists"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Error finding failing log groups. Expected %v, got %v", expected, result)
+	}
+}
+
+func TestFindLogGroupsToRetain(t *testing.T) {
+	regionGroups := RegionLogGroups{
+		Region: "eu-west-1",
+		FailingGroups: []logGroup{
+			{Name: "/aws/lambda/fixable", Days: 30},
+			{Name: "/ecs/short", SkipReason: "already expires after 7 days"},
+			{Name: "/in-stack", Days: 365},
+			{Name: "/in-terraform", Days: 365},
+		},
+		GroupsInStacks: []common.StackResources{{StackName: "app", PhysicalIds: []string{"/in-stack"}}},
+		ManagedInCode:  []common.ManagedBy{{Id: "/in-terraform", Reason: "tagged terraform=true"}},
+	}
+	expected := []retentionChange{{Name: "/aws/lambda/fixable", Days: 30}}
+	if got := FindLogGroupsToRetain(regionGroups); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error finding log groups to retain. Expected %v, got %v", expected, got)
+	}
+}
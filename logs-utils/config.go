@@ -0,0 +1,101 @@
+package logsutils
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validRetentionDays are the only retention periods CloudWatch Logs accepts.
+var validRetentionDays = []int32{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653}
+
+// RetentionRule sets the retention of log groups whose names match Pattern,
+// where * matches any run of characters, including /.
+type RetentionRule struct {
+	Pattern string `yaml:"pattern"`
+	Days    int32  `yaml:"days"`
+}
+
+// RetentionConfig chooses a retention period for each log group. The first
+// matching rule wins, and groups matching no rule get the default.
+type RetentionConfig struct {
+	Default int32           `yaml:"default"`
+	Rules   []RetentionRule `yaml:"patterns"`
+}
+
+var DefaultRetentionConfig = RetentionConfig{Default: 365}
+
+func validateDays(days int32) error {
+	if !slices.Contains(validRetentionDays, days) {
+		return fmt.Errorf("%d is not a retention period CloudWatch Logs accepts, which are %v", days, validRetentionDays)
+	}
+	return nil
+}
+
+func (c RetentionConfig) Validate() error {
+	if err := validateDays(c.Default); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for _, rule := range c.Rules {
+		if rule.Pattern == "" {
+			return fmt.Errorf("every pattern needs a non-empty pattern")
+		}
+		if err := validateDays(rule.Days); err != nil {
+			return fmt.Errorf("pattern %s: %w", rule.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// ParseRetentionConfig reads a config in YAML, or JSON, e.g.
+//
+//	default: 365
+//	patterns:
+//	  - pattern: /aws/lambda/*
+//	    days: 30
+func ParseRetentionConfig(data []byte, source string) (RetentionConfig, error) {
+	config := DefaultRetentionConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return RetentionConfig{}, fmt.Errorf("failed to parse retention config %s: %w", source, err)
+	}
+	if err := config.Validate(); err != nil {
+		return RetentionConfig{}, fmt.Errorf("invalid retention config %s: %w", source, err)
+	}
+	return config, nil
+}
+
+// LoadRetentionConfig reads the config at path, or returns the default
+// config if path is empty.
+func LoadRetentionConfig(path string) (RetentionConfig, error) {
+	if path == "" {
+		return DefaultRetentionConfig, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RetentionConfig{}, fmt.Errorf("failed to read retention config: %w", err)
+	}
+	return ParseRetentionConfig(data, path)
+}
+
+func matchPattern(pattern string, name string) bool {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	matched, _ := regexp.MatchString("^"+strings.Join(parts, ".*")+"$", name)
+	return matched
+}
+
+// Retention returns the number of days to keep a log group's events for.
+func (c RetentionConfig) Retention(logGroupName string) int32 {
+	for _, rule := range c.Rules {
+		if matchPattern(rule.Pattern, logGroupName) {
+			return rule.Days
+		}
+	}
+	return c.Default
+}
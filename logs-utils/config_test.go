@@ -0,0 +1,60 @@
+package logsutils
+
+import (
+	"testing"
+)
+
+func TestParseRetentionConfig(t *testing.T) {
+	config, err := ParseRetentionConfig([]byte(`
+default: 731
+patterns:
+  - pattern: /aws/lambda/*
+    days: 30
+  - pattern: "*-CODE"
+    days: 7
+`), "test.yaml")
+	if err != nil {
+		t.Fatalf("Error parsing retention config: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		expected int32
+	}{
+		{"/aws/lambda/my-function", 30},
+		{"/aws/lambda/nested/path", 30},
+		{"/aws/lambda/my-function-CODE", 30}, // First match wins
+		{"/ecs/my-service-CODE", 7},
+		{"/ecs/my-service-PROD", 731},
+		{"/aws/lambda", 731},
+	}
+	for _, c := range cases {
+		if got := config.Retention(c.name); got != c.expected {
+			t.Errorf("Error choosing retention for %s. Expected %d, got %d", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestParseRetentionConfigDefault(t *testing.T) {
+	config, err := ParseRetentionConfig([]byte(`{"patterns": [{"pattern": "/aws/lambda/*", "days": 30}]}`), "test.json")
+	if err != nil {
+		t.Fatalf("Error parsing retention config: %v", err)
+	}
+	if got := config.Retention("/ecs/my-service"); got != 365 {
+		t.Errorf("Error choosing default retention. Expected 365, got %d", got)
+	}
+}
+
+func TestParseRetentionConfigInvalid(t *testing.T) {
+	invalid := []string{
+		`default: 100`,
+		`patterns: [{pattern: /aws/lambda/*, days: 31}]`,
+		`patterns: [{days: 30}]`,
+		`default: [`,
+	}
+	for _, body := range invalid {
+		if _, err := ParseRetentionConfig([]byte(body), "test.yaml"); err == nil {
+			t.Errorf("Expected an error parsing retention config %q", body)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package logsutils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	logsTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// logGroupName turns the ARN of a failing log group into its name. Log group
+// ARNs may or may not end in :*.
+func logGroupName(arn string) string {
+	if i := strings.Index(arn, ":log-group:"); i >= 0 {
+		arn = arn[i+len(":log-group:"):]
+	}
+	return strings.TrimSuffix(arn, ":*")
+}
+
+// neverExpires reports whether a log group keeps its events forever.
+func neverExpires(group logsTypes.LogGroup) bool {
+	return group.RetentionInDays == nil
+}
+
+func listLogGroups(ctx context.Context, logsClient *cloudwatchlogs.Client, prefix string) ([]logsTypes.LogGroup, error) {
+	input := &cloudwatchlogs.DescribeLogGroupsInput{}
+	if prefix != "" {
+		input.LogGroupNamePrefix = &prefix
+	}
+	var groups []logsTypes.LogGroup
+	paginator := cloudwatchlogs.NewDescribeLogGroupsPaginator(logsClient, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list log groups: %w", err)
+		}
+		groups = append(groups, page.LogGroups...)
+	}
+	return groups, nil
+}
+
+// getLogGroup finds a log group by name, returning nil if it doesn't exist.
+func getLogGroup(ctx context.Context, logsClient *cloudwatchlogs.Client, name string) (*logsTypes.LogGroup, error) {
+	groups, err := listLogGroups(ctx, logsClient, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		if aws.ToString(group.LogGroupName) == name {
+			return &group, nil
+		}
+	}
+	return nil, nil
+}
+
+// EvaluateCloudWatch_16 finds the log groups in a region which never expire,
+// without relying on Security Hub.
+func EvaluateCloudWatch_16(sess *common.Session) common.Evaluator {
+	return func(ctx context.Context, region string) ([]string, error) {
+		groups, err := listLogGroups(ctx, sess.CloudWatchLogs(region), "")
+		if err != nil {
+			return nil, err
+		}
+		var failing []string
+		for _, group := range groups {
+			if neverExpires(group) {
+				failing = append(failing, aws.ToString(group.LogGroupArn))
+			}
+		}
+		return failing, nil
+	}
+}
@@ -0,0 +1,20 @@
+package logsutils
+
+import (
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// RetentionPatch proposes the template changes that set the retention of a
+// stack's failing log groups. Each group's retention depends on its name, so
+// the builder is specific to one stack.
+func RetentionPatch(stack common.StackResources, config RetentionConfig) common.PatchBuilder {
+	names := map[string]string{}
+	for physicalId, logicalId := range stack.LogicalIds {
+		names[logicalId] = physicalId
+	}
+
+	return func(template common.StackTemplate, logicalId string) []common.PatchOp {
+		days := int(config.Retention(names[logicalId]))
+		return common.PropertyPatch("RetentionInDays", days)(template, logicalId)
+	}
+}
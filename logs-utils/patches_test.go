@@ -0,0 +1,38 @@
+package logsutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func TestRetentionPatch(t *testing.T) {
+	template, err := common.ParseTemplate(`
+Resources:
+  LambdaLogs:
+    Type: AWS::Logs::LogGroup
+  ServiceLogs:
+    Type: AWS::Logs::LogGroup
+    Properties:
+      LogGroupName: /ecs/my-service
+`)
+	if err != nil {
+		t.Fatalf("Error parsing template: %v", err)
+	}
+	stack := common.StackResources{
+		StackName:   "app",
+		PhysicalIds: []string{"/aws/lambda/my-function", "/ecs/my-service"},
+		LogicalIds:  map[string]string{"/aws/lambda/my-function": "LambdaLogs", "/ecs/my-service": "ServiceLogs"},
+	}
+	build := RetentionPatch(stack, RetentionConfig{Default: 365, Rules: []RetentionRule{{Pattern: "/aws/lambda/*", Days: 30}}})
+
+	expected := []common.PatchOp{{Op: "add", Path: "/Resources/LambdaLogs/Properties", Value: map[string]any{"RetentionInDays": 30}}}
+	if got := build(template, "LambdaLogs"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error patching log group without properties. Expected %v, got %v", expected, got)
+	}
+	expected = []common.PatchOp{{Op: "add", Path: "/Resources/ServiceLogs/Properties/RetentionInDays", Value: 365}}
+	if got := build(template, "ServiceLogs"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error patching log group with properties. Expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,37 @@
+package logsutils
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// FixCloudWatch_16 sets a retention on log groups that never expire, in every
+// region, choosing each group's retention from config by its name.
+func FixCloudWatch_16(ctx context.Context, sess *common.Session, opts common.Options, config RetentionConfig) (common.Result, error) {
+	return common.Remediate(ctx, sess, opts, common.Remediation[RegionLogGroups, retentionChange]{
+		ControlId: "CloudWatch.16",
+		Noun:      "log groups",
+		Action:    "set retention periods",
+		Evaluate:  EvaluateCloudWatch_16(sess),
+		Find: func(ctx context.Context, f common.FailingResources) (RegionLogGroups, error) {
+			regionGroups := FindRegionLogGroups(ctx, sess.CloudWatchLogs(f.Region), sess.CloudFormation(f.Region), opts, f, sess.AccountId, config)
+			return regionGroups, regionGroups.Err
+		},
+		Plan: func(regionGroups RegionLogGroups) ([]retentionChange, []common.StackPatch) {
+			return FindLogGroupsToRetain(regionGroups), regionGroups.StackPatches
+		},
+		Apply: func(ctx context.Context, change common.Change[retentionChange]) error {
+			return putRetentionPolicy(ctx, sess.CloudWatchLogs(change.Region), change.Item)
+		},
+		Audit: func(change retentionChange) (string, string, map[string]any) {
+			return "logs:PutRetentionPolicy", change.Name, map[string]any{"RetentionInDays": change.Days}
+		},
+		Verify: func(ctx context.Context, change common.Change[retentionChange]) (bool, error) {
+			group, err := getLogGroup(ctx, sess.CloudWatchLogs(change.Region), change.Item.Name)
+			return group != nil && aws.ToInt32(group.RetentionInDays) == change.Item.Days, err
+		},
+		Id: func(change retentionChange) string { return change.Name },
+	})
+}
@@ -4,46 +4,255 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
-	"sync"
+	"time"
 
 	bucketutils "github.com/guardian/fsbp-tools/fsbp-fix/bucket-utils"
 	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	dynamodbutils "github.com/guardian/fsbp-tools/fsbp-fix/dynamodb-utils"
+	ecrutils "github.com/guardian/fsbp-tools/fsbp-fix/ecr-utils"
+	kmsutils "github.com/guardian/fsbp-tools/fsbp-fix/kms-utils"
+	lambdautils "github.com/guardian/fsbp-tools/fsbp-fix/lambda-utils"
+	logsutils "github.com/guardian/fsbp-tools/fsbp-fix/logs-utils"
+	snsutils "github.com/guardian/fsbp-tools/fsbp-fix/sns-utils"
+	sqsutils "github.com/guardian/fsbp-tools/fsbp-fix/sqs-utils"
+	ssmutils "github.com/guardian/fsbp-tools/fsbp-fix/ssm-utils"
 	vpcutils "github.com/guardian/fsbp-tools/fsbp-fix/vpc-utils"
 )
 
+// sharedFlags are the flags every control accepts.
+type sharedFlags struct {
+	execute        *bool
+	profile        *string
+	region         *string
+	concurrency    *int
+	limit          *int
+	source         *string
+	cacheTtl       *time.Duration
+	stackLookup    *string
+	managedTags    *string
+	terraformState *string
+	patchDir       *string
+	yes            *bool
+	confirmAccount *string
+	maxChanges     *int
+	maxPerRegion   *int
+	canary         *int
+	canaryWait     *time.Duration
+	alarmPrefix    *string
+	logFormat      *string
+	verbose        *bool
+	quiet          *bool
+	auditLog       *string
+	auditS3        *string
+	retryMode      *string
+	maxAttempts    *int
+	retryTokens    *uint
+}
+
+// registerSharedFlags adds the flags every control takes. Resources are what a
+// control finds failing, and changes are what its change limits and canary
+// count, which are not always the same thing.
+func registerSharedFlags(fs *flag.FlagSet, resources string, changes string) sharedFlags {
+	return sharedFlags{
+		execute:        fs.Bool("execute", false, "Execute the fix"),
+		profile:        fs.String("profile", "", "AWS profile to use"),
+		region:         fs.String("region", "", "The region to run in. Defaults to all enabled regions"),
+		concurrency:    fs.Int("concurrency", 8, "The number of regions to process at once, and of stacks or resources within each region. Applies at each level, so up to its square of AWS calls can be in flight"),
+		limit:          fs.Int("limit", 0, "The maximum number of failing "+resources+" to process, across all regions. 0 means no limit"),
+		source:         fs.String("source", common.SourceSecurityHub, "Where to find failing "+resources+": securityhub, direct or both"),
+		cacheTtl:       fs.Duration("cache-ttl", time.Hour, "How long to reuse cached CloudFormation stack resources for. 0 disables the cache"),
+		stackLookup:    fs.String("stack-lookup", common.StackLookupAuto, "How to find stack-managed "+resources+": auto, scan or physical-id"),
+		managedTags:    fs.String("managed-tags", "", "Comma-separated list of extra tag keys marking "+resources+" as managed in code"),
+		terraformState: fs.String("terraform-state", "", "Comma-separated list of local Terraform state files whose "+resources+" should be fixed in code"),
+		patchDir:       fs.String("patch-dir", "", "Directory to write proposed CloudFormation template patches to"),
+		yes:            fs.Bool("yes", false, "Don't ask for confirmation before executing"),
+		confirmAccount: fs.String("confirm-account", "", "Execute without asking for confirmation, but only in this account ID"),
+		maxChanges:     fs.Int("max-changes", 0, "The maximum number of "+changes+" to change in this run. 0 means no limit"),
+		maxPerRegion:   fs.Int("max-changes-per-region", 0, "The maximum number of "+changes+" to change in each region. 0 means no limit"),
+		canary:         fs.Int("canary", 0, "Change this many "+changes+" first, then check health before changing the rest. 0 disables the canary"),
+		canaryWait:     fs.Duration("canary-wait", 10*time.Minute, "How long to wait after the canary before checking health"),
+		alarmPrefix:    fs.String("canary-alarm-prefix", "", "Only treat CloudWatch alarms with this name prefix as canary health checks"),
+		logFormat:      fs.String("log-format", common.LogFormatText, "The format of log messages: text or json"),
+		verbose:        fs.Bool("v", false, "Log debug messages"),
+		quiet:          fs.Bool("quiet", false, "Only log warnings and errors"),
+		auditLog:       fs.String("audit-log", common.DefaultAuditLogPath(), "File to append a record of every change to"),
+		auditS3:        fs.String("audit-s3", "", "S3 URI, e.g. s3://bucket/prefix, to upload each run's audit records to"),
+		retryMode:      fs.String("retry-mode", common.DefaultRetryPolicy.Mode, "How to retry failed AWS calls: standard or adaptive"),
+		maxAttempts:    fs.Int("max-attempts", common.DefaultRetryPolicy.MaxAttempts, "The maximum number of attempts at each AWS call"),
+		retryTokens:    fs.Uint("retry-tokens", common.DefaultRetryPolicy.Tokens, "The size of each client's retry token bucket, which limits retries when a service is struggling. At least 5, the cost of one retry"),
+	}
+}
+
+func (f sharedFlags) options() common.Options {
+	if *f.verbose && *f.quiet {
+		fatal("Please provide at most one of -v and -quiet")
+	}
+	logger, err := common.NewLogger(os.Stderr, *f.logFormat, *f.verbose, *f.quiet)
+	if err != nil {
+		exitOnError(err, "Invalid flags")
+	}
+	slog.SetDefault(logger)
+
+	if *f.profile == "" {
+		fatal("Please provide a named AWS profile")
+	}
+
+	if *f.concurrency < 1 {
+		fatal("Please provide a concurrency of at least 1")
+	}
+
+	if *f.limit < 0 {
+		fatal("Please provide a limit of at least 0")
+	}
+
+	if *f.maxChanges < 0 || *f.maxPerRegion < 0 || *f.canary < 0 {
+		fatal("Please provide change limits and a canary size of at least 0")
+	}
+
+	if err := common.ValidateSource(*f.source); err != nil {
+		exitOnError(err, "Invalid flags")
+	}
+
+	retryPolicy := common.RetryPolicy{
+		Mode:        *f.retryMode,
+		MaxAttempts: *f.maxAttempts,
+		MaxBackoff:  common.DefaultRetryPolicy.MaxBackoff,
+		Tokens:      *f.retryTokens,
+	}
+	exitOnError(retryPolicy.Validate(), "Invalid flags")
+
+	if err := common.ValidateStackLookupStrategy(*f.stackLookup); err != nil {
+		exitOnError(err, "Invalid flags")
+	}
+
+	if err := common.ValidateAuditS3Uri(*f.auditS3); err != nil {
+		exitOnError(err, "Invalid flags")
+	}
+
+	confirm := common.Confirmation{
+		Yes:            *f.yes,
+		ConfirmAccount: *f.confirmAccount,
+	}
+	if *f.execute {
+		exitOnError(confirm.CheckInteractive(), "Cannot execute")
+	}
+
+	detectors, err := common.NewManagedByDetectors(splitList(*f.managedTags), splitList(*f.terraformState))
+	exitOnError(err, "Failed to load managed-by detectors")
+
+	return common.Options{
+		Execute:     *f.execute,
+		Concurrency: *f.concurrency,
+		Limit:       *f.limit,
+		Source:      *f.source,
+		StackLookup: common.StackLookup{
+			Strategy: *f.stackLookup,
+			Cache:    common.StackCache{Dir: common.DefaultStackCacheDir(), TTL: *f.cacheTtl},
+		},
+		Detectors: detectors,
+		PatchDir:  *f.patchDir,
+		Confirm:   confirm,
+		Limits: common.Limits{
+			MaxChanges:          *f.maxChanges,
+			MaxChangesPerRegion: *f.maxPerRegion,
+		},
+		Canary:      common.Canary{Size: *f.canary, Wait: *f.canaryWait},
+		AlarmPrefix: *f.alarmPrefix,
+		Retry:       retryPolicy,
+	}
+}
+
+// newSession authenticates, and fails fast if it is the wrong account. When
+// executing, it also opens the audit log.
+func newSession(ctx context.Context, f sharedFlags, opts *common.Options) *common.Session {
+	sess, err := common.NewSession(ctx, *f.profile, *f.region, opts.Retry)
+	exitOnError(err, "Failed to get account details")
+	exitOnError(opts.Confirm.CheckAccount(sess.AccountId), "Refusing to run")
+	if opts.Execute {
+		opts.Audit, err = common.OpenAuditLog(*f.auditLog, sess)
+		exitOnError(err, "Failed to open audit log")
+	}
+	return sess
+}
+
+// closeAudit finishes the audit log, uploading this run's records if asked.
+func closeAudit(ctx context.Context, f sharedFlags, opts common.Options, sess *common.Session) {
+	if *f.auditS3 != "" {
+		common.WarnOnError(opts.Audit.Upload(ctx, sess, *f.auditS3), "Failed to upload audit log")
+	}
+	common.WarnOnError(opts.Audit.Close(), "Failed to close audit log")
+}
+
+// fatal stops a run that cannot start.
+func fatal(msg string) {
+	slog.Error(msg)
+	os.Exit(common.ExitError)
+}
+
+// exitOnError stops a run that cannot start.
+func exitOnError(err error, msg string) {
+	if err != nil {
+		slog.Error(msg, "error", err)
+		os.Exit(common.ExitError)
+	}
+}
+
+// exit ends the run with an exit code describing its outcome.
+func exit(result common.Result, err error) {
+	if err != nil {
+		slog.Error("Run did not complete", "error", err)
+	}
+	if len(result.Failures) > 0 {
+		slog.Error("Some regions or changes failed", "failures", len(result.Failures))
+		result.PrintFailures()
+	}
+	os.Exit(common.ExitCode(result, err))
+}
+
 func main() {
 
 	ctx := context.Background()
 	fixS3_8 := flag.NewFlagSet("s3.8", flag.ExitOnError)
 	fixEc2_2 := flag.NewFlagSet("ec2.2", flag.ExitOnError)
+	fixKms_4 := flag.NewFlagSet("kms.4", flag.ExitOnError)
+	fixCloudWatch_16 := flag.NewFlagSet("cloudwatch.16", flag.ExitOnError)
+	fixSsm_1 := flag.NewFlagSet("ssm.1", flag.ExitOnError)
+	fixDynamoDB_2 := flag.NewFlagSet("dynamodb.2", flag.ExitOnError)
+	fixLambda_1 := flag.NewFlagSet("lambda.1", flag.ExitOnError)
+	fixSns_1 := flag.NewFlagSet("sns.1", flag.ExitOnError)
+	fixSns_4 := flag.NewFlagSet("sns.4", flag.ExitOnError)
+	fixSqs_1 := flag.NewFlagSet("sqs.1", flag.ExitOnError)
+	fixSqs_3 := flag.NewFlagSet("sqs.3", flag.ExitOnError)
+	fixEcr_1 := flag.NewFlagSet("ecr.1", flag.ExitOnError)
+	fixEcr_2 := flag.NewFlagSet("ecr.2", flag.ExitOnError)
+	fixEcr_3 := flag.NewFlagSet("ecr.3", flag.ExitOnError)
 
 	if len(os.Args) < 2 {
 		fmt.Println(len(os.Args))
 		fmt.Println(os.Args)
-		fmt.Println("expected 's3.8' or 'ec2.2' subcommands")
-		os.Exit(1)
+		fmt.Println("expected 's3.8', 'ec2.2', 'kms.4', 'cloudwatch.16', 'ssm.1', 'dynamodb.2', 'lambda.1', 'sns.1', 'sns.4', 'sqs.1', 'sqs.3', 'ecr.1', 'ecr.2' or 'ecr.3' subcommands")
+		os.Exit(common.ExitError)
 	}
 
 	switch strings.ToLower(os.Args[1]) {
 	case "s3.8":
 
-		execute := fixS3_8.Bool("execute", false, "Execute the block operation")
-		profile := fixS3_8.String("profile", "", "AWS profile to use")
-		region := fixS3_8.String("region", "", "The region of the bucket")
-		bucketCount := fixS3_8.Int("max", 100, "The maximum number of buckets to attempt to process")
+		shared := registerSharedFlags(fixS3_8, "buckets", "buckets")
+		bucketCount := fixS3_8.Int("max", 0, "Deprecated. Use -limit")
 		exclusions := fixS3_8.String("exclusions", "", "Comma-separated list of buckets to skip")
 
 		fixS3_8.Parse(os.Args[2:])
 
-		if *profile == "" {
-			log.Fatal("Please provide a named AWS profile")
-		}
+		opts := shared.options()
 
-		if *bucketCount < 1 || *bucketCount > 100 {
-			log.Fatal("Please provide a max between 1 and 100")
+		if *bucketCount != 0 {
+			slog.Warn("-max is deprecated. Please use -limit instead")
+			if opts.Limit == 0 {
+				opts.Limit = *bucketCount
+			}
 		}
 
 		var exclusionsSlice []string
@@ -51,47 +260,200 @@ func main() {
 		if *exclusions == "" {
 			exclusionsSlice = []string{}
 		} else {
-			fmt.Printf("Parsing exclusions")
+			slog.Debug("Parsing exclusions", "exclusions", *exclusions)
 			exclusionsSlice = bucketutils.SplitAndTrim(*exclusions)
 		}
 
-		accountDetails, err := common.GetAccountDetails(ctx, *profile, *region)
-		if err != nil {
-			log.Fatalf("Error getting account details: %v", err)
-		}
-
-		for i, r := range accountDetails.Regions {
-			fmt.Printf("Region %d: %s\n", i+1, r)
-			bucketutils.FixS3_8(ctx, *profile, r, *bucketCount, exclusionsSlice, *execute)
-			fmt.Printf("----------------------------------------------------\n\n")
-		}
+		sess := newSession(ctx, shared, &opts)
+		result, err := bucketutils.FixS3_8(ctx, sess, opts, exclusionsSlice)
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
 
 	case "ec2.2":
-		execute := fixEc2_2.Bool("execute", false, "Execute the block operation")
-		profile := fixEc2_2.String("profile", "", "AWS profile to use")
-		region := fixEc2_2.String("region", "", "The region of the bucket")
+		shared := registerSharedFlags(fixEc2_2, "security groups", "security group rules")
+		output := fixEc2_2.String("output", vpcutils.OutputTable, "How to print the rules to delete: table or json")
 
 		fixEc2_2.Parse(os.Args[2:])
 
-		if *profile == "" {
-			log.Fatal("Please provide a named AWS profile")
+		opts := shared.options()
+		exitOnError(vpcutils.ValidateOutput(*output), "Invalid flags")
+		if *output == vpcutils.OutputJson {
+			// Keep stdout for the JSON, so it can be piped straight into another tool
+			common.Out = os.Stderr
 		}
 
-		accountDetails, err := common.GetAccountDetails(ctx, *profile, *region)
-		common.ExitOnError(err, "Failed to get account details")
+		sess := newSession(ctx, shared, &opts)
+		unusedSgRules, skipped, findErr := vpcutils.FindUnusedSgRules(ctx, sess, opts)
+		result, err := vpcutils.FixEc2_2(ctx, sess, opts, unusedSgRules, skipped, *output)
+		result.Fail(findErr)
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
 
-		ch := make(chan vpcutils.SecurityGroupRuleDetails)
-		wg := sync.WaitGroup{}
+	case "kms.4":
+		shared := registerSharedFlags(fixKms_4, "keys", "keys")
+		rotationPeriod := fixKms_4.Int("rotation-period", 0, "Days between automatic rotations, from 90 to 2560. 0 uses the KMS default of 365")
 
-		vpcutils.FindUnusedSgRules(ctx, accountDetails, ch, &wg, *profile)
-		go func() {
-			wg.Wait()
-			close(ch)
-		}()
-		vpcutils.FixEc2_2(ctx, ch, execute, profile)
+		fixKms_4.Parse(os.Args[2:])
+
+		opts := shared.options()
+		exitOnError(kmsutils.ValidateRotationPeriod(*rotationPeriod), "Invalid flags")
+
+		sess := newSession(ctx, shared, &opts)
+		result, err := kmsutils.FixKMS_4(ctx, sess, opts, int32(*rotationPeriod))
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
+
+	case "cloudwatch.16":
+		shared := registerSharedFlags(fixCloudWatch_16, "log groups", "log groups")
+		retentionConfig := fixCloudWatch_16.String("retention-config", "", "YAML or JSON file choosing each log group's retention by name. Defaults to 365 days for every group")
+
+		fixCloudWatch_16.Parse(os.Args[2:])
+
+		opts := shared.options()
+		config, err := logsutils.LoadRetentionConfig(*retentionConfig)
+		exitOnError(err, "Invalid flags")
+
+		sess := newSession(ctx, shared, &opts)
+		result, err := logsutils.FixCloudWatch_16(ctx, sess, opts, config)
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
+
+	case "ssm.1":
+		shared := registerSharedFlags(fixSsm_1, "instances", "instance profiles and roles")
+		instanceProfile := fixSsm_1.String("instance-profile", "", "Instance profile to attach to instances without one. Its role must have AmazonSSMManagedInstanceCore")
+
+		fixSsm_1.Parse(os.Args[2:])
+
+		opts := shared.options()
+
+		sess := newSession(ctx, shared, &opts)
+		result, err := ssmutils.FixSSM_1(ctx, sess, opts, *instanceProfile)
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
+
+	case "dynamodb.2":
+		shared := registerSharedFlags(fixDynamoDB_2, "tables", "tables")
+
+		fixDynamoDB_2.Parse(os.Args[2:])
+
+		opts := shared.options()
+
+		sess := newSession(ctx, shared, &opts)
+		result, err := dynamodbutils.FixDynamoDB_2(ctx, sess, opts)
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
+
+	case "lambda.1":
+		shared := registerSharedFlags(fixLambda_1, "functions", "function policy statements")
+
+		fixLambda_1.Parse(os.Args[2:])
+
+		opts := shared.options()
+
+		sess := newSession(ctx, shared, &opts)
+		result, err := lambdautils.FixLambda_1(ctx, sess, opts)
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
+
+	case "sns.1":
+		shared := registerSharedFlags(fixSns_1, "topics", "topics")
+		kmsKey := fixSns_1.String("kms-key", snsutils.DefaultKmsKey, "KMS key ID, ARN or alias to encrypt topics with")
+
+		fixSns_1.Parse(os.Args[2:])
+
+		opts := shared.options()
+
+		sess := newSession(ctx, shared, &opts)
+		result, err := snsutils.FixSNS_1(ctx, sess, opts, *kmsKey)
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
+
+	case "sns.4":
+		shared := registerSharedFlags(fixSns_4, "topics", "topics")
+
+		fixSns_4.Parse(os.Args[2:])
+
+		opts := shared.options()
+
+		sess := newSession(ctx, shared, &opts)
+		result, err := snsutils.FixSNS_4(ctx, sess, opts)
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
+
+	case "sqs.1":
+		shared := registerSharedFlags(fixSqs_1, "queues", "queues")
+		kmsKey := fixSqs_1.String("kms-key", sqsutils.DefaultKmsKey, "KMS key ID, ARN or alias to encrypt queues with")
+
+		fixSqs_1.Parse(os.Args[2:])
+
+		opts := shared.options()
+
+		sess := newSession(ctx, shared, &opts)
+		result, err := sqsutils.FixSQS_1(ctx, sess, opts, *kmsKey)
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
+
+	case "sqs.3":
+		shared := registerSharedFlags(fixSqs_3, "queues", "queues")
+
+		fixSqs_3.Parse(os.Args[2:])
+
+		opts := shared.options()
+
+		sess := newSession(ctx, shared, &opts)
+		result, err := sqsutils.FixSQS_3(ctx, sess, opts)
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
+
+	case "ecr.1":
+		shared := registerSharedFlags(fixEcr_1, "repositories", "repositories")
+
+		fixEcr_1.Parse(os.Args[2:])
+
+		opts := shared.options()
+
+		sess := newSession(ctx, shared, &opts)
+		result, err := ecrutils.FixECR_1(ctx, sess, opts)
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
+
+	case "ecr.2":
+		shared := registerSharedFlags(fixEcr_2, "repositories", "repositories")
+
+		fixEcr_2.Parse(os.Args[2:])
+
+		opts := shared.options()
+
+		sess := newSession(ctx, shared, &opts)
+		result, err := ecrutils.FixECR_2(ctx, sess, opts)
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
+
+	case "ecr.3":
+		shared := registerSharedFlags(fixEcr_3, "repositories", "repositories")
+		keepTagged := fixEcr_3.Int("keep-tagged", ecrutils.DefaultLifecyclePolicy.KeepTagged, "The number of most recently pushed tagged images to keep in each repository")
+		expireUntaggedDays := fixEcr_3.Int("expire-untagged-days", ecrutils.DefaultLifecyclePolicy.ExpireUntaggedDays, "Days after they are pushed to expire untagged images")
+
+		fixEcr_3.Parse(os.Args[2:])
+
+		opts := shared.options()
+		lifecycle := ecrutils.LifecyclePolicy{KeepTagged: *keepTagged, ExpireUntaggedDays: *expireUntaggedDays}
+		exitOnError(lifecycle.Validate(), "Invalid flags")
+
+		sess := newSession(ctx, shared, &opts)
+		result, err := ecrutils.FixECR_3(ctx, sess, opts, lifecycle)
+		closeAudit(ctx, shared, opts, sess)
+		exit(result, err)
 
 	default:
-		fmt.Println("expected 's3.8' or 'ec2.2' subcommands")
-		os.Exit(1)
+		fmt.Println("expected 's3.8', 'ec2.2', 'kms.4', 'cloudwatch.16', 'ssm.1', 'dynamodb.2', 'lambda.1', 'sns.1', 'sns.4', 'sqs.1', 'sqs.3', 'ecr.1', 'ecr.2' or 'ecr.3' subcommands")
+		os.Exit(common.ExitError)
+	}
+}
+
+func splitList(str string) []string {
+	if str == "" {
+		return []string{}
 	}
+	return bucketutils.SplitAndTrim(str)
 }
@@ -0,0 +1,36 @@
+package snsutils
+
+import (
+	"context"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/policy"
+)
+
+// FixSNS_4 removes the statements that let anyone use failing topics from
+// their access policies, in every region. Statements restricted by a
+// condition, e.g. on aws:SourceOwner, are kept. Each original policy is
+// recorded in the audit log, so it can be restored.
+func FixSNS_4(ctx context.Context, sess *common.Session, opts common.Options) (common.Result, error) {
+	return common.Remediate(ctx, sess, opts, common.Remediation[RegionTopics, *policy.Change]{
+		ControlId: "SNS.4",
+		Noun:      "topics",
+		Changes:   "topic policies",
+		Action:    "remove public statements",
+		Evaluate:  EvaluateSNS_4(sess, opts.Concurrency),
+		Find:      findRegionTopics(sess, opts, AccessPolicyPatch),
+		Plan: func(regionTopics RegionTopics) ([]*policy.Change, []common.StackPatch) {
+			return FindStatementsToRemove(regionTopics), regionTopics.StackPatches
+		},
+		Apply: func(ctx context.Context, change common.Change[*policy.Change]) error {
+			return removePublicStatements(ctx, sess.SNS(change.Region), change.Item)
+		},
+		Audit: func(change *policy.Change) (string, string, map[string]any) {
+			return "sns:SetTopicAttributes", change.Id, change.Parameters()
+		},
+		Verify: func(ctx context.Context, change common.Change[*policy.Change]) (bool, error) {
+			return topicPrivate(ctx, sess.SNS(change.Region), change.Item.Id)
+		},
+		Id: func(change *policy.Change) string { return change.Name },
+	})
+}
@@ -0,0 +1,242 @@
+package snsutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snsTypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/policy"
+)
+
+// DefaultKmsKey is the AWS managed key for SNS.
+const DefaultKmsKey = "alias/aws/sns"
+
+type topic struct {
+	Arn            string
+	KmsMasterKeyId string
+	Policy         policy.Document
+	Tags           map[string]string
+	SkipReason     string
+}
+
+func getTopicTags(ctx context.Context, snsClient *sns.Client, arn string) (map[string]string, error) {
+	resp, err := snsClient.ListTagsForResource(ctx, &sns.ListTagsForResourceInput{ResourceArn: &arn})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags for topic %s: %w", topicName(arn), err)
+	}
+	tags := map[string]string{}
+	for _, tag := range resp.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
+func getTopic(ctx context.Context, snsClient *sns.Client, arn string) (topic, error) {
+	resp, err := snsClient.GetTopicAttributes(ctx, &sns.GetTopicAttributesInput{TopicArn: &arn})
+	var notFound *snsTypes.NotFoundException
+	if errors.As(err, &notFound) {
+		return topic{Arn: arn, SkipReason: "topic no longer exists"}, nil
+	}
+	if err != nil {
+		return topic{}, fmt.Errorf("failed to get attributes of topic %s: %w", topicName(arn), err)
+	}
+
+	t := topic{Arn: arn, KmsMasterKeyId: resp.Attributes["KmsMasterKeyId"]}
+	if document := resp.Attributes["Policy"]; document != "" {
+		t.Policy, err = policy.Parse(document)
+		if err != nil {
+			return topic{}, fmt.Errorf("failed to read policy of topic %s: %w", topicName(arn), err)
+		}
+	}
+	return t, nil
+}
+
+// RegionTopics is everything we need to know about a region to decide which
+// topics to fix. It is gathered concurrently and printed afterwards.
+type RegionTopics struct {
+	Region         string
+	FailingTopics  []topic
+	TopicsInStacks []common.StackResources
+	ManagedInCode  []common.ManagedBy // Managed by IaC other than CloudFormation
+	StackPatches   []common.StackPatch
+	Err            error
+}
+
+// FindRegionTopics gathers the failing topics in a region, and proposes
+// patches for those in stacks with build.
+func FindRegionTopics(ctx context.Context, snsClient *sns.Client, cfnClient *cloudformation.Client, opts common.Options, failing common.FailingResources, accountId string, build common.PatchBuilder) RegionTopics {
+	region := failing.Region
+	type topicResult struct {
+		topic topic
+		err   error
+	}
+	results := common.ParallelMap(ctx, opts.Concurrency, failing.Arns, func(ctx context.Context, arn string) topicResult {
+		t, err := getTopic(ctx, snsClient, arn)
+		if err == nil && t.SkipReason == "" {
+			t.Tags, err = getTopicTags(ctx, snsClient, arn)
+		}
+		return topicResult{topic: t, err: err}
+	})
+	var failingTopics []topic
+	var existing []string
+	var errs []error
+	for _, res := range results {
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		failingTopics = append(failingTopics, res.topic)
+		if res.topic.SkipReason == "" {
+			existing = append(existing, res.topic.Arn)
+		}
+	}
+	if len(errs) > 0 {
+		return RegionTopics{Region: region, Err: fmt.Errorf("could not read failing topics: %w", errors.Join(errs...))}
+	}
+
+	topicsInStacks, err := opts.StackLookup.FindResourcesInStacks(ctx, cfnClient, accountId, region, common.ResourceTypeSnsTopic, existing, opts.Concurrency)
+	if err != nil {
+		return RegionTopics{Region: region, Err: fmt.Errorf("could not determine which topics are in CloudFormation stacks: %w", err)}
+	}
+
+	notInStacks := common.Without(existing, common.ResourcesInStacks(topicsInStacks))
+	var resources []common.ManagedResource
+	for _, t := range failingTopics {
+		if slices.Contains(notInStacks, t.Arn) {
+			resources = append(resources, common.ManagedResource{Id: t.Arn, Type: common.ResourceTypeSnsTopic, Tags: t.Tags})
+		}
+	}
+
+	return RegionTopics{
+		Region:         region,
+		FailingTopics:  failingTopics,
+		TopicsInStacks: topicsInStacks,
+		ManagedInCode:  opts.Detectors.Detect(resources),
+		StackPatches:   common.BuildStackPatches(ctx, cfnClient, region, topicsInStacks, build, opts.Concurrency),
+	}
+}
+
+// findRegionTopics looks up a region's failing topics for SNS.1 or SNS.4.
+func findRegionTopics(sess *common.Session, opts common.Options, build common.PatchBuilder) func(context.Context, common.FailingResources) (RegionTopics, error) {
+	return func(ctx context.Context, f common.FailingResources) (RegionTopics, error) {
+		regionTopics := FindRegionTopics(ctx, sess.SNS(f.Region), sess.CloudFormation(f.Region), opts, f, sess.AccountId, build)
+		return regionTopics, regionTopics.Err
+	}
+}
+
+// printExcluded prints the topics to be fixed elsewhere, and returns them.
+func printExcluded(regionTopics RegionTopics, skipped map[string]string) []string {
+	var skips []common.Skipped
+	for _, t := range regionTopics.FailingTopics {
+		if reason, ok := skipped[t.Arn]; ok {
+			skips = append(skips, common.Skipped{Name: topicName(t.Arn), Reason: reason})
+		}
+	}
+	return common.PrintExcluded("topics", skips, regionTopics.TopicsInStacks, regionTopics.ManagedInCode)
+}
+
+// encryptionSkipReason explains why a topic shouldn't be encrypted with
+// kmsKey, or is empty. Services publishing to a topic need to use its key,
+// which the key policy of an AWS managed key doesn't let them do.
+func encryptionSkipReason(t topic, kmsKey string) string {
+	if t.SkipReason != "" {
+		return t.SkipReason
+	}
+	if t.KmsMasterKeyId != "" {
+		return "already encrypted"
+	}
+	if services := t.Policy.Services(); len(services) > 0 && common.IsAwsManagedKey(kmsKey) {
+		return fmt.Sprintf("its policy lets %s publish, which can't use the AWS managed key. Use -kms-key with a key they can use", strings.Join(services, ", "))
+	}
+	return ""
+}
+
+// FindTopicsToEncrypt prints what we found in a region, and returns the
+// topics to encrypt with kmsKey.
+func FindTopicsToEncrypt(regionTopics RegionTopics, kmsKey string) []string {
+	skipped := map[string]string{}
+	for _, t := range regionTopics.FailingTopics {
+		if reason := encryptionSkipReason(t, kmsKey); reason != "" {
+			skipped[t.Arn] = reason
+		}
+	}
+	excluded := printExcluded(regionTopics, skipped)
+
+	var toEncrypt []string
+	for _, t := range regionTopics.FailingTopics {
+		if _, ok := skipped[t.Arn]; !ok && !slices.Contains(excluded, t.Arn) {
+			toEncrypt = append(toEncrypt, t.Arn)
+		}
+	}
+
+	if len(toEncrypt) > 0 {
+		fmt.Fprintf(common.Out, "\nEncrypting the following topics with %s:\n", kmsKey)
+		for idx, arn := range toEncrypt {
+			fmt.Fprintln(common.Out, idx+1, topicName(arn))
+		}
+		fmt.Fprint(common.Out, "\n")
+	}
+
+	failingTopicCount := len(regionTopics.FailingTopics)
+	fmt.Fprintln(common.Out, failingTopicCount, "failing topics found.")
+	fmt.Fprintln(common.Out, len(toEncrypt), "to encrypt, and", failingTopicCount-len(toEncrypt), "to skip.")
+	return toEncrypt
+}
+
+// FindStatementsToRemove prints each public statement of the failing topics'
+// policies, and returns the changes that remove them. SNS won't accept a
+// policy without statements, so topics whose every statement is public are
+// skipped.
+func FindStatementsToRemove(regionTopics RegionTopics) []*policy.Change {
+	var resources []policy.Resource
+	for _, t := range regionTopics.FailingTopics {
+		resources = append(resources, policy.Resource{Id: t.Arn, Name: topicName(t.Arn), Policy: t.Policy, SkipReason: t.SkipReason})
+	}
+	return policy.PlanRemovals("topics", resources, regionTopics.TopicsInStacks, regionTopics.ManagedInCode, false)
+}
+
+func setTopicAttribute(ctx context.Context, snsClient *sns.Client, arn string, name string, value string) error {
+	_, err := snsClient.SetTopicAttributes(ctx, &sns.SetTopicAttributesInput{
+		TopicArn:       &arn,
+		AttributeName:  &name,
+		AttributeValue: &value,
+	})
+	return err
+}
+
+func encryptTopic(ctx context.Context, snsClient *sns.Client, arn string, kmsKey string) error {
+	if err := setTopicAttribute(ctx, snsClient, arn, "KmsMasterKeyId", kmsKey); err != nil {
+		return err
+	}
+	slog.Info("Encrypted topic", "topic", topicName(arn), "key", kmsKey)
+	return nil
+}
+
+// removePublicStatements removes the public statements from a topic's policy
+// as it is now, rather than as it was planned.
+func removePublicStatements(ctx context.Context, snsClient *sns.Client, change *policy.Change) error {
+	read := func() (policy.Document, error) {
+		t, err := getTopic(ctx, snsClient, change.Id)
+		if err == nil && t.SkipReason != "" {
+			err = errors.New(t.SkipReason)
+		}
+		return t.Policy, err
+	}
+	write := func(updated string) error {
+		return setTopicAttribute(ctx, snsClient, change.Id, "Policy", updated)
+	}
+	// SNS won't accept a policy without statements
+	if err := change.Apply(read, write, false); err != nil {
+		return err
+	}
+	slog.Info("Removed public statements", "topic", change.Name, "statements", len(change.Removed))
+	return nil
+}
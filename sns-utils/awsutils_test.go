@@ -0,0 +1,81 @@
+package snsutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/policy"
+)
+
+const arnPrefix = "arn:aws:sns:eu-west-1:123456789012:"
+
+func mustParse(t *testing.T, document string) policy.Document {
+	t.Helper()
+	parsed, err := policy.Parse(document)
+	if err != nil {
+		t.Fatalf("Error parsing policy: %v", err)
+	}
+	return parsed
+}
+
+func TestTopicName(t *testing.T) {
+	if name := topicName(arnPrefix + "alerts"); name != "alerts" {
+		t.Errorf("Error getting topic name. Expected alerts, got %s", name)
+	}
+}
+
+func TestFindTopicsToEncrypt(t *testing.T) {
+	events := mustParse(t, `{"Statement": [{"Effect": "Allow", "Principal": {"Service": "events.amazonaws.com"}, "Action": "sns:Publish"}]}`)
+	regionTopics := RegionTopics{
+		Region: "eu-west-1",
+		FailingTopics: []topic{
+			{Arn: arnPrefix + "fixable"},
+			{Arn: arnPrefix + "events", Policy: events},
+			{Arn: arnPrefix + "deleted", SkipReason: "topic no longer exists"},
+			{Arn: arnPrefix + "in-stack"},
+			{Arn: arnPrefix + "in-terraform"},
+		},
+		TopicsInStacks: []common.StackResources{{StackName: "app", PhysicalIds: []string{arnPrefix + "in-stack"}}},
+		ManagedInCode:  []common.ManagedBy{{Id: arnPrefix + "in-terraform", Reason: "in Terraform state"}},
+	}
+
+	expected := []string{arnPrefix + "fixable"}
+	if got := FindTopicsToEncrypt(regionTopics, DefaultKmsKey); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error finding topics to encrypt with the AWS managed key. Expected %v, got %v", expected, got)
+	}
+
+	// Services can publish to topics encrypted with a customer managed key
+	expected = []string{arnPrefix + "fixable", arnPrefix + "events"}
+	if got := FindTopicsToEncrypt(regionTopics, "alias/topics"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error finding topics to encrypt with a customer managed key. Expected %v, got %v", expected, got)
+	}
+}
+
+func TestFindStatementsToRemove(t *testing.T) {
+	public := mustParse(t, `{"Statement": [
+  {"Sid": "owner", "Effect": "Allow", "Principal": {"AWS": "*"}, "Action": "sns:Publish", "Condition": {"StringEquals": {"AWS:SourceOwner": "123456789012"}}},
+  {"Sid": "public", "Effect": "Allow", "Principal": "*", "Action": "sns:Subscribe"}
+]}`)
+	allPublic := mustParse(t, `{"Statement": [{"Sid": "public", "Effect": "Allow", "Principal": "*", "Action": "sns:Publish"}]}`)
+	regionTopics := RegionTopics{
+		Region: "eu-west-1",
+		FailingTopics: []topic{
+			{Arn: arnPrefix + "public", Policy: public},
+			{Arn: arnPrefix + "all-public", Policy: allPublic},
+			{Arn: arnPrefix + "fixed-already"},
+		},
+	}
+
+	changes := FindStatementsToRemove(regionTopics)
+	if len(changes) != 1 || changes[0].Id != arnPrefix+"public" {
+		t.Fatalf("Error finding statements to remove. Expected a change to the public topic, got %v", changes)
+	}
+	updated, err := policy.Parse(changes[0].Updated())
+	if err != nil {
+		t.Fatalf("Error parsing the updated policy: %v", err)
+	}
+	if len(updated.Statement) != 1 || updated.Statement[0].Sid != "owner" {
+		t.Errorf("Error removing public statements. Expected only the owner statement to remain, got %s", updated)
+	}
+}
@@ -0,0 +1,39 @@
+package snsutils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// FixSNS_1 encrypts failing topics with kmsKey in every region, after checking
+// each region can use the key.
+func FixSNS_1(ctx context.Context, sess *common.Session, opts common.Options, kmsKey string) (common.Result, error) {
+	findTopics := findRegionTopics(sess, opts, EncryptionPatch(kmsKey))
+	return common.Remediate(ctx, sess, opts, common.Remediation[RegionTopics, string]{
+		ControlId: "SNS.1",
+		Noun:      "topics",
+		Action:    "encrypt topics",
+		Evaluate:  EvaluateSNS_1(sess, opts.Concurrency),
+		Find: func(ctx context.Context, f common.FailingResources) (RegionTopics, error) {
+			if err := common.CheckEncryptionKey(ctx, sess.KMS(f.Region), kmsKey); err != nil {
+				return RegionTopics{Region: f.Region}, fmt.Errorf("could not use key: %w", err)
+			}
+			return findTopics(ctx, f)
+		},
+		Plan: func(regionTopics RegionTopics) ([]string, []common.StackPatch) {
+			return FindTopicsToEncrypt(regionTopics, kmsKey), regionTopics.StackPatches
+		},
+		Apply: func(ctx context.Context, change common.Change[string]) error {
+			return encryptTopic(ctx, sess.SNS(change.Region), change.Item, kmsKey)
+		},
+		Audit: func(arn string) (string, string, map[string]any) {
+			return "sns:SetTopicAttributes", arn, map[string]any{"KmsMasterKeyId": kmsKey}
+		},
+		Verify: func(ctx context.Context, change common.Change[string]) (bool, error) {
+			return topicEncrypted(ctx, sess.SNS(change.Region), change.Item)
+		},
+		Id: topicName,
+	})
+}
@@ -0,0 +1,85 @@
+package snsutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// topicName returns the name at the end of a topic ARN.
+func topicName(arn string) string {
+	return arn[strings.LastIndex(arn, ":")+1:]
+}
+
+func listTopics(ctx context.Context, snsClient *sns.Client) ([]string, error) {
+	var arns []string
+	paginator := sns.NewListTopicsPaginator(snsClient, &sns.ListTopicsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list topics: %w", err)
+		}
+		for _, topic := range page.Topics {
+			arns = append(arns, aws.ToString(topic.TopicArn))
+		}
+	}
+	return arns, nil
+}
+
+// evaluateTopics finds the topics in a region that fail a check, without
+// relying on Security Hub.
+func evaluateTopics(sess *common.Session, concurrency int, failed func(topic) bool) common.Evaluator {
+	return func(ctx context.Context, region string) ([]string, error) {
+		snsClient := sess.SNS(region)
+		arns, err := listTopics(ctx, snsClient)
+		if err != nil {
+			return nil, err
+		}
+
+		type result struct {
+			failed bool
+			err    error
+		}
+		results := common.ParallelMap(ctx, concurrency, arns, func(ctx context.Context, arn string) result {
+			t, err := getTopic(ctx, snsClient, arn)
+			return result{failed: err == nil && t.SkipReason == "" && failed(t), err: err}
+		})
+
+		var failing []string
+		var errs []error
+		for i, res := range results {
+			if res.err != nil {
+				errs = append(errs, res.err)
+			} else if res.failed {
+				failing = append(failing, arns[i])
+			}
+		}
+		return failing, errors.Join(errs...)
+	}
+}
+
+// EvaluateSNS_1 finds the topics in a region that aren't encrypted at rest.
+func EvaluateSNS_1(sess *common.Session, concurrency int) common.Evaluator {
+	return evaluateTopics(sess, concurrency, func(t topic) bool { return t.KmsMasterKeyId == "" })
+}
+
+// EvaluateSNS_4 finds the topics in a region whose access policy lets anyone
+// use them.
+func EvaluateSNS_4(sess *common.Session, concurrency int) common.Evaluator {
+	return evaluateTopics(sess, concurrency, func(t topic) bool { return len(t.Policy.PublicStatements()) > 0 })
+}
+
+func topicEncrypted(ctx context.Context, snsClient *sns.Client, arn string) (bool, error) {
+	t, err := getTopic(ctx, snsClient, arn)
+	return t.KmsMasterKeyId != "", err
+}
+
+func topicPrivate(ctx context.Context, snsClient *sns.Client, arn string) (bool, error) {
+	t, err := getTopic(ctx, snsClient, arn)
+	return err == nil && len(t.Policy.PublicStatements()) == 0, err
+}
@@ -0,0 +1,16 @@
+package snsutils
+
+import (
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/policy"
+)
+
+// EncryptionPatch proposes the template change that encrypts a stack-managed
+// topic with kmsKey.
+func EncryptionPatch(kmsKey string) common.PatchBuilder {
+	return common.PropertyPatch("KmsMasterKeyId", kmsKey)
+}
+
+// AccessPolicyPatch proposes removing the public statements from the
+// AWS::SNS::TopicPolicy resources that apply to a stack-managed topic.
+var AccessPolicyPatch = policy.RemovePublicStatementsPatch(common.ResourceTypeTopicPolicy, "Topics")
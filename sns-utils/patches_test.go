@@ -0,0 +1,38 @@
+package snsutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func TestEncryptionPatch(t *testing.T) {
+	template, err := common.ParseTemplate(`
+Resources:
+  BareTopic:
+    Type: AWS::SNS::Topic
+  Topic:
+    Type: AWS::SNS::Topic
+    Properties:
+      TopicName: alerts
+  EncryptedTopic:
+    Type: AWS::SNS::Topic
+    Properties:
+      KmsMasterKeyId: ""
+`)
+	if err != nil {
+		t.Fatalf("Error parsing template: %v", err)
+	}
+
+	cases := map[string][]common.PatchOp{
+		"BareTopic":      {{Op: "add", Path: "/Resources/BareTopic/Properties", Value: map[string]any{"KmsMasterKeyId": DefaultKmsKey}}},
+		"Topic":          {{Op: "add", Path: "/Resources/Topic/Properties/KmsMasterKeyId", Value: DefaultKmsKey}},
+		"EncryptedTopic": {{Op: "replace", Path: "/Resources/EncryptedTopic/Properties/KmsMasterKeyId", Value: DefaultKmsKey}},
+	}
+	for logicalId, expected := range cases {
+		if got := EncryptionPatch(DefaultKmsKey)(template, logicalId); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Error patching %s. Expected %v, got %v", logicalId, expected, got)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package sqsutils
+
+import (
+	"context"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/policy"
+)
+
+// FixSQS_3 removes the statements that let anyone use failing queues from
+// their access policies, in every region. Statements restricted by a
+// condition, e.g. on aws:SourceArn, are kept. Each original policy is
+// recorded in the audit log, so it can be restored.
+func FixSQS_3(ctx context.Context, sess *common.Session, opts common.Options) (common.Result, error) {
+	return common.Remediate(ctx, sess, opts, common.Remediation[RegionQueues, *policy.Change]{
+		ControlId: "SQS.3",
+		Noun:      "queues",
+		Changes:   "queue policies",
+		Action:    "remove public statements",
+		Evaluate:  EvaluateSQS_3(sess, opts.Concurrency),
+		Find:      findRegionQueues(sess, opts, AccessPolicyPatch),
+		Plan: func(regionQueues RegionQueues) ([]*policy.Change, []common.StackPatch) {
+			return FindStatementsToRemove(regionQueues), regionQueues.StackPatches
+		},
+		Apply: func(ctx context.Context, change common.Change[*policy.Change]) error {
+			return removePublicStatements(ctx, sess.SQS(change.Region), change.Item)
+		},
+		Audit: func(change *policy.Change) (string, string, map[string]any) {
+			return "sqs:SetQueueAttributes", change.Id, change.Parameters()
+		},
+		Verify: func(ctx context.Context, change common.Change[*policy.Change]) (bool, error) {
+			return queuePrivate(ctx, sess.SQS(change.Region), change.Item.Id)
+		},
+		Id: func(change *policy.Change) string { return change.Name },
+	})
+}
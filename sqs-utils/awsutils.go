@@ -0,0 +1,296 @@
+package sqsutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/policy"
+)
+
+// DefaultKmsKey is the AWS managed key for SQS.
+const DefaultKmsKey = "alias/aws/sqs"
+
+// queue is a failing queue, identified by its URL, which is also its
+// physical ID in CloudFormation.
+type queue struct {
+	Url            string
+	Arn            string
+	KmsMasterKeyId string
+	SqsManagedSse  bool
+	Policy         policy.Document
+	Tags           map[string]string
+	SkipReason     string
+}
+
+func (q queue) Encrypted() bool {
+	return q.KmsMasterKeyId != "" || q.SqsManagedSse
+}
+
+func getQueueTags(ctx context.Context, sqsClient *sqs.Client, url string) (map[string]string, error) {
+	resp, err := sqsClient.ListQueueTags(ctx, &sqs.ListQueueTagsInput{QueueUrl: &url})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags for queue %s: %w", queueName(url), err)
+	}
+	return resp.Tags, nil
+}
+
+// parseQueueArn returns the name and owning account of a queue, given its ARN,
+// e.g. arn:aws:sqs:eu-west-1:123456789012:jobs. ARNs can come from Security
+// Hub findings, so they are checked rather than trusted.
+func parseQueueArn(queueArn string) (name string, owner string, err error) {
+	parsed, err := arn.Parse(queueArn)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid queue ARN %q: %w", queueArn, err)
+	}
+	if parsed.Service != "sqs" || parsed.AccountID == "" || parsed.Resource == "" || strings.ContainsAny(parsed.Resource, ":/") {
+		return "", "", fmt.Errorf("invalid queue ARN %q", queueArn)
+	}
+	return parsed.Resource, parsed.AccountID, nil
+}
+
+func getQueueUrl(ctx context.Context, sqsClient *sqs.Client, queueArn string) (string, error) {
+	name, owner, err := parseQueueArn(queueArn)
+	if err != nil {
+		return "", err
+	}
+	resp, err := sqsClient.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: &name, QueueOwnerAWSAccountId: &owner})
+	if err != nil {
+		return "", err
+	}
+	return *resp.QueueUrl, nil
+}
+
+func getQueue(ctx context.Context, sqsClient *sqs.Client, url string) (queue, error) {
+	resp, err := sqsClient.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       &url,
+		AttributeNames: []sqsTypes.QueueAttributeName{sqsTypes.QueueAttributeNameAll},
+	})
+	var notFound *sqsTypes.QueueDoesNotExist
+	if errors.As(err, &notFound) {
+		return queue{Url: url, SkipReason: "queue no longer exists"}, nil
+	}
+	if err != nil {
+		return queue{}, fmt.Errorf("failed to get attributes of queue %s: %w", queueName(url), err)
+	}
+
+	q := queue{
+		Url:            url,
+		Arn:            resp.Attributes[string(sqsTypes.QueueAttributeNameQueueArn)],
+		KmsMasterKeyId: resp.Attributes[string(sqsTypes.QueueAttributeNameKmsMasterKeyId)],
+		SqsManagedSse:  resp.Attributes[string(sqsTypes.QueueAttributeNameSqsManagedSseEnabled)] == "true",
+	}
+	if document := resp.Attributes[string(sqsTypes.QueueAttributeNamePolicy)]; document != "" {
+		q.Policy, err = policy.Parse(document)
+		if err != nil {
+			return queue{}, fmt.Errorf("failed to read policy of queue %s: %w", queueName(url), err)
+		}
+	}
+	return q, nil
+}
+
+// getFailingQueue reads a failing queue, given its ARN.
+func getFailingQueue(ctx context.Context, sqsClient *sqs.Client, queueArn string) (queue, error) {
+	url, err := getQueueUrl(ctx, sqsClient, queueArn)
+	var notFound *sqsTypes.QueueDoesNotExist
+	if errors.As(err, &notFound) {
+		return queue{Url: queueName(queueArn), Arn: queueArn, SkipReason: "queue no longer exists"}, nil
+	}
+	if err != nil {
+		return queue{}, fmt.Errorf("failed to get URL of queue %s: %w", queueName(queueArn), err)
+	}
+
+	q, err := getQueue(ctx, sqsClient, url)
+	if err != nil || q.SkipReason != "" {
+		return q, err
+	}
+	q.Tags, err = getQueueTags(ctx, sqsClient, url)
+	if err != nil {
+		return queue{}, err
+	}
+	return q, nil
+}
+
+// RegionQueues is everything we need to know about a region to decide which
+// queues to fix. It is gathered concurrently and printed afterwards.
+type RegionQueues struct {
+	Region         string
+	FailingQueues  []queue
+	QueuesInStacks []common.StackResources // Keyed by queue URL
+	ManagedInCode  []common.ManagedBy      // Managed by IaC other than CloudFormation
+	StackPatches   []common.StackPatch
+	Err            error
+}
+
+// FindRegionQueues gathers the failing queues in a region, and proposes
+// patches for those in stacks with build.
+func FindRegionQueues(ctx context.Context, sqsClient *sqs.Client, cfnClient *cloudformation.Client, opts common.Options, failing common.FailingResources, accountId string, build common.PatchBuilder) RegionQueues {
+	region := failing.Region
+	type queueResult struct {
+		queue queue
+		err   error
+	}
+	results := common.ParallelMap(ctx, opts.Concurrency, failing.Arns, func(ctx context.Context, queueArn string) queueResult {
+		q, err := getFailingQueue(ctx, sqsClient, queueArn)
+		return queueResult{queue: q, err: err}
+	})
+	var failingQueues []queue
+	var existing []string
+	var errs []error
+	for _, res := range results {
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		failingQueues = append(failingQueues, res.queue)
+		if res.queue.SkipReason == "" {
+			existing = append(existing, res.queue.Url)
+		}
+	}
+	if len(errs) > 0 {
+		return RegionQueues{Region: region, Err: fmt.Errorf("could not read failing queues: %w", errors.Join(errs...))}
+	}
+
+	queuesInStacks, err := opts.StackLookup.FindResourcesInStacks(ctx, cfnClient, accountId, region, common.ResourceTypeSqsQueue, existing, opts.Concurrency)
+	if err != nil {
+		return RegionQueues{Region: region, Err: fmt.Errorf("could not determine which queues are in CloudFormation stacks: %w", err)}
+	}
+
+	notInStacks := common.Without(existing, common.ResourcesInStacks(queuesInStacks))
+	var resources []common.ManagedResource
+	for _, q := range failingQueues {
+		if slices.Contains(notInStacks, q.Url) {
+			resources = append(resources, common.ManagedResource{Id: q.Url, Type: common.ResourceTypeSqsQueue, Tags: q.Tags})
+		}
+	}
+
+	return RegionQueues{
+		Region:         region,
+		FailingQueues:  failingQueues,
+		QueuesInStacks: queuesInStacks,
+		ManagedInCode:  opts.Detectors.Detect(resources),
+		StackPatches:   common.BuildStackPatches(ctx, cfnClient, region, queuesInStacks, build, opts.Concurrency),
+	}
+}
+
+// findRegionQueues looks up a region's failing queues for SQS.1 or SQS.3.
+func findRegionQueues(sess *common.Session, opts common.Options, build common.PatchBuilder) func(context.Context, common.FailingResources) (RegionQueues, error) {
+	return func(ctx context.Context, f common.FailingResources) (RegionQueues, error) {
+		regionQueues := FindRegionQueues(ctx, sess.SQS(f.Region), sess.CloudFormation(f.Region), opts, f, sess.AccountId, build)
+		return regionQueues, regionQueues.Err
+	}
+}
+
+// printExcluded prints the queues to be fixed elsewhere, and returns them.
+func printExcluded(regionQueues RegionQueues, skipped map[string]string) []string {
+	var skips []common.Skipped
+	for _, q := range regionQueues.FailingQueues {
+		if reason, ok := skipped[q.Url]; ok {
+			skips = append(skips, common.Skipped{Name: queueName(q.Url), Reason: reason})
+		}
+	}
+	return common.PrintExcluded("queues", skips, regionQueues.QueuesInStacks, regionQueues.ManagedInCode)
+}
+
+// encryptionSkipReason explains why a queue shouldn't be encrypted with
+// kmsKey, or is empty. Services sending to a queue, e.g. SNS or S3 event
+// notifications, need to use its key, which the key policy of an AWS managed
+// key doesn't let them do.
+func encryptionSkipReason(q queue, kmsKey string) string {
+	if q.SkipReason != "" {
+		return q.SkipReason
+	}
+	if q.Encrypted() {
+		return "already encrypted"
+	}
+	if services := q.Policy.Services(); len(services) > 0 && common.IsAwsManagedKey(kmsKey) {
+		return fmt.Sprintf("its policy lets %s send messages, which can't use the AWS managed key. Use -kms-key with a key they can use", strings.Join(services, ", "))
+	}
+	return ""
+}
+
+// FindQueuesToEncrypt prints what we found in a region, and returns the URLs
+// of the queues to encrypt with kmsKey.
+func FindQueuesToEncrypt(regionQueues RegionQueues, kmsKey string) []string {
+	skipped := map[string]string{}
+	for _, q := range regionQueues.FailingQueues {
+		if reason := encryptionSkipReason(q, kmsKey); reason != "" {
+			skipped[q.Url] = reason
+		}
+	}
+	excluded := printExcluded(regionQueues, skipped)
+
+	var toEncrypt []string
+	for _, q := range regionQueues.FailingQueues {
+		if _, ok := skipped[q.Url]; !ok && !slices.Contains(excluded, q.Url) {
+			toEncrypt = append(toEncrypt, q.Url)
+		}
+	}
+
+	if len(toEncrypt) > 0 {
+		fmt.Fprintf(common.Out, "\nEncrypting the following queues with %s:\n", kmsKey)
+		for idx, url := range toEncrypt {
+			fmt.Fprintln(common.Out, idx+1, queueName(url))
+		}
+		fmt.Fprint(common.Out, "\n")
+	}
+
+	failingQueueCount := len(regionQueues.FailingQueues)
+	fmt.Fprintln(common.Out, failingQueueCount, "failing queues found.")
+	fmt.Fprintln(common.Out, len(toEncrypt), "to encrypt, and", failingQueueCount-len(toEncrypt), "to skip.")
+	return toEncrypt
+}
+
+// FindStatementsToRemove prints each public statement of the failing queues'
+// policies, and returns the changes that remove them.
+func FindStatementsToRemove(regionQueues RegionQueues) []*policy.Change {
+	var resources []policy.Resource
+	for _, q := range regionQueues.FailingQueues {
+		resources = append(resources, policy.Resource{Id: q.Url, Name: queueName(q.Url), Policy: q.Policy, SkipReason: q.SkipReason})
+	}
+	return policy.PlanRemovals("queues", resources, regionQueues.QueuesInStacks, regionQueues.ManagedInCode, true)
+}
+
+func setQueueAttribute(ctx context.Context, sqsClient *sqs.Client, url string, name sqsTypes.QueueAttributeName, value string) error {
+	_, err := sqsClient.SetQueueAttributes(ctx, &sqs.SetQueueAttributesInput{
+		QueueUrl:   &url,
+		Attributes: map[string]string{string(name): value},
+	})
+	return err
+}
+
+func encryptQueue(ctx context.Context, sqsClient *sqs.Client, url string, kmsKey string) error {
+	if err := setQueueAttribute(ctx, sqsClient, url, sqsTypes.QueueAttributeNameKmsMasterKeyId, kmsKey); err != nil {
+		return err
+	}
+	slog.Info("Encrypted queue", "queue", queueName(url), "key", kmsKey)
+	return nil
+}
+
+// removePublicStatements removes the public statements from a queue's policy
+// as it is now, rather than as it was planned.
+func removePublicStatements(ctx context.Context, sqsClient *sqs.Client, change *policy.Change) error {
+	read := func() (policy.Document, error) {
+		q, err := getQueue(ctx, sqsClient, change.Id)
+		if err == nil && q.SkipReason != "" {
+			err = errors.New(q.SkipReason)
+		}
+		return q.Policy, err
+	}
+	write := func(updated string) error {
+		return setQueueAttribute(ctx, sqsClient, change.Id, sqsTypes.QueueAttributeNamePolicy, updated)
+	}
+	if err := change.Apply(read, write, true); err != nil {
+		return err
+	}
+	slog.Info("Removed public statements", "queue", change.Name, "statements", len(change.Removed))
+	return nil
+}
@@ -0,0 +1,78 @@
+package sqsutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/policy"
+)
+
+const urlPrefix = "https://sqs.eu-west-1.amazonaws.com/123456789012/"
+
+func mustParse(t *testing.T, document string) policy.Document {
+	t.Helper()
+	parsed, err := policy.Parse(document)
+	if err != nil {
+		t.Fatalf("Error parsing policy: %v", err)
+	}
+	return parsed
+}
+
+func TestQueueName(t *testing.T) {
+	cases := map[string]string{
+		"arn:aws:sqs:eu-west-1:123456789012:jobs": "jobs",
+		urlPrefix + "jobs.fifo":                   "jobs.fifo",
+	}
+	for arnOrUrl, expected := range cases {
+		if got := queueName(arnOrUrl); got != expected {
+			t.Errorf("Error getting queue name from %s. Expected %s, got %s", arnOrUrl, expected, got)
+		}
+	}
+}
+
+func TestParseQueueArn(t *testing.T) {
+	name, owner, err := parseQueueArn("arn:aws:sqs:eu-west-1:123456789012:jobs.fifo")
+	if err != nil || name != "jobs.fifo" || owner != "123456789012" {
+		t.Errorf("Error parsing queue ARN. Expected jobs.fifo owned by 123456789012, got %s, %s, %v", name, owner, err)
+	}
+
+	for _, invalid := range []string{
+		"arn:aws:sqs:eu-west-1",
+		"arn:aws:sqs:eu-west-1:123456789012:",
+		"arn:aws:sqs:eu-west-1::jobs",
+		"arn:aws:sns:eu-west-1:123456789012:jobs",
+		urlPrefix + "jobs",
+		"",
+	} {
+		if _, _, err := parseQueueArn(invalid); err == nil {
+			t.Errorf("Error parsing queue ARN. Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestFindQueuesToEncrypt(t *testing.T) {
+	// The usual policy letting an SNS topic send to a queue
+	subscribed := mustParse(t, `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "*"}, "Action": "sqs:SendMessage",
+  "Condition": {"ArnEquals": {"aws:SourceArn": "arn:aws:sns:eu-west-1:123456789012:alerts"}}}]}`)
+	regionQueues := RegionQueues{
+		Region: "eu-west-1",
+		FailingQueues: []queue{
+			{Url: urlPrefix + "fixable"},
+			{Url: urlPrefix + "subscribed", Policy: subscribed},
+			{Url: urlPrefix + "sse-sqs", SqsManagedSse: true},
+			{Url: urlPrefix + "in-stack"},
+		},
+		QueuesInStacks: []common.StackResources{{StackName: "app", PhysicalIds: []string{urlPrefix + "in-stack"}}},
+	}
+
+	expected := []string{urlPrefix + "fixable"}
+	if got := FindQueuesToEncrypt(regionQueues, DefaultKmsKey); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error finding queues to encrypt with the AWS managed key. Expected %v, got %v", expected, got)
+	}
+
+	expected = []string{urlPrefix + "fixable", urlPrefix + "subscribed"}
+	if got := FindQueuesToEncrypt(regionQueues, "alias/queues"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error finding queues to encrypt with a customer managed key. Expected %v, got %v", expected, got)
+	}
+}
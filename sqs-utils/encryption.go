@@ -0,0 +1,39 @@
+package sqsutils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// FixSQS_1 encrypts failing queues with kmsKey in every region, after checking
+// each region can use the key.
+func FixSQS_1(ctx context.Context, sess *common.Session, opts common.Options, kmsKey string) (common.Result, error) {
+	findQueues := findRegionQueues(sess, opts, EncryptionPatch(kmsKey))
+	return common.Remediate(ctx, sess, opts, common.Remediation[RegionQueues, string]{
+		ControlId: "SQS.1",
+		Noun:      "queues",
+		Action:    "encrypt queues",
+		Evaluate:  EvaluateSQS_1(sess, opts.Concurrency),
+		Find: func(ctx context.Context, f common.FailingResources) (RegionQueues, error) {
+			if err := common.CheckEncryptionKey(ctx, sess.KMS(f.Region), kmsKey); err != nil {
+				return RegionQueues{Region: f.Region}, fmt.Errorf("could not use key: %w", err)
+			}
+			return findQueues(ctx, f)
+		},
+		Plan: func(regionQueues RegionQueues) ([]string, []common.StackPatch) {
+			return FindQueuesToEncrypt(regionQueues, kmsKey), regionQueues.StackPatches
+		},
+		Apply: func(ctx context.Context, change common.Change[string]) error {
+			return encryptQueue(ctx, sess.SQS(change.Region), change.Item, kmsKey)
+		},
+		Audit: func(url string) (string, string, map[string]any) {
+			return "sqs:SetQueueAttributes", url, map[string]any{"KmsMasterKeyId": kmsKey}
+		},
+		Verify: func(ctx context.Context, change common.Change[string]) (bool, error) {
+			return queueEncrypted(ctx, sess.SQS(change.Region), change.Item)
+		},
+		Id: queueName,
+	})
+}
@@ -0,0 +1,86 @@
+package sqsutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// queueName returns the name at the end of a queue ARN or URL.
+func queueName(arnOrUrl string) string {
+	return arnOrUrl[strings.LastIndexAny(arnOrUrl, ":/")+1:]
+}
+
+func listQueues(ctx context.Context, sqsClient *sqs.Client) ([]string, error) {
+	var urls []string
+	// ListQueues only pages when MaxResults is set
+	paginator := sqs.NewListQueuesPaginator(sqsClient, &sqs.ListQueuesInput{MaxResults: aws.Int32(1000)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list queues: %w", err)
+		}
+		urls = append(urls, page.QueueUrls...)
+	}
+	return urls, nil
+}
+
+// evaluateQueues finds the queues in a region that fail a check, without
+// relying on Security Hub.
+func evaluateQueues(sess *common.Session, concurrency int, failed func(queue) bool) common.Evaluator {
+	return func(ctx context.Context, region string) ([]string, error) {
+		sqsClient := sess.SQS(region)
+		urls, err := listQueues(ctx, sqsClient)
+		if err != nil {
+			return nil, err
+		}
+
+		type result struct {
+			queue  queue
+			failed bool
+			err    error
+		}
+		results := common.ParallelMap(ctx, concurrency, urls, func(ctx context.Context, url string) result {
+			q, err := getQueue(ctx, sqsClient, url)
+			return result{queue: q, failed: err == nil && q.SkipReason == "" && failed(q), err: err}
+		})
+
+		var failing []string
+		var errs []error
+		for _, res := range results {
+			if res.err != nil {
+				errs = append(errs, res.err)
+			} else if res.failed {
+				failing = append(failing, res.queue.Arn)
+			}
+		}
+		return failing, errors.Join(errs...)
+	}
+}
+
+// EvaluateSQS_1 finds the queues in a region that aren't encrypted at rest,
+// with either SQS managed keys or KMS.
+func EvaluateSQS_1(sess *common.Session, concurrency int) common.Evaluator {
+	return evaluateQueues(sess, concurrency, func(q queue) bool { return !q.Encrypted() })
+}
+
+// EvaluateSQS_3 finds the queues in a region whose access policy lets anyone
+// use them.
+func EvaluateSQS_3(sess *common.Session, concurrency int) common.Evaluator {
+	return evaluateQueues(sess, concurrency, func(q queue) bool { return len(q.Policy.PublicStatements()) > 0 })
+}
+
+func queueEncrypted(ctx context.Context, sqsClient *sqs.Client, url string) (bool, error) {
+	q, err := getQueue(ctx, sqsClient, url)
+	return q.Encrypted(), err
+}
+
+func queuePrivate(ctx context.Context, sqsClient *sqs.Client, url string) (bool, error) {
+	q, err := getQueue(ctx, sqsClient, url)
+	return err == nil && len(q.Policy.PublicStatements()) == 0, err
+}
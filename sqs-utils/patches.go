@@ -0,0 +1,16 @@
+package sqsutils
+
+import (
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common/policy"
+)
+
+// EncryptionPatch proposes the template change that encrypts a stack-managed
+// queue with kmsKey.
+func EncryptionPatch(kmsKey string) common.PatchBuilder {
+	return common.PropertyPatch("KmsMasterKeyId", kmsKey)
+}
+
+// AccessPolicyPatch proposes removing the public statements from the
+// AWS::SQS::QueuePolicy resources that apply to a stack-managed queue.
+var AccessPolicyPatch = policy.RemovePublicStatementsPatch(common.ResourceTypeQueuePolicy, "Queues")
@@ -0,0 +1,33 @@
+package sqsutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func TestEncryptionPatch(t *testing.T) {
+	template, err := common.ParseTemplate(`
+Resources:
+  BareQueue:
+    Type: AWS::SQS::Queue
+  Queue:
+    Type: AWS::SQS::Queue
+    Properties:
+      SqsManagedSseEnabled: false
+`)
+	if err != nil {
+		t.Fatalf("Error parsing template: %v", err)
+	}
+
+	cases := map[string][]common.PatchOp{
+		"BareQueue": {{Op: "add", Path: "/Resources/BareQueue/Properties", Value: map[string]any{"KmsMasterKeyId": "alias/queues"}}},
+		"Queue":     {{Op: "add", Path: "/Resources/Queue/Properties/KmsMasterKeyId", Value: "alias/queues"}},
+	}
+	for logicalId, expected := range cases {
+		if got := EncryptionPatch("alias/queues")(template, logicalId); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Error patching %s. Expected %v, got %v", logicalId, expected, got)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package ssmutils
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// sharedRoleThreshold is how many instances must use a role before we warn
+// that adding the policy to it affects them all.
+const sharedRoleThreshold = 3
+
+const (
+	fixAttachProfile = "attach-profile"
+	fixAttachPolicy  = "attach-policy"
+)
+
+// ssmFix is one change that lets Systems Manager manage instances: either
+// attaching the default instance profile to an instance without one, or
+// adding the policy to the role of an existing profile.
+type ssmFix struct {
+	Kind        string
+	InstanceId  string // For fixAttachProfile
+	ProfileName string
+	RoleName    string   // For fixAttachPolicy
+	Instances   []string // For fixAttachPolicy, every instance in the region using the role
+}
+
+// Id is the resource a fix changes.
+func (f ssmFix) Id() string {
+	if f.Kind == fixAttachProfile {
+		return f.InstanceId
+	}
+	return f.RoleName
+}
+
+// Shared reports whether adding the policy to a role affects enough
+// instances to call out.
+func (f ssmFix) Shared() bool {
+	return f.Kind == fixAttachPolicy && len(f.Instances) >= sharedRoleThreshold
+}
+
+// RegionInstances is everything we need to know about a region to decide
+// how to fix its instances. It is gathered concurrently and printed afterwards.
+type RegionInstances struct {
+	Region            string
+	FailingInstances  []ec2Instance
+	SkipReasons       map[string]string // Keyed by instance ID
+	InstancesInStacks []common.StackResources
+	RolesInStacks     []common.StackResources
+	ManagedInCode     []common.ManagedBy  // Managed by IaC other than CloudFormation
+	ProfileUsers      map[string][]string // Instance IDs, keyed by the ARN of the profile they use
+	StackPatches      []common.StackPatch
+	Err               error
+}
+
+// skipReason explains why we won't fix an instance, or returns an empty
+// string if we will.
+func skipReason(instance ec2Instance, defaultProfile string) string {
+	switch {
+	case instance.managed():
+		return "its role already has " + ssmPolicyArn
+	case instance.AutoScalingGroup != "":
+		return fmt.Sprintf("launched by Auto Scaling group %s, so please fix its launch template", instance.AutoScalingGroup)
+	case instance.Profile == nil && defaultProfile == "":
+		return "no instance profile. Pass -instance-profile to attach one"
+	case instance.Profile != nil && instance.Profile.RoleName == "":
+		return fmt.Sprintf("instance profile %s has no role", instance.Profile.Name)
+	}
+	return ""
+}
+
+func FindRegionInstances(ctx context.Context, ec2Client *ec2.Client, iamClient *iam.Client, cfnClient *cloudformation.Client, opts common.Options, failing common.FailingResources, accountId string, defaultProfile string) RegionInstances {
+	region := failing.Region
+	var ids []string
+	for _, arn := range failing.Arns {
+		ids = append(ids, nameFromArn(arn))
+	}
+	instances, err := inspectInstances(ctx, ec2Client, iamClient, ids, opts.Concurrency)
+	if err != nil {
+		return RegionInstances{Region: region, Err: fmt.Errorf("could not inspect failing instances: %w", err)}
+	}
+
+	skipReasons := map[string]string{}
+	var needProfile, roles, profileArns []string
+	var resources []common.ManagedResource
+	for _, instance := range instances {
+		if reason := skipReason(instance, defaultProfile); reason != "" {
+			skipReasons[instance.Id] = reason
+			continue
+		}
+		if instance.Profile == nil {
+			needProfile = append(needProfile, instance.Id)
+			resources = append(resources, common.ManagedResource{Id: instance.Id, Type: common.ResourceTypeEc2Instance, Tags: instance.Tags})
+		} else if !slices.Contains(roles, instance.Profile.RoleName) {
+			roles = append(roles, instance.Profile.RoleName)
+			profileArns = append(profileArns, instance.Profile.Arn)
+			resources = append(resources, common.ManagedResource{Id: instance.Profile.RoleName, Type: common.ResourceTypeIamRole, Tags: instance.Profile.RoleTags})
+		}
+	}
+
+	instancesInStacks, err := opts.StackLookup.FindResourcesInStacks(ctx, cfnClient, accountId, region, common.ResourceTypeEc2Instance, needProfile, opts.Concurrency)
+	if err != nil {
+		return RegionInstances{Region: region, Err: fmt.Errorf("could not determine which instances are in CloudFormation stacks: %w", err)}
+	}
+	rolesInStacks, err := opts.StackLookup.FindResourcesInStacks(ctx, cfnClient, accountId, region, common.ResourceTypeIamRole, roles, opts.Concurrency)
+	if err != nil {
+		return RegionInstances{Region: region, Err: fmt.Errorf("could not determine which roles are in CloudFormation stacks: %w", err)}
+	}
+	inStacks := append(common.ResourcesInStacks(instancesInStacks), common.ResourcesInStacks(rolesInStacks)...)
+	resources = slices.DeleteFunc(resources, func(r common.ManagedResource) bool { return slices.Contains(inStacks, r.Id) })
+
+	profileUsers, err := findProfileUsers(ctx, ec2Client, profileArns)
+	if err != nil {
+		return RegionInstances{Region: region, Err: err}
+	}
+
+	return RegionInstances{
+		Region:            region,
+		FailingInstances:  instances,
+		SkipReasons:       skipReasons,
+		InstancesInStacks: instancesInStacks,
+		RolesInStacks:     rolesInStacks,
+		ManagedInCode:     opts.Detectors.Detect(resources),
+		ProfileUsers:      profileUsers,
+		StackPatches:      common.BuildStackPatches(ctx, cfnClient, region, rolesInStacks, SsmPolicyPatch, opts.Concurrency),
+	}
+}
+
+// findProfileUsers finds every instance using each profile, not just the
+// failing ones we were given, as they are all affected by a change to the
+// profile's role.
+func findProfileUsers(ctx context.Context, ec2Client *ec2.Client, profileArns []string) (map[string][]string, error) {
+	users := map[string][]string{}
+	if len(profileArns) == 0 {
+		return users, nil
+	}
+	instances, err := describeInstances(ctx, ec2Client, "iam-instance-profile.arn", profileArns)
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range instances {
+		arn := aws.ToString(instance.IamInstanceProfile.Arn)
+		users[arn] = append(users[arn], aws.ToString(instance.InstanceId))
+	}
+	return users, nil
+}
+
+func describeInstance(instance ec2Instance) string {
+	if instance.Name == "" {
+		return instance.Id
+	}
+	return fmt.Sprintf("%s (%s)", instance.Id, instance.Name)
+}
+
+// FindFixes prints what we found in a region, and returns the changes to
+// make, highlighting roles shared by many instances.
+func FindFixes(regionInstances RegionInstances, defaultProfile string) []ssmFix {
+	var skipped []common.Skipped
+	for _, instance := range regionInstances.FailingInstances {
+		if reason := regionInstances.SkipReasons[instance.Id]; reason != "" {
+			skipped = append(skipped, common.Skipped{Name: describeInstance(instance), Reason: reason})
+		}
+	}
+	excluded := common.PrintExcluded("instances", skipped, regionInstances.InstancesInStacks, regionInstances.ManagedInCode)
+	if len(regionInstances.RolesInStacks) > 0 {
+		excluded = append(excluded, common.PrintExcluded("roles", nil, regionInstances.RolesInStacks, nil)...)
+	}
+
+	var fixes []ssmFix
+	for _, instance := range regionInstances.FailingInstances {
+		if regionInstances.SkipReasons[instance.Id] != "" {
+			continue
+		}
+		if instance.Profile == nil {
+			if !slices.Contains(excluded, instance.Id) {
+				fixes = append(fixes, ssmFix{Kind: fixAttachProfile, InstanceId: instance.Id, ProfileName: defaultProfile})
+			}
+			continue
+		}
+		role := instance.Profile.RoleName
+		if slices.Contains(excluded, role) || slices.ContainsFunc(fixes, func(f ssmFix) bool { return f.RoleName == role }) {
+			continue
+		}
+		fixes = append(fixes, ssmFix{
+			Kind:        fixAttachPolicy,
+			ProfileName: instance.Profile.Name,
+			RoleName:    role,
+			Instances:   regionInstances.ProfileUsers[instance.Profile.Arn],
+		})
+	}
+
+	printFixes(fixes)
+	failingCount := len(regionInstances.FailingInstances)
+	fmt.Fprintln(common.Out, failingCount, "failing instances found.")
+	fmt.Fprintln(common.Out, len(fixes), "changes to make.")
+	return fixes
+}
+
+func printFixes(fixes []ssmFix) {
+	var attachProfile, attachPolicy []ssmFix
+	for _, fix := range fixes {
+		if fix.Kind == fixAttachProfile {
+			attachProfile = append(attachProfile, fix)
+		} else {
+			attachPolicy = append(attachPolicy, fix)
+		}
+	}
+
+	if len(attachProfile) > 0 {
+		fmt.Fprintf(common.Out, "\nAttaching instance profile %s to the following instances:\n", attachProfile[0].ProfileName)
+		for idx, fix := range attachProfile {
+			fmt.Fprintln(common.Out, idx+1, fix.InstanceId)
+		}
+	}
+	if len(attachPolicy) > 0 {
+		fmt.Fprintf(common.Out, "\nAdding %s to the following roles:\n", ssmPolicyArn)
+		for idx, fix := range attachPolicy {
+			fmt.Fprintf(common.Out, "%d %s (profile %s), used by %d instances: %s\n", idx+1, fix.RoleName, fix.ProfileName, len(fix.Instances), strings.Join(fix.Instances, ", "))
+			if fix.Shared() {
+				fmt.Fprintf(common.Out, "  WARNING: role %s is shared by %d instances, all of which will be affected\n", fix.RoleName, len(fix.Instances))
+			}
+		}
+	}
+	fmt.Fprint(common.Out, "\n")
+}
+
+func applyFix(ctx context.Context, ec2Client *ec2.Client, iamClient *iam.Client, fix ssmFix) error {
+	if fix.Kind == fixAttachProfile {
+		_, err := ec2Client.AssociateIamInstanceProfile(ctx, &ec2.AssociateIamInstanceProfileInput{
+			InstanceId:         &fix.InstanceId,
+			IamInstanceProfile: &ec2Types.IamInstanceProfileSpecification{Name: &fix.ProfileName},
+		})
+		if err != nil {
+			return err
+		}
+		slog.Info("Attached instance profile", "instance", fix.InstanceId, "profile", fix.ProfileName)
+		return nil
+	}
+
+	_, err := iamClient.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
+		RoleName:  &fix.RoleName,
+		PolicyArn: aws.String(ssmPolicyArn),
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Attached policy to role", "role", fix.RoleName, "policy", ssmPolicyArn, "instances", len(fix.Instances))
+	return nil
+}
+
+// auditAction is the API call a fix makes, and its parameters, for the audit log.
+func auditAction(fix ssmFix) (string, map[string]any) {
+	if fix.Kind == fixAttachProfile {
+		return "ec2:AssociateIamInstanceProfile", map[string]any{"IamInstanceProfile": fix.ProfileName}
+	}
+	return "iam:AttachRolePolicy", map[string]any{"PolicyArn": ssmPolicyArn, "Instances": fix.Instances}
+}
+
+func profileAttached(ctx context.Context, ec2Client *ec2.Client, instanceId string) (bool, error) {
+	resp, err := ec2Client.DescribeIamInstanceProfileAssociations(ctx, &ec2.DescribeIamInstanceProfileAssociationsInput{
+		Filters: []ec2Types.Filter{
+			{Name: aws.String("instance-id"), Values: []string{instanceId}},
+			{Name: aws.String("state"), Values: []string{"associated"}},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to describe instance profile associations for %s: %w", instanceId, err)
+	}
+	return len(resp.IamInstanceProfileAssociations) > 0, nil
+}
+
+func fixApplied(ctx context.Context, ec2Client *ec2.Client, iamClient *iam.Client, fix ssmFix) (bool, error) {
+	if fix.Kind == fixAttachProfile {
+		return profileAttached(ctx, ec2Client, fix.InstanceId)
+	}
+	return roleHasPolicy(ctx, iamClient, fix.RoleName, ssmPolicyArn)
+}
@@ -0,0 +1,80 @@
+package ssmutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func TestNameFromArn(t *testing.T) {
+	cases := map[string]string{
+		"arn:aws:ec2:eu-west-1:123456789012:instance/i-0123456789abcdef0":   "i-0123456789abcdef0",
+		"arn:aws:iam::123456789012:instance-profile/path/to/my-app-profile": "my-app-profile",
+	}
+	for arn, expected := range cases {
+		if got := nameFromArn(arn); got != expected {
+			t.Errorf("Error getting name from %s. Expected %s, got %s", arn, expected, got)
+		}
+	}
+}
+
+func TestSkipReason(t *testing.T) {
+	withPolicy := &instanceProfile{Name: "managed", RoleName: "managed-role", HasPolicy: true}
+	withoutPolicy := &instanceProfile{Name: "unmanaged", RoleName: "unmanaged-role"}
+	withoutRole := &instanceProfile{Name: "empty"}
+
+	cases := []struct {
+		name           string
+		instance       ec2Instance
+		defaultProfile string
+		fixable        bool
+	}{
+		{"profile without the policy", ec2Instance{Profile: withoutPolicy}, "", true},
+		{"profile with the policy", ec2Instance{Profile: withPolicy}, "", false},
+		{"profile without a role", ec2Instance{Profile: withoutRole}, "", false},
+		{"no profile, with a default", ec2Instance{}, "default", true},
+		{"no profile, without a default", ec2Instance{}, "", false},
+		{"in an Auto Scaling group", ec2Instance{Profile: withoutPolicy, AutoScalingGroup: "my-asg"}, "", false},
+	}
+	for _, c := range cases {
+		if got := skipReason(c.instance, c.defaultProfile) == ""; got != c.fixable {
+			t.Errorf("Error deciding whether to fix instance with %s. Expected fixable %v, got reason %q", c.name, c.fixable, skipReason(c.instance, c.defaultProfile))
+		}
+	}
+}
+
+func TestFindFixes(t *testing.T) {
+	shared := &instanceProfile{Arn: "arn:shared", Name: "shared", RoleName: "shared-role"}
+	inStack := &instanceProfile{Arn: "arn:stack", Name: "stack", RoleName: "stack-role"}
+	regionInstances := RegionInstances{
+		Region: "eu-west-1",
+		FailingInstances: []ec2Instance{
+			{Id: "i-1", Profile: shared},
+			{Id: "i-2", Profile: shared},
+			{Id: "i-3"},
+			{Id: "i-4", Profile: inStack},
+			{Id: "i-5"},
+			{Id: "i-6", AutoScalingGroup: "my-asg"},
+		},
+		SkipReasons:   map[string]string{"i-6": "launched by Auto Scaling group my-asg"},
+		RolesInStacks: []common.StackResources{{StackName: "app", PhysicalIds: []string{"stack-role"}}},
+		ManagedInCode: []common.ManagedBy{{Id: "i-5", Reason: "tagged terraform=true"}},
+		ProfileUsers:  map[string][]string{"arn:shared": {"i-1", "i-2", "i-7"}},
+	}
+
+	fixes := FindFixes(regionInstances, "default")
+	expected := []ssmFix{
+		{Kind: fixAttachPolicy, ProfileName: "shared", RoleName: "shared-role", Instances: []string{"i-1", "i-2", "i-7"}},
+		{Kind: fixAttachProfile, InstanceId: "i-3", ProfileName: "default"},
+	}
+	if !reflect.DeepEqual(fixes, expected) {
+		t.Errorf("Error finding fixes. Expected %v, got %v", expected, fixes)
+	}
+	if !fixes[0].Shared() {
+		t.Errorf("Expected a role used by %d instances to be shared", len(fixes[0].Instances))
+	}
+	if fixes[1].Shared() {
+		t.Errorf("Expected attaching a profile not to be shared")
+	}
+}
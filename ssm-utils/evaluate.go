@@ -0,0 +1,221 @@
+package ssmutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// ssmPolicyArn is the AWS managed policy that lets the SSM agent on an
+// instance register with Systems Manager.
+const ssmPolicyArn = "arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"
+
+// ec2FilterLimit is the most values EC2 accepts in a single filter.
+const ec2FilterLimit = 200
+
+// nameFromArn returns the last part of an ARN's resource, e.g. the instance ID
+// of an instance ARN, or the name of an instance profile.
+func nameFromArn(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// instanceProfile is an instance profile, and whether its role lets Systems
+// Manager manage the instances using it.
+type instanceProfile struct {
+	Arn       string
+	Name      string
+	RoleName  string // Empty if the profile has no role
+	RoleTags  map[string]string
+	HasPolicy bool
+}
+
+// ec2Instance is an instance, and the instance profile it uses, if any.
+type ec2Instance struct {
+	Id               string
+	Name             string
+	Tags             map[string]string
+	AutoScalingGroup string
+	Profile          *instanceProfile
+}
+
+// managed reports whether the instance's role has the policy Systems
+// Manager needs.
+func (i ec2Instance) managed() bool {
+	return i.Profile != nil && i.Profile.HasPolicy
+}
+
+func toEc2Instance(instance ec2Types.Instance) ec2Instance {
+	tags := map[string]string{}
+	for _, tag := range instance.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return ec2Instance{
+		Id:               aws.ToString(instance.InstanceId),
+		Name:             tags["Name"],
+		Tags:             tags,
+		AutoScalingGroup: tags["aws:autoscaling:groupName"],
+	}
+}
+
+// describeInstances returns every instance that hasn't been terminated which
+// matches the filter, or every such instance if values is nil. Filtering, rather
+// than asking for instances by ID, means terminated instances are ignored
+// rather than failing the call.
+func describeInstances(ctx context.Context, ec2Client *ec2.Client, filter string, values []string) ([]ec2Types.Instance, error) {
+	liveStates := ec2Types.Filter{
+		Name:   aws.String("instance-state-name"),
+		Values: []string{"pending", "running", "stopping", "stopped"},
+	}
+	chunks := [][]string{nil}
+	if values != nil {
+		chunks = slices.Collect(slices.Chunk(values, ec2FilterLimit))
+	}
+
+	var instances []ec2Types.Instance
+	for _, chunk := range chunks {
+		filters := []ec2Types.Filter{liveStates}
+		if chunk != nil {
+			filters = append(filters, ec2Types.Filter{Name: &filter, Values: chunk})
+		}
+		paginator := ec2.NewDescribeInstancesPaginator(ec2Client, &ec2.DescribeInstancesInput{Filters: filters})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe instances: %w", err)
+			}
+			for _, reservation := range page.Reservations {
+				instances = append(instances, reservation.Instances...)
+			}
+		}
+	}
+	return instances, nil
+}
+
+func roleHasPolicy(ctx context.Context, iamClient *iam.Client, roleName string, policyArn string) (bool, error) {
+	paginator := iam.NewListAttachedRolePoliciesPaginator(iamClient, &iam.ListAttachedRolePoliciesInput{RoleName: &roleName})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to list policies attached to role %s: %w", roleName, err)
+		}
+		for _, policy := range page.AttachedPolicies {
+			if aws.ToString(policy.PolicyArn) == policyArn {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func getRoleTags(ctx context.Context, iamClient *iam.Client, roleName string) (map[string]string, error) {
+	tags := map[string]string{}
+	paginator := iam.NewListRoleTagsPaginator(iamClient, &iam.ListRoleTagsInput{RoleName: &roleName})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tags for role %s: %w", roleName, err)
+		}
+		for _, tag := range page.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+	return tags, nil
+}
+
+func getInstanceProfile(ctx context.Context, iamClient *iam.Client, name string) (instanceProfile, error) {
+	resp, err := iamClient.GetInstanceProfile(ctx, &iam.GetInstanceProfileInput{InstanceProfileName: &name})
+	if err != nil {
+		return instanceProfile{}, fmt.Errorf("failed to get instance profile %s: %w", name, err)
+	}
+	profile := instanceProfile{Arn: aws.ToString(resp.InstanceProfile.Arn), Name: name}
+	// An instance profile can hold at most one role
+	if len(resp.InstanceProfile.Roles) == 0 {
+		return profile, nil
+	}
+
+	profile.RoleName = aws.ToString(resp.InstanceProfile.Roles[0].RoleName)
+	profile.HasPolicy, err = roleHasPolicy(ctx, iamClient, profile.RoleName, ssmPolicyArn)
+	if err != nil {
+		return instanceProfile{}, err
+	}
+	profile.RoleTags, err = getRoleTags(ctx, iamClient, profile.RoleName)
+	if err != nil {
+		return instanceProfile{}, err
+	}
+	return profile, nil
+}
+
+// inspectInstances describes instances, and the instance profiles they use.
+// If ids is nil, every instance in the region is inspected.
+func inspectInstances(ctx context.Context, ec2Client *ec2.Client, iamClient *iam.Client, ids []string, concurrency int) ([]ec2Instance, error) {
+	described, err := describeInstances(ctx, ec2Client, "instance-id", ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var profileArns []string
+	for _, instance := range described {
+		if instance.IamInstanceProfile != nil && !slices.Contains(profileArns, aws.ToString(instance.IamInstanceProfile.Arn)) {
+			profileArns = append(profileArns, aws.ToString(instance.IamInstanceProfile.Arn))
+		}
+	}
+
+	type profileResult struct {
+		profile instanceProfile
+		err     error
+	}
+	results := common.ParallelMap(ctx, concurrency, profileArns, func(ctx context.Context, arn string) profileResult {
+		profile, err := getInstanceProfile(ctx, iamClient, nameFromArn(arn))
+		return profileResult{profile: profile, err: err}
+	})
+	profiles := map[string]instanceProfile{}
+	var errs []error
+	for i, res := range results {
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		profiles[profileArns[i]] = res.profile
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	var instances []ec2Instance
+	for _, described := range described {
+		instance := toEc2Instance(described)
+		if described.IamInstanceProfile != nil {
+			profile := profiles[aws.ToString(described.IamInstanceProfile.Arn)]
+			instance.Profile = &profile
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// EvaluateSSM_1 finds the instances in a region whose instance profile, if
+// they have one, doesn't let Systems Manager manage them, without relying on
+// Security Hub.
+func EvaluateSSM_1(sess *common.Session, concurrency int) common.Evaluator {
+	return func(ctx context.Context, region string) ([]string, error) {
+		instances, err := inspectInstances(ctx, sess.EC2(region), sess.IAM(), nil, concurrency)
+		if err != nil {
+			return nil, err
+		}
+		var failing []string
+		for _, instance := range instances {
+			if !instance.managed() {
+				failing = append(failing, fmt.Sprintf("arn:aws:ec2:%s:%s:instance/%s", region, sess.AccountId, instance.Id))
+			}
+		}
+		return failing, nil
+	}
+}
@@ -0,0 +1,77 @@
+package ssmutils
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// checkDefaultProfile makes sure attaching the default profile would
+// actually fix an instance, before we plan to attach it to any.
+func checkDefaultProfile(ctx context.Context, sess *common.Session, name string) error {
+	if name == "" {
+		return nil
+	}
+	profile, err := getInstanceProfile(ctx, sess.IAM(), name)
+	if err != nil {
+		return err
+	}
+	if !profile.HasPolicy {
+		return fmt.Errorf("instance profile %s does not have a role with %s, so would not fix any instance", name, ssmPolicyArn)
+	}
+	return nil
+}
+
+// FixSSM_1 lets Systems Manager manage failing instances in every region,
+// by attaching the default instance profile to instances without one, and
+// adding the policy it needs to the roles of those with one. Roles are
+// global, so each is only changed once, however many regions use it.
+func FixSSM_1(ctx context.Context, sess *common.Session, opts common.Options, defaultProfile string) (common.Result, error) {
+	if err := checkDefaultProfile(ctx, sess, defaultProfile); err != nil {
+		return common.Result{}, fmt.Errorf("invalid default instance profile: %w", err)
+	}
+
+	var plannedRoles []string
+	return common.Remediate(ctx, sess, opts, common.Remediation[RegionInstances, ssmFix]{
+		ControlId: "SSM.1",
+		Noun:      "instances",
+		Changes:   "instance profiles and roles",
+		Action:    "attach instance profiles and policies",
+		Evaluate:  EvaluateSSM_1(sess, opts.Concurrency),
+		Find: func(ctx context.Context, f common.FailingResources) (RegionInstances, error) {
+			regionInstances := FindRegionInstances(ctx, sess.EC2(f.Region), sess.IAM(), sess.CloudFormation(f.Region), opts, f, sess.AccountId, defaultProfile)
+			return regionInstances, regionInstances.Err
+		},
+		Plan: func(regionInstances RegionInstances) ([]ssmFix, []common.StackPatch) {
+			var fixes []ssmFix
+			for _, fix := range FindFixes(regionInstances, defaultProfile) {
+				if fix.Kind == fixAttachPolicy {
+					if slices.Contains(plannedRoles, fix.RoleName) {
+						slog.Info("Role is used in several regions, and is already being changed", "role", fix.RoleName, "region", regionInstances.Region)
+						continue
+					}
+					plannedRoles = append(plannedRoles, fix.RoleName)
+				}
+				fixes = append(fixes, fix)
+			}
+			return fixes, regionInstances.StackPatches
+		},
+		Apply: func(ctx context.Context, change common.Change[ssmFix]) error {
+			return applyFix(ctx, sess.EC2(change.Region), sess.IAM(), change.Item)
+		},
+		Audit: func(fix ssmFix) (string, string, map[string]any) {
+			action, parameters := auditAction(fix)
+			return action, fix.Id(), parameters
+		},
+		Verify: func(ctx context.Context, change common.Change[ssmFix]) (bool, error) {
+			return fixApplied(ctx, sess.EC2(change.Region), sess.IAM(), change.Item)
+		},
+		Id: ssmFix.Id,
+		Notes: func(fixed []common.Change[ssmFix]) []string {
+			return []string{"Instances can take up to 30 minutes to register with Systems Manager, if their SSM agent is running and can reach it."}
+		},
+	})
+}
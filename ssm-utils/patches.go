@@ -0,0 +1,24 @@
+package ssmutils
+
+import (
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// SsmPolicyPatch proposes the template change that adds the Systems Manager
+// policy to a stack-managed role.
+func SsmPolicyPatch(template common.StackTemplate, logicalId string) []common.PatchOp {
+	policies, exists := template.Properties(logicalId)["ManagedPolicyArns"].([]any)
+	if !exists {
+		return common.PropertyPatch("ManagedPolicyArns", []any{ssmPolicyArn})(template, logicalId)
+	}
+	for _, policy := range policies {
+		if policy == ssmPolicyArn {
+			return nil
+		}
+	}
+	return []common.PatchOp{{
+		Op:    "add",
+		Path:  common.ResourcePointer(logicalId, "Properties", "ManagedPolicyArns", "-"),
+		Value: ssmPolicyArn,
+	}}
+}
@@ -0,0 +1,45 @@
+package ssmutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func TestSsmPolicyPatch(t *testing.T) {
+	template, err := common.ParseTemplate(`
+Resources:
+  BareRole:
+    Type: AWS::IAM::Role
+  RoleWithoutPolicies:
+    Type: AWS::IAM::Role
+    Properties:
+      Path: /
+  RoleWithPolicies:
+    Type: AWS::IAM::Role
+    Properties:
+      ManagedPolicyArns:
+        - arn:aws:iam::aws:policy/ReadOnlyAccess
+  FixedRole:
+    Type: AWS::IAM::Role
+    Properties:
+      ManagedPolicyArns:
+        - arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore
+`)
+	if err != nil {
+		t.Fatalf("Error parsing template: %v", err)
+	}
+
+	cases := map[string][]common.PatchOp{
+		"BareRole":            {{Op: "add", Path: "/Resources/BareRole/Properties", Value: map[string]any{"ManagedPolicyArns": []any{ssmPolicyArn}}}},
+		"RoleWithoutPolicies": {{Op: "add", Path: "/Resources/RoleWithoutPolicies/Properties/ManagedPolicyArns", Value: []any{ssmPolicyArn}}},
+		"RoleWithPolicies":    {{Op: "add", Path: "/Resources/RoleWithPolicies/Properties/ManagedPolicyArns/-", Value: ssmPolicyArn}},
+		"FixedRole":           nil,
+	}
+	for logicalId, expected := range cases {
+		if got := SsmPolicyPatch(template, logicalId); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Error patching %s. Expected %v, got %v", logicalId, expected, got)
+		}
+	}
+}
@@ -2,216 +2,390 @@ package vpcutils
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
-	"github.com/aws/aws-sdk-go-v2/service/securityhub"
+	"github.com/aws/smithy-go"
 	"github.com/guardian/fsbp-tools/fsbp-fix/common"
 )
 
 type vpcDetails struct {
-	VpcName string
-	VpcId   string
+	VpcName string `json:"name"`
+	VpcId   string `json:"id"`
 }
 
+// securityGroupRule is everything a reviewer needs to know about a rule
+// before it is revoked. A rule has exactly one peer: a CIDR, a prefix list,
+// or another security group.
 type securityGroupRule struct {
-	GroupRuleId string
-	FromPort    int32
-	ToPort      int32
-	IpProtocol  string
-	Direction   string // ingress or egress
+	GroupRuleId string `json:"ruleId"`
+	Direction   string `json:"direction"` // ingress or egress
+	IpProtocol  string `json:"protocol"`  // -1 means all traffic
+	FromPort    *int32 `json:"fromPort,omitempty"`
+	ToPort      *int32 `json:"toPort,omitempty"`
+
+	CidrIpv4               string            `json:"cidrIpv4,omitempty"`
+	CidrIpv6               string            `json:"cidrIpv6,omitempty"`
+	PrefixListId           string            `json:"prefixListId,omitempty"`
+	ReferencedGroupId      string            `json:"referencedGroupId,omitempty"`
+	ReferencedGroupAccount string            `json:"referencedGroupAccount,omitempty"`
+	Description            string            `json:"description,omitempty"`
+	Tags                   map[string]string `json:"tags,omitempty"`
 }
 
 type ruleDetails struct {
-	SecurityGroup string
-	VpcDetails    vpcDetails
-	Rule          securityGroupRule
+	SecurityGroup string            `json:"securityGroup"`
+	VpcDetails    vpcDetails        `json:"vpc"`
+	Rule          securityGroupRule `json:"rule"`
 }
 
 type SecurityGroupRuleDetails struct {
-	Region string
-	Groups []ruleDetails
-}
-
-func getSecurityGroupRules(ctx context.Context, ec2Client *ec2.Client, groupId string) ([]securityGroupRule, error) {
-	fieldName := "group-id"
-	rules, err := ec2Client.DescribeSecurityGroupRules(ctx, &ec2.DescribeSecurityGroupRulesInput{
-		//No pagination needed. If MaxResults is not specified, then all items are returned
-		Filters: []types.Filter{
-			{
-				Name:   &fieldName,
-				Values: []string{groupId},
-			},
-		},
-	})
-	if err != nil {
-		return nil, err
-	}
+	Region        string
+	Groups        []ruleDetails
+	StackManaged  []common.StackResources // Unused groups left alone to avoid stack drift
+	ManagedInCode []common.ManagedBy      // As above, but managed by other IaC tools
+	StackPatches  []common.StackPatch
+}
 
-	res := []securityGroupRule{}
-	for _, rule := range rules.SecurityGroupRules {
-		var direction string
+// ec2FilterLimit is the most values EC2 accepts in a single filter.
+const ec2FilterLimit = 200
 
-		if *rule.IsEgress {
-			direction = "egress"
-		} else {
-			direction = "ingress"
+func toSecurityGroupRule(rule types.SecurityGroupRule) securityGroupRule {
+	direction := "ingress"
+	if aws.ToBool(rule.IsEgress) {
+		direction = "egress"
+	}
+	res := securityGroupRule{
+		GroupRuleId:  aws.ToString(rule.SecurityGroupRuleId),
+		Direction:    direction,
+		IpProtocol:   aws.ToString(rule.IpProtocol),
+		FromPort:     rule.FromPort,
+		ToPort:       rule.ToPort,
+		CidrIpv4:     aws.ToString(rule.CidrIpv4),
+		CidrIpv6:     aws.ToString(rule.CidrIpv6),
+		PrefixListId: aws.ToString(rule.PrefixListId),
+		Description:  aws.ToString(rule.Description),
+	}
+	if ref := rule.ReferencedGroupInfo; ref != nil {
+		res.ReferencedGroupId = aws.ToString(ref.GroupId)
+		res.ReferencedGroupAccount = aws.ToString(ref.UserId)
+	}
+	if len(rule.Tags) > 0 {
+		res.Tags = map[string]string{}
+		for _, tag := range rule.Tags {
+			res.Tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
 		}
-		res = append(res, securityGroupRule{
-			GroupRuleId: *rule.SecurityGroupRuleId,
-			FromPort:    *rule.FromPort,
-			ToPort:      *rule.ToPort,
-			IpProtocol:  *rule.IpProtocol,
-			Direction:   direction,
+	}
+	return res
+}
+
+// getSecurityGroupRules describes the rules of every group, in as few calls
+// as possible, keyed by group ID.
+func getSecurityGroupRules(ctx context.Context, ec2Client *ec2.Client, groupIds []string) (map[string][]securityGroupRule, error) {
+	rules := map[string][]securityGroupRule{}
+	for chunk := range slices.Chunk(groupIds, ec2FilterLimit) {
+		paginator := ec2.NewDescribeSecurityGroupRulesPaginator(ec2Client, &ec2.DescribeSecurityGroupRulesInput{
+			Filters:    []types.Filter{{Name: aws.String("group-id"), Values: chunk}},
+			MaxResults: aws.Int32(1000),
 		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe security group rules: %w", err)
+			}
+			for _, rule := range page.SecurityGroupRules {
+				rules[*rule.GroupId] = append(rules[*rule.GroupId], toSecurityGroupRule(rule))
+			}
+		}
 	}
-	return res, nil
+	return rules, nil
 }
 
-func getVpcDetails(ctx context.Context, ec2Client *ec2.Client, groupId string) (vpcDetails, error) {
-	groupDescriptions, err := ec2Client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
-		GroupIds: []string{groupId},
-	})
-	if err != nil {
-		return vpcDetails{}, err
+// getVpcDetails describes every VPC in one paginated call, keyed by VPC ID.
+func getVpcDetails(ctx context.Context, ec2Client *ec2.Client, vpcIds []string) (map[string]vpcDetails, error) {
+	vpcs := map[string]vpcDetails{}
+	if len(vpcIds) == 0 {
+		return vpcs, nil
+	}
+	paginator := ec2.NewDescribeVpcsPaginator(ec2Client, &ec2.DescribeVpcsInput{VpcIds: vpcIds})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe VPCs: %w", err)
+		}
+		for _, vpc := range page.Vpcs {
+			vpcs[*vpc.VpcId] = vpcDetails{
+				VpcName: FindTag(vpc.Tags, "Name", "unknown"),
+				VpcId:   *vpc.VpcId,
+			}
+		}
 	}
+	return vpcs, nil
+}
 
-	res := []vpcDetails{}
-	for _, group := range groupDescriptions.SecurityGroups {
-		vpcs, err := ec2Client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{
-			VpcIds: []string{*group.VpcId},
+func describeSecurityGroups(ctx context.Context, ec2Client *ec2.Client, groupIds []string) ([]types.SecurityGroup, error) {
+	var groups []types.SecurityGroup
+	for chunk := range slices.Chunk(groupIds, ec2FilterLimit) {
+		paginator := ec2.NewDescribeSecurityGroupsPaginator(ec2Client, &ec2.DescribeSecurityGroupsInput{
+			Filters: []types.Filter{{Name: aws.String("group-id"), Values: chunk}},
 		})
-		if err != nil {
-			return vpcDetails{}, err
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe security groups: %w", err)
+			}
+			groups = append(groups, page.SecurityGroups...)
 		}
-		for _, vpc := range vpcs.Vpcs {
-			name := FindTag(vpc.Tags, "Name", "unknown")
-			res = append(res, vpcDetails{
-				VpcName: name,
-				VpcId:   *group.VpcId,
+	}
+	return groups, nil
+}
+
+// joinRuleDetails combines groups, their rules and their VPCs, keeping the
+// order of groups.
+func joinRuleDetails(groups []types.SecurityGroup, rules map[string][]securityGroupRule, vpcs map[string]vpcDetails) []ruleDetails {
+	var details []ruleDetails
+	for _, group := range groups {
+		vpc, ok := vpcs[aws.ToString(group.VpcId)]
+		if !ok {
+			vpc = vpcDetails{VpcName: "unknown", VpcId: aws.ToString(group.VpcId)}
+		}
+		for _, rule := range rules[*group.GroupId] {
+			details = append(details, ruleDetails{
+				SecurityGroup: *group.GroupId,
+				VpcDetails:    vpc,
+				Rule:          rule,
 			})
 		}
 	}
-	return res[0], nil // A security group cannot be associated with multiple VPCs.
+	return details
 }
 
-func getSecurityGroupRuleDetails(ctx context.Context, ec2Client *ec2.Client, groupId string, region string) (SecurityGroupRuleDetails, error) {
-	rules, err := getSecurityGroupRules(ctx, ec2Client, groupId)
-	if err != nil {
-		return SecurityGroupRuleDetails{}, err
-	}
-	vpcDetails, err := getVpcDetails(ctx, ec2Client, groupId)
-	if err != nil {
-		return SecurityGroupRuleDetails{}, err
+func getSecurityGroupRuleDetails(ctx context.Context, ec2Client *ec2.Client, groups []types.SecurityGroup) ([]ruleDetails, error) {
+	var groupIds, vpcIds []string
+	for _, group := range groups {
+		groupIds = append(groupIds, *group.GroupId)
+		if group.VpcId != nil && !slices.Contains(vpcIds, *group.VpcId) {
+			vpcIds = append(vpcIds, *group.VpcId)
+		}
 	}
 
-	res := SecurityGroupRuleDetails{
-		Region: region,
-		Groups: []ruleDetails{},
+	rules, err := getSecurityGroupRules(ctx, ec2Client, groupIds)
+	if err != nil {
+		return nil, err
 	}
-	for _, rule := range rules {
-		res.Groups = append(res.Groups, ruleDetails{
-			SecurityGroup: groupId,
-			VpcDetails:    vpcDetails,
-			Rule:          rule,
-		})
+	vpcs, err := getVpcDetails(ctx, ec2Client, vpcIds)
+	if err != nil {
+		return nil, err
 	}
-	return res, nil
+	return joinRuleDetails(groups, rules, vpcs), nil
 }
 
+// findUnusedSecurityGroups returns the groups not attached to any network
+// interface, looking up only the interfaces using the given groups.
 func findUnusedSecurityGroups(ctx context.Context, ec2Client *ec2.Client, sgIds []string) ([]string, error) {
-
-	allNetworkInterfaces := []types.NetworkInterface{}
 	securityGroupsInNetworkInterfaces := []string{}
-	maxInterfaceResults := int32(1000) // Unlikely we will ever have more than 1000 network interfaces in one region
-
-	paginator := ec2.NewDescribeNetworkInterfacesPaginator(ec2Client, &ec2.DescribeNetworkInterfacesInput{
-		MaxResults: &maxInterfaceResults,
-	})
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to describe network interfaces: %w", err)
+	for chunk := range slices.Chunk(sgIds, ec2FilterLimit) {
+		paginator := ec2.NewDescribeNetworkInterfacesPaginator(ec2Client, &ec2.DescribeNetworkInterfacesInput{
+			Filters:    []types.Filter{{Name: aws.String("group-id"), Values: chunk}},
+			MaxResults: aws.Int32(1000),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe network interfaces: %w", err)
+			}
+			for _, networkInterface := range page.NetworkInterfaces {
+				for _, group := range networkInterface.Groups {
+					securityGroupsInNetworkInterfaces = append(securityGroupsInNetworkInterfaces, *group.GroupId)
+				}
+			}
 		}
-		allNetworkInterfaces = append(allNetworkInterfaces, page.NetworkInterfaces...)
 	}
 
-	for _, networkInterface := range allNetworkInterfaces {
-		for _, group := range networkInterface.Groups {
-			securityGroupsInNetworkInterfaces = append(securityGroupsInNetworkInterfaces, *group.GroupId)
+	return common.Without(sgIds, securityGroupsInNetworkInterfaces), nil
+}
+
+func findGroupsManagedInCode(detectors common.ManagedByDetectors, groups []types.SecurityGroup) []common.ManagedBy {
+	var resources []common.ManagedResource
+	for _, group := range groups {
+		tags := map[string]string{}
+		for _, tag := range group.Tags {
+			tags[*tag.Key] = *tag.Value
 		}
+		resources = append(resources, common.ManagedResource{
+			Id:   *group.GroupId,
+			Type: common.ResourceTypeSecurityGroup,
+			Tags: tags,
+		})
 	}
-
-	return common.Complement(sgIds, securityGroupsInNetworkInterfaces), nil
+	return detectors.Detect(resources)
 }
 
-func FindUnusedSecurityGroupRules(ctx context.Context, ec2Client *ec2.Client, securityHubClient *securityhub.Client, accountId string, region string) (SecurityGroupRuleDetails, error) {
-	findings, err := common.ReturnFindings(ctx, securityHubClient, "EC2.2", 100, accountId, region)
+func FindUnusedSecurityGroupRules(ctx context.Context, ec2Client *ec2.Client, cfnClient *cloudformation.Client, opts common.Options, failing common.FailingResources, accountId string) (SecurityGroupRuleDetails, error) {
+	region := failing.Region
+	securityGroups := []string{}
+	for _, arn := range failing.Arns {
+		securityGroups = append(securityGroups, IdFromArn(arn))
+	}
+
+	unusedSecurityGroups, err := findUnusedSecurityGroups(ctx, ec2Client, securityGroups)
 	if err != nil {
 		return SecurityGroupRuleDetails{}, err
 	}
 
-	securityGroups := []string{}
+	stackManaged, err := opts.StackLookup.FindResourcesInStacks(ctx, cfnClient, accountId, region, common.ResourceTypeSecurityGroup, unusedSecurityGroups, opts.Concurrency)
+	if err != nil {
+		return SecurityGroupRuleDetails{}, fmt.Errorf("could not determine which security groups are in CloudFormation stacks: %w", err)
+	}
+	unusedSecurityGroups = common.Without(unusedSecurityGroups, common.ResourcesInStacks(stackManaged))
 
-	for _, finding := range findings {
-		for _, resource := range finding.Resources {
-			sgId := IdFromArn(*resource.Id)
-			securityGroups = append(securityGroups, sgId)
-		}
+	groups, err := describeSecurityGroups(ctx, ec2Client, unusedSecurityGroups)
+	if err != nil {
+		return SecurityGroupRuleDetails{}, err
 	}
+	managedInCode := findGroupsManagedInCode(opts.Detectors, groups)
+	groups = slices.DeleteFunc(groups, func(group types.SecurityGroup) bool {
+		return slices.Contains(common.ManagedIds(managedInCode), *group.GroupId)
+	})
 
-	unusedSecurityGroups, err := findUnusedSecurityGroups(ctx, ec2Client, securityGroups)
+	rules, err := getSecurityGroupRuleDetails(ctx, ec2Client, groups)
 	if err != nil {
 		return SecurityGroupRuleDetails{}, err
 	}
-	securityGroupRuleDetails := SecurityGroupRuleDetails{}
 
-	for _, sg := range unusedSecurityGroups {
-		rules, err := getSecurityGroupRuleDetails(ctx, ec2Client, sg, region)
-		if err != nil {
-			return SecurityGroupRuleDetails{}, err
-		}
-		securityGroupRuleDetails.Groups = append(securityGroupRuleDetails.Groups, rules.Groups...)
+	securityGroupRuleDetails := SecurityGroupRuleDetails{
+		Groups:        rules,
+		StackManaged:  stackManaged,
+		ManagedInCode: managedInCode,
+		StackPatches:  common.BuildStackPatches(ctx, cfnClient, region, stackManaged, RemoveRulesPatch, opts.Concurrency),
 	}
 
 	securityGroupRuleDetails.Region = region //Only set the region once we've collected all the rules
 	return securityGroupRuleDetails, nil
 }
 
-func deleteSecurityGroupRule(ctx context.Context, ec2Client *ec2.Client, rule ruleDetails) error {
+// ruleBatch is the rules to delete from one security group in one direction,
+// which can be revoked in a single call.
+type ruleBatch struct {
+	SecurityGroup string
+	Direction     string
+	Rules         []ruleDetails
+}
 
-	if rule.Rule.Direction == "egress" {
-		_, err := ec2Client.RevokeSecurityGroupEgress(ctx, &ec2.RevokeSecurityGroupEgressInput{
-			GroupId:              &rule.SecurityGroup,
-			SecurityGroupRuleIds: []string{rule.Rule.GroupRuleId},
-		})
-		if err != nil {
-			return err
+func (b ruleBatch) ruleIds() []string {
+	var ids []string
+	for _, rule := range b.Rules {
+		ids = append(ids, rule.Rule.GroupRuleId)
+	}
+	return ids
+}
+
+// batchRules groups rules by region, security group and direction, in the
+// order each group first appears.
+func batchRules(changes []common.Change[ruleDetails]) []common.Change[ruleBatch] {
+	var batches []common.Change[ruleBatch]
+	index := map[[3]string]int{}
+	for _, change := range changes {
+		key := [3]string{change.Region, change.Item.SecurityGroup, change.Item.Rule.Direction}
+		i, ok := index[key]
+		if !ok {
+			i = len(batches)
+			index[key] = i
+			batches = append(batches, common.Change[ruleBatch]{
+				Region: change.Region,
+				Item:   ruleBatch{SecurityGroup: change.Item.SecurityGroup, Direction: change.Item.Rule.Direction},
+			})
 		}
+		batches[i].Item.Rules = append(batches[i].Item.Rules, change.Item)
+	}
+	return batches
+}
+
+// batchCanary batches rules for a roll-out whose canary counts rules, like
+// the change limits do. The canary's rules are batched apart from the rest,
+// and the canary returned counts the batches they make up.
+func batchCanary(c common.Canary, changes []common.Change[ruleDetails]) (common.Canary, []common.Change[ruleBatch]) {
+	first := common.CanaryChanges(c, changes)
+	canary := batchRules(first)
+	rest := batchRules(changes[len(first):])
+	return common.Canary{Size: len(canary), Wait: c.Wait}, append(canary, rest...)
+}
+
+func revokeAction(direction string) string {
+	if direction == "egress" {
+		return "ec2:RevokeSecurityGroupEgress"
+	}
+	return "ec2:RevokeSecurityGroupIngress"
+}
+
+func revokeSecurityGroupRules(ctx context.Context, ec2Client *ec2.Client, audit *common.AuditLog, region string, groupId string, direction string, ruleIds []string) error {
+	var err error
+	if direction == "egress" {
+		_, err = ec2Client.RevokeSecurityGroupEgress(ctx, &ec2.RevokeSecurityGroupEgressInput{
+			GroupId:              &groupId,
+			SecurityGroupRuleIds: ruleIds,
+		})
 	} else {
-		_, err := ec2Client.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
-			GroupId:              &rule.SecurityGroup,
-			SecurityGroupRuleIds: []string{rule.Rule.GroupRuleId},
+		_, err = ec2Client.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+			GroupId:              &groupId,
+			SecurityGroupRuleIds: ruleIds,
 		})
-		if err != nil {
-			return err
-		}
 	}
-	fmt.Printf("Deleted rule %s from security group %s\n", rule.Rule.GroupRuleId, rule.SecurityGroup)
-	return nil
-
+	audit.Record(region, revokeAction(direction), groupId, map[string]any{
+		"GroupId":              groupId,
+		"SecurityGroupRuleIds": ruleIds,
+	}, err)
+	return err
 }
 
-func DeleteSecurityGroupRules(ctx context.Context, ec2Client *ec2.Client, securityGroupRuleDetails SecurityGroupRuleDetails, failures *[]string) {
+// deleteRuleBatch revokes a batch of rules in one call. If that fails, it
+// falls back to one call per rule, so one bad rule doesn't stop the others
+// being deleted. It returns the rules deleted, and an error for each failure.
+func deleteRuleBatch(ctx context.Context, ec2Client *ec2.Client, audit *common.AuditLog, batch common.Change[ruleBatch]) ([]ruleDetails, []error) {
+	group := batch.Item
+	err := revokeSecurityGroupRules(ctx, ec2Client, audit, batch.Region, group.SecurityGroup, group.Direction, group.ruleIds())
+	if err == nil {
+		slog.Info("Deleted rules", "rules", group.ruleIds(), "securityGroup", group.SecurityGroup, "direction", group.Direction)
+		return group.Rules, nil
+	}
+	if len(group.Rules) > 1 {
+		slog.Warn("Failed to delete rules together. Trying one at a time", "securityGroup", group.SecurityGroup, "direction", group.Direction, "error", err)
+	}
 
-	fmt.Println("Deleting...")
-	for _, group := range securityGroupRuleDetails.Groups {
-		err := deleteSecurityGroupRule(ctx, ec2Client, group)
+	var deleted []ruleDetails
+	var errs []error
+	for _, rule := range group.Rules {
+		if len(group.Rules) > 1 {
+			err = revokeSecurityGroupRules(ctx, ec2Client, audit, batch.Region, group.SecurityGroup, group.Direction, []string{rule.Rule.GroupRuleId})
+		}
 		if err != nil {
-			fmt.Printf("Error deleting %v\n", group.Rule.GroupRuleId)
-			fmt.Println(err)
-			*failures = append(*failures, group.Rule.GroupRuleId)
+			slog.Error("Error deleting rule", "rule", rule.Rule.GroupRuleId, "securityGroup", rule.SecurityGroup, "error", err)
+			errs = append(errs, fmt.Errorf("failed to delete rule %s from security group %s: %w", rule.Rule.GroupRuleId, rule.SecurityGroup, err))
+			continue
 		}
+		slog.Info("Deleted rule", "rule", rule.Rule.GroupRuleId, "securityGroup", rule.SecurityGroup)
+		deleted = append(deleted, rule)
+	}
+	return deleted, errs
+}
+
+// ruleIsDeleted re-reads a rule, to check a deletion has taken effect.
+func ruleIsDeleted(ctx context.Context, ec2Client *ec2.Client, ruleId string) (bool, error) {
+	resp, err := ec2Client.DescribeSecurityGroupRules(ctx, &ec2.DescribeSecurityGroupRulesInput{
+		SecurityGroupRuleIds: []string{ruleId},
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidSecurityGroupRuleId.NotFound" {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
 	}
+	return len(resp.SecurityGroupRules) == 0, nil
 }
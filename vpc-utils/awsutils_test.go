@@ -0,0 +1,98 @@
+package vpcutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func ruleChange(region string, group string, direction string, id string) common.Change[ruleDetails] {
+	return common.Change[ruleDetails]{
+		Region: region,
+		Item: ruleDetails{
+			SecurityGroup: group,
+			Rule:          securityGroupRule{GroupRuleId: id, Direction: direction},
+		},
+	}
+}
+
+func TestBatchRules(t *testing.T) {
+	batches := batchRules([]common.Change[ruleDetails]{
+		ruleChange("eu-west-1", "sg-1", "ingress", "sgr-1"),
+		ruleChange("eu-west-1", "sg-1", "egress", "sgr-2"),
+		ruleChange("eu-west-1", "sg-1", "ingress", "sgr-3"),
+		ruleChange("eu-west-1", "sg-2", "ingress", "sgr-4"),
+		ruleChange("us-east-1", "sg-1", "ingress", "sgr-5"),
+	})
+
+	type batch struct {
+		region, group, direction string
+		ids                      []string
+	}
+	var got []batch
+	for _, b := range batches {
+		got = append(got, batch{b.Region, b.Item.SecurityGroup, b.Item.Direction, b.Item.ruleIds()})
+	}
+	expected := []batch{
+		{"eu-west-1", "sg-1", "ingress", []string{"sgr-1", "sgr-3"}},
+		{"eu-west-1", "sg-1", "egress", []string{"sgr-2"}},
+		{"eu-west-1", "sg-2", "ingress", []string{"sgr-4"}},
+		{"us-east-1", "sg-1", "ingress", []string{"sgr-5"}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error batching rules. Expected %v, got %v", expected, got)
+	}
+}
+
+func TestBatchCanary(t *testing.T) {
+	changes := []common.Change[ruleDetails]{
+		ruleChange("eu-west-1", "sg-1", "ingress", "sgr-1"),
+		ruleChange("eu-west-1", "sg-1", "ingress", "sgr-2"),
+		ruleChange("eu-west-1", "sg-1", "ingress", "sgr-3"),
+		ruleChange("eu-west-1", "sg-2", "ingress", "sgr-4"),
+	}
+	cases := map[int]struct {
+		canary int
+		ids    [][]string
+	}{
+		0: {0, [][]string{{"sgr-1", "sgr-2", "sgr-3"}, {"sgr-4"}}},
+		1: {1, [][]string{{"sgr-1"}, {"sgr-2", "sgr-3"}, {"sgr-4"}}},
+		4: {2, [][]string{{"sgr-1", "sgr-2", "sgr-3"}, {"sgr-4"}}},
+		9: {2, [][]string{{"sgr-1", "sgr-2", "sgr-3"}, {"sgr-4"}}},
+	}
+	for size, expected := range cases {
+		canary, batches := batchCanary(common.Canary{Size: size}, changes)
+		var ids [][]string
+		for _, batch := range batches {
+			ids = append(ids, batch.Item.ruleIds())
+		}
+		if canary.Size != expected.canary || !reflect.DeepEqual(ids, expected.ids) {
+			t.Errorf("Error batching a canary of %d rules. Expected %d batches in the canary of %v, got %d of %v", size, expected.canary, expected.ids, canary.Size, ids)
+		}
+	}
+}
+
+func TestJoinRuleDetails(t *testing.T) {
+	groups := []types.SecurityGroup{
+		{GroupId: aws.String("sg-2"), VpcId: aws.String("vpc-2")},
+		{GroupId: aws.String("sg-1"), VpcId: aws.String("vpc-1")},
+		{GroupId: aws.String("sg-3"), VpcId: aws.String("vpc-1")},
+	}
+	rules := map[string][]securityGroupRule{
+		"sg-1": {{GroupRuleId: "sgr-1"}, {GroupRuleId: "sgr-2"}},
+		"sg-2": {{GroupRuleId: "sgr-3"}},
+	}
+	vpcs := map[string]vpcDetails{"vpc-1": {VpcName: "main", VpcId: "vpc-1"}}
+
+	var got []string
+	for _, detail := range joinRuleDetails(groups, rules, vpcs) {
+		got = append(got, detail.SecurityGroup+"/"+detail.Rule.GroupRuleId+"/"+detail.VpcDetails.VpcName)
+	}
+	expected := []string{"sg-2/sgr-3/unknown", "sg-1/sgr-1/main", "sg-1/sgr-2/main"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Error joining rule details. Expected %v, got %v", expected, got)
+	}
+}
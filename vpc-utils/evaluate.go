@@ -0,0 +1,44 @@
+package vpcutils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func securityGroupArn(region string, group types.SecurityGroup) string {
+	return fmt.Sprintf("arn:aws:ec2:%s:%s:security-group/%s", region, aws.ToString(group.OwnerId), aws.ToString(group.GroupId))
+}
+
+// allowsTraffic reports whether a default security group fails EC2.2, which
+// needs it to have no rules at all.
+func allowsTraffic(group types.SecurityGroup) bool {
+	return len(group.IpPermissions) > 0 || len(group.IpPermissionsEgress) > 0
+}
+
+// EvaluateEc2_2 finds the default security groups in a region which have any
+// rules, without relying on Security Hub.
+func EvaluateEc2_2(sess *common.Session) common.Evaluator {
+	return func(ctx context.Context, region string) ([]string, error) {
+		var failing []string
+		paginator := ec2.NewDescribeSecurityGroupsPaginator(sess.EC2(region), &ec2.DescribeSecurityGroupsInput{
+			Filters: []types.Filter{{Name: aws.String("group-name"), Values: []string{"default"}}},
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe default security groups: %w", err)
+			}
+			for _, group := range page.SecurityGroups {
+				if allowsTraffic(group) {
+					failing = append(failing, securityGroupArn(region, group))
+				}
+			}
+		}
+		return failing, nil
+	}
+}
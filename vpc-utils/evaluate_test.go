@@ -0,0 +1,25 @@
+package vpcutils
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestSecurityGroupArnMatchesSecurityHub(t *testing.T) {
+	group := types.SecurityGroup{OwnerId: aws.String("123456789012"), GroupId: aws.String("sg-0123")}
+	arn := securityGroupArn("eu-west-1", group)
+	if arn != "arn:aws:ec2:eu-west-1:123456789012:security-group/sg-0123" || IdFromArn(arn) != "sg-0123" {
+		t.Errorf("Error building security group ARN. Got %s", arn)
+	}
+}
+
+func TestAllowsTraffic(t *testing.T) {
+	if allowsTraffic(types.SecurityGroup{}) {
+		t.Errorf("Error evaluating a group without rules. Expected it to pass")
+	}
+	if !allowsTraffic(types.SecurityGroup{IpPermissionsEgress: []types.IpPermission{{IpProtocol: aws.String("-1")}}}) {
+		t.Errorf("Error evaluating a group with an egress rule. Expected it to fail")
+	}
+}
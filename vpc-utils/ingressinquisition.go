@@ -2,86 +2,152 @@ package vpcutils
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
-	"sync"
-	"text/tabwriter"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
-	"github.com/aws/aws-sdk-go-v2/service/securityhub"
 	"github.com/guardian/fsbp-tools/fsbp-fix/common"
 )
 
-func findEc2_2(ch chan<- SecurityGroupRuleDetails, ctx context.Context, cfg aws.Config, ec2Client *ec2.Client, accountId string) {
-	securityHubClient := securityhub.NewFromConfig(cfg)
-	res, err := FindUnusedSecurityGroupRules(ctx, ec2Client, securityHubClient, accountId, cfg.Region)
-	common.WarnOnError(err, "Failed to find unused security group rules in region "+cfg.Region)
-	if len(res.Groups) > 0 {
-		ch <- res
-	} else {
-		fmt.Printf("No unused security group rules found in %s\n", cfg.Region)
+// FindUnusedSgRules finds the rules to delete in every region, and how many
+// failing groups -limit held back. It returns the errors from any regions it
+// could not process alongside the rest.
+func FindUnusedSgRules(ctx context.Context, sess *common.Session, opts common.Options) ([]SecurityGroupRuleDetails, int, error) {
+	slog.Info("Finding unused security group rules. Please be patient", "source", opts.Source, "regions", len(sess.Regions))
+
+	type regionResult struct {
+		details SecurityGroupRuleDetails
+		err     error
 	}
-}
+	failing := common.ParallelMap(ctx, opts.Concurrency, sess.Regions, func(ctx context.Context, region string) common.FailingResources {
+		return opts.FindFailing(ctx, sess, "EC2.2", EvaluateEc2_2(sess), region)
+	})
+	failing = common.LimitFailingResources(failing, opts.Limit)
+	common.PrintFailingResources(failing, "security groups")
 
-func deleteRulesForRegion(ctx context.Context, unusedSecurityGroups SecurityGroupRuleDetails, execute bool, profile string, failures *[]string) {
-	if execute && common.UserConfirmation() {
-		cfg, err := common.Auth(ctx, profile, unusedSecurityGroups.Region)
-		if err != nil {
-			fmt.Printf("Failed to authenticate with AWS for region %s: %v\n", unusedSecurityGroups.Region, err)
-			for _, sg := range unusedSecurityGroups.Groups {
-				*failures = append(*failures, sg.Rule.GroupRuleId)
-			}
-			return
+	results := common.ParallelMap(ctx, opts.Concurrency, failing, func(ctx context.Context, f common.FailingResources) regionResult {
+		if f.Err != nil {
+			return regionResult{err: f.Err}
 		}
+		details, err := FindUnusedSecurityGroupRules(ctx, sess.EC2(f.Region), sess.CloudFormation(f.Region), opts, f, sess.AccountId)
+		return regionResult{details: details, err: err}
+	})
 
-		ec2Client := ec2.NewFromConfig(cfg)
-		DeleteSecurityGroupRules(ctx, ec2Client, unusedSecurityGroups, failures)
-	} else {
-		fmt.Println("Skipping deletion.")
+	unusedSgRules := []SecurityGroupRuleDetails{}
+	var errs []error
+	for i, res := range results {
+		region := sess.Regions[i]
+		if skipped := failing[i].Skipped(); skipped > 0 {
+			slog.Info("Not processing more failing security groups, due to -limit", "region", region, "skipped", skipped)
+		}
+		if res.err != nil {
+			slog.Error("Failed to find unused security group rules", "region", region, "error", res.err)
+			errs = append(errs, fmt.Errorf("%s: %w", region, res.err))
+			continue
+		}
+		for _, stack := range res.details.StackManaged {
+			fmt.Fprintf(common.Out, "%s - Skipping security groups in stack %s: %v\n", region, stack.StackName, stack.PhysicalIds)
+		}
+		for _, managed := range res.details.ManagedInCode {
+			fmt.Fprintf(common.Out, "%s - Managed in code, please fix there: %s (%s)\n", region, managed.Id, managed.Reason)
+		}
+		opts.ReportStackPatches(res.details.StackPatches)
+		if len(res.details.Groups) > 0 {
+			unusedSgRules = append(unusedSgRules, res.details)
+		} else {
+			fmt.Fprintf(common.Out, "No unused security group rules found in %s\n", region)
+		}
 	}
+	return unusedSgRules, common.TotalSkipped(failing), errors.Join(errs...)
 }
 
-func FindUnusedSgRules(ctx context.Context, accountDetails common.AccountDetails, ch chan<- SecurityGroupRuleDetails, wg *sync.WaitGroup, profile string) {
-	fmt.Println("Finding unused security group rules. Please be patient.")
-	for _, r := range accountDetails.Regions {
-		cfg, err := common.Auth(ctx, profile, r)
-		if err != nil {
-			fmt.Printf("Failed to authenticate with AWS for region %s: %v\n", r, err)
-			continue
+// FixEc2_2 deletes the unused rules. Rules that fail are recorded in the
+// result, and the groups -limit skipped count as remaining. An error means
+// the run itself could not complete.
+//
+// Unlike the other controls, this doesn't use common.Remediate, which makes
+// one change per call. Its limits and canary count rules, but it revokes each
+// security group's rules in one call per direction.
+func FixEc2_2(ctx context.Context, sess *common.Session, opts common.Options, unusedSgRules []SecurityGroupRuleDetails, skipped int, output string) (common.Result, error) {
+	result := common.Result{}
+	var changes []common.Change[ruleDetails]
+
+	if output == OutputJson {
+		if err := printRuleJson(os.Stdout, unusedSgRules); err != nil {
+			return result, fmt.Errorf("failed to print unused rules: %w", err)
 		}
-		ec2Client := ec2.NewFromConfig(cfg)
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			findEc2_2(ch, ctx, cfg, ec2Client, accountDetails.AccountId)
-		}()
 	}
+	for _, details := range unusedSgRules {
+		if output == OutputTable {
+			fmt.Printf("%s - Unused security group rules\n\n", details.Region)
+			if err := printRuleTable(os.Stdout, details.Groups); err != nil {
+				return result, fmt.Errorf("failed to print unused rules: %w", err)
+			}
+			fmt.Println("----------------------------------------------------")
+		}
 
-}
+		for _, rule := range details.Groups {
+			changes = append(changes, common.Change[ruleDetails]{Region: details.Region, Item: rule})
+		}
+	}
 
-func FixEc2_2(ctx context.Context, ch <-chan SecurityGroupRuleDetails, execute *bool, profile *string) {
-	failures := []string{}
+	changes, deferred := common.ApplyLimits(opts.Limits, changes)
+	if len(deferred) > 0 {
+		slog.Warn("Deferring rules to a later run, to stay within the change limits", "deferred", len(deferred))
+	}
 
-	for result := range ch {
-		// Print out results as a table
-		fmt.Printf("%s - Unused security group rules\n\n", result.Region)
-		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
-		fmt.Fprintln(w, "Security Group\tVPC Name\tVPC ID\tRule Id\tFrom Port\tTo Port\tIP Protocol\tDirection")
-		for _, sg := range result.Groups {
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", sg.SecurityGroup, sg.VpcDetails.VpcName, sg.VpcDetails.VpcId, sg.Rule.GroupRuleId, sg.Rule.FromPort, sg.Rule.ToPort, sg.Rule.IpProtocol, sg.Rule.Direction)
-		}
+	result.Remaining = len(changes) + len(deferred) + skipped
 
-		err := w.Flush()
-		common.ExitOnError(err, "")
+	if !opts.Execute || len(changes) == 0 {
+		fmt.Fprintln(common.Out, "Skipping deletion.")
+		return result, nil
+	}
 
-		deleteRulesForRegion(ctx, result, *execute, *profile, &failures) // Set execute to false for dry run
-		fmt.Println("----------------------------------------------------")
+	common.PlanChanges(changes).Print(sess.AccountId, "security group rules")
+	confirmed, err := opts.Confirm.Confirm(sess.AccountId)
+	if err != nil {
+		return result, fmt.Errorf("could not confirm changes: %w", err)
+	}
+	if !confirmed {
+		fmt.Fprintln(common.Out, "Skipping deletion.")
+		return result, nil
 	}
+
+	failures := []string{}
+	var deleted []common.Change[ruleDetails]
+	canary, batches := batchCanary(opts.Canary, changes)
+	slog.Info("Deleting rules", "rules", len(changes), "batches", len(batches))
+	err = common.RollOut(ctx, canary, batches, opts.HealthChecks(sess, common.Regions(batches)), func(ctx context.Context, batch common.Change[ruleBatch]) {
+		rules, errs := deleteRuleBatch(ctx, sess.EC2(batch.Region), opts.Audit, batch)
+		for _, rule := range rules {
+			deleted = append(deleted, common.Change[ruleDetails]{Region: batch.Region, Item: rule})
+		}
+		for _, err := range errs {
+			result.Fail(err)
+		}
+		deletedBatch := ruleBatch{Rules: rules}
+		failures = append(failures, common.Without(batch.Item.ruleIds(), deletedBatch.ruleIds())...)
+	})
+	result.Remaining -= len(deleted)
+	// If the canary failed, the remaining rules were not attempted
+	result.Fail(err)
 	if len(failures) > 0 {
-		fmt.Println("Failed to delete the following rules:")
+		fmt.Fprintln(common.Out, "Failed to delete the following rules:")
 		for _, failure := range failures {
-			fmt.Println(failure)
+			fmt.Fprintln(common.Out, failure)
 		}
 	}
+
+	slog.Info("Verifying rules are deleted", "rules", len(deleted))
+	verifications := common.VerifyChanges(ctx, common.DefaultBackoff, opts.Concurrency, deleted, func(rule ruleDetails) string { return rule.Rule.GroupRuleId },
+		func(change common.Change[ruleDetails]) common.Check {
+			return func(ctx context.Context) (bool, error) {
+				return ruleIsDeleted(ctx, sess.EC2(change.Region), change.Item.Rule.GroupRuleId)
+			}
+		})
+	common.PrintVerifications(verifications, "security group rules")
+	result.RecordVerifications(verifications)
+
+	return result, nil
 }
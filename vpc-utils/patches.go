@@ -0,0 +1,57 @@
+package vpcutils
+
+import (
+	"reflect"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+// denyAllEgress stands in for "no egress rules", the same way the CDK does
+// for allowAllOutbound: false. CloudFormation gives a security group with no
+// SecurityGroupEgress property a rule allowing all outbound traffic.
+var denyAllEgress = []any{map[string]any{
+	"CidrIp":      "255.255.255.255/32",
+	"Description": "Disallow all traffic",
+	"FromPort":    252,
+	"IpProtocol":  "icmp",
+	"ToPort":      86,
+}}
+
+// RemoveRulesPatch proposes the template changes that remove every rule from
+// a stack-managed security group, both inline and as separate resources.
+func RemoveRulesPatch(template common.StackTemplate, logicalId string) []common.PatchOp {
+	var ops []common.PatchOp
+	properties := template.Properties(logicalId)
+
+	if _, exists := properties["SecurityGroupIngress"]; exists {
+		ops = append(ops, common.PatchOp{
+			Op:   "remove",
+			Path: common.ResourcePointer(logicalId, "Properties", "SecurityGroupIngress"),
+		})
+	}
+
+	egress, exists := properties["SecurityGroupEgress"]
+	if !exists {
+		ops = append(ops, common.PatchOp{
+			Op:    "add",
+			Path:  common.ResourcePointer(logicalId, "Properties", "SecurityGroupEgress"),
+			Value: denyAllEgress,
+		})
+	} else if !reflect.DeepEqual(egress, denyAllEgress) {
+		ops = append(ops, common.PatchOp{
+			Op:    "replace",
+			Path:  common.ResourcePointer(logicalId, "Properties", "SecurityGroupEgress"),
+			Value: denyAllEgress,
+		})
+	}
+
+	for _, resourceType := range []string{"AWS::EC2::SecurityGroupIngress", "AWS::EC2::SecurityGroupEgress"} {
+		for _, ruleId := range template.ResourcesWithProperty(resourceType, "GroupId", logicalId) {
+			ops = append(ops, common.PatchOp{
+				Op:   "remove",
+				Path: common.ResourcePointer(ruleId),
+			})
+		}
+	}
+	return ops
+}
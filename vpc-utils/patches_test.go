@@ -0,0 +1,50 @@
+package vpcutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guardian/fsbp-tools/fsbp-fix/common"
+)
+
+func TestRemoveRulesPatch(t *testing.T) {
+	template, err := common.ParseTemplate(`
+Resources:
+  Group:
+    Type: AWS::EC2::SecurityGroup
+    Properties:
+      GroupDescription: Example
+      SecurityGroupIngress:
+        - CidrIp: 0.0.0.0/0
+          IpProtocol: tcp
+          FromPort: 443
+          ToPort: 443
+  Egress:
+    Type: AWS::EC2::SecurityGroupEgress
+    Properties:
+      GroupId: !GetAtt Group.GroupId
+      CidrIp: 0.0.0.0/0
+      IpProtocol: "-1"
+`)
+	if err != nil {
+		t.Fatalf("Error parsing template: %v", err)
+	}
+
+	result := RemoveRulesPatch(template, "Group")
+	expected := []common.PatchOp{
+		{Op: "remove", Path: "/Resources/Group/Properties/SecurityGroupIngress"},
+		{Op: "add", Path: "/Resources/Group/Properties/SecurityGroupEgress", Value: denyAllEgress},
+		{Op: "remove", Path: "/Resources/Egress"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Error patching security group. Expected %v, got %v", expected, result)
+	}
+}
+
+func TestRemoveRulesPatchOfGroupWithoutRules(t *testing.T) {
+	template, _ := common.ParseTemplate(`{"Resources": {"Group": {"Type": "AWS::EC2::SecurityGroup", "Properties": {"GroupDescription": "Example"}}}}`)
+	result := RemoveRulesPatch(template, "Group")
+	if len(result) != 1 || result[0].Op != "add" {
+		t.Errorf("Expected only the deny-all egress rule to be added. Got %v", result)
+	}
+}
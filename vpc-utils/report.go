@@ -0,0 +1,100 @@
+package vpcutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	OutputTable = "table"
+	OutputJson  = "json"
+)
+
+func ValidateOutput(output string) error {
+	if output != OutputTable && output != OutputJson {
+		return fmt.Errorf("unknown output %q. Expected %s or %s", output, OutputTable, OutputJson)
+	}
+	return nil
+}
+
+func (r securityGroupRule) allTraffic() bool {
+	return r.IpProtocol == "-1"
+}
+
+// Protocol names the rule's protocol, e.g. tcp, or "all".
+func (r securityGroupRule) Protocol() string {
+	if r.allTraffic() {
+		return "all"
+	}
+	return r.IpProtocol
+}
+
+// Ports describes the rule's port range, e.g. 443 or 1024-65535. For ICMP,
+// these are the type and code.
+func (r securityGroupRule) Ports() string {
+	if r.allTraffic() || r.FromPort == nil || r.ToPort == nil || (*r.FromPort == -1 && *r.ToPort == -1) {
+		return "all"
+	}
+	if *r.FromPort == *r.ToPort {
+		return fmt.Sprint(*r.FromPort)
+	}
+	return fmt.Sprintf("%d-%d", *r.FromPort, *r.ToPort)
+}
+
+// Peer describes where traffic is allowed from, for ingress, or to, for egress.
+func (r securityGroupRule) Peer() string {
+	switch {
+	case r.CidrIpv4 != "":
+		return r.CidrIpv4
+	case r.CidrIpv6 != "":
+		return r.CidrIpv6
+	case r.PrefixListId != "":
+		return r.PrefixListId
+	case r.ReferencedGroupId != "" && r.ReferencedGroupAccount != "":
+		return r.ReferencedGroupAccount + "/" + r.ReferencedGroupId
+	case r.ReferencedGroupId != "":
+		return r.ReferencedGroupId
+	}
+	return "unknown"
+}
+
+func formatTags(tags map[string]string) string {
+	var pairs []string
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, key+"="+tags[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func printRuleTable(w io.Writer, rules []ruleDetails) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintln(tw, "Security Group\tVPC Name\tVPC ID\tRule Id\tDirection\tProtocol\tPorts\tPeer\tDescription\tTags")
+	for _, sg := range rules {
+		rule := sg.Rule
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", sg.SecurityGroup, sg.VpcDetails.VpcName, sg.VpcDetails.VpcId, rule.GroupRuleId, rule.Direction, rule.Protocol(), rule.Ports(), rule.Peer(), rule.Description, formatTags(rule.Tags))
+	}
+	return tw.Flush()
+}
+
+// jsonRule is a rule as written by -output json.
+type jsonRule struct {
+	Region string `json:"region"`
+	ruleDetails
+}
+
+func printRuleJson(w io.Writer, unusedSgRules []SecurityGroupRuleDetails) error {
+	rules := []jsonRule{}
+	for _, details := range unusedSgRules {
+		for _, rule := range details.Groups {
+			rules = append(rules, jsonRule{Region: details.Region, ruleDetails: rule})
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rules)
+}
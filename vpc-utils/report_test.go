@@ -0,0 +1,75 @@
+package vpcutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestRuleSummaries(t *testing.T) {
+	cases := []struct {
+		name                  string
+		rule                  securityGroupRule
+		protocol, ports, peer string
+	}{
+		{"all traffic", securityGroupRule{IpProtocol: "-1", FromPort: aws.Int32(-1), ToPort: aws.Int32(-1), CidrIpv4: "0.0.0.0/0"}, "all", "all", "0.0.0.0/0"},
+		{"single port", securityGroupRule{IpProtocol: "tcp", FromPort: aws.Int32(443), ToPort: aws.Int32(443), CidrIpv6: "::/0"}, "tcp", "443", "::/0"},
+		{"port range", securityGroupRule{IpProtocol: "udp", FromPort: aws.Int32(1024), ToPort: aws.Int32(65535), PrefixListId: "pl-1"}, "udp", "1024-65535", "pl-1"},
+		{"no ports", securityGroupRule{IpProtocol: "tcp", ReferencedGroupId: "sg-1"}, "tcp", "all", "sg-1"},
+		{"other account", securityGroupRule{IpProtocol: "tcp", FromPort: aws.Int32(22), ToPort: aws.Int32(22), ReferencedGroupId: "sg-1", ReferencedGroupAccount: "123456789012"}, "tcp", "22", "123456789012/sg-1"},
+	}
+	for _, c := range cases {
+		if got := c.rule.Protocol(); got != c.protocol {
+			t.Errorf("Error describing protocol of %s. Expected %s, got %s", c.name, c.protocol, got)
+		}
+		if got := c.rule.Ports(); got != c.ports {
+			t.Errorf("Error describing ports of %s. Expected %s, got %s", c.name, c.ports, got)
+		}
+		if got := c.rule.Peer(); got != c.peer {
+			t.Errorf("Error describing peer of %s. Expected %s, got %s", c.name, c.peer, got)
+		}
+	}
+}
+
+func TestToSecurityGroupRuleHandlesMissingFields(t *testing.T) {
+	rule := toSecurityGroupRule(types.SecurityGroupRule{
+		SecurityGroupRuleId: aws.String("sgr-1"),
+		IpProtocol:          aws.String("-1"),
+		ReferencedGroupInfo: &types.ReferencedSecurityGroup{GroupId: aws.String("sg-2"), UserId: aws.String("123456789012")},
+		Tags:                []types.Tag{{Key: aws.String("Name"), Value: aws.String("web")}},
+	})
+	if rule.Direction != "ingress" || rule.Ports() != "all" || rule.Peer() != "123456789012/sg-2" || rule.Tags["Name"] != "web" {
+		t.Errorf("Error converting rule. Got %+v", rule)
+	}
+}
+
+func TestPrintRuleTable(t *testing.T) {
+	var out bytes.Buffer
+	err := printRuleTable(&out, []ruleDetails{{
+		SecurityGroup: "sg-1",
+		Rule:          securityGroupRule{GroupRuleId: "sgr-1", IpProtocol: "-1", CidrIpv4: "0.0.0.0/0", Description: "Allow all", Tags: map[string]string{"b": "2", "a": "1"}},
+	}})
+	if err != nil || !strings.Contains(out.String(), "Allow all") || !strings.Contains(out.String(), "a=1,b=2") {
+		t.Errorf("Error printing rule table. Got %q, %v", out.String(), err)
+	}
+}
+
+func TestPrintRuleJson(t *testing.T) {
+	var out bytes.Buffer
+	err := printRuleJson(&out, []SecurityGroupRuleDetails{{
+		Region: "eu-west-1",
+		Groups: []ruleDetails{{SecurityGroup: "sg-1", Rule: securityGroupRule{GroupRuleId: "sgr-1", IpProtocol: "tcp", FromPort: aws.Int32(22), ToPort: aws.Int32(22)}}},
+	}})
+	var rules []map[string]any
+	if err != nil || json.Unmarshal(out.Bytes(), &rules) != nil || len(rules) != 1 {
+		t.Fatalf("Error printing rule JSON. Got %q, %v", out.String(), err)
+	}
+	rule, _ := rules[0]["rule"].(map[string]any)
+	if rules[0]["region"] != "eu-west-1" || rules[0]["securityGroup"] != "sg-1" || rule["fromPort"] != 22.0 {
+		t.Errorf("Error printing rule JSON. Got %v", rules[0])
+	}
+}